@@ -0,0 +1,52 @@
+package amenity
+
+import (
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/repositories/amenities"
+	"net/http"
+)
+
+type Handlers struct {
+	Amenities amenities.Repo
+}
+
+type CategoryGroup struct {
+	Category  string              `json:"category"`
+	Amenities []amenities.Amenity `json:"amenities"`
+}
+
+// ListAmenities returns the amenity catalog grouped by category, in catalog order
+func (handler Handlers) ListAmenities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use GET")
+		return
+	}
+
+	items, err := handler.Amenities.List(r.Context())
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not list amenities")
+		return
+	}
+
+	groups := make([]CategoryGroup, 0)
+	index := make(map[string]int)
+
+	for _, item := range items {
+		category := "other"
+		if item.Category != nil && *item.Category != "" {
+			category = *item.Category
+		}
+
+		i, ok := index[category]
+		if !ok {
+			i = len(groups)
+			index[category] = i
+			groups = append(groups, CategoryGroup{Category: category})
+		}
+		groups[i].Amenities = append(groups[i].Amenities, item)
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"categories": groups,
+	})
+}
@@ -3,26 +3,44 @@ package app
 import (
 	"context"
 	"errors"
+	"expvar"
+	"go-react-rooms/internal/amenity"
 	"go-react-rooms/internal/auth"
 	"go-react-rooms/internal/auth/routes"
 	"go-react-rooms/internal/cache"
 	"go-react-rooms/internal/chat"
 	"go-react-rooms/internal/config"
+	"go-react-rooms/internal/contact"
 	"go-react-rooms/internal/db"
 	"go-react-rooms/internal/debug"
 	"go-react-rooms/internal/functions"
 	"go-react-rooms/internal/health"
 	"go-react-rooms/internal/httpserver"
 	"go-react-rooms/internal/listing"
+	"go-react-rooms/internal/mailer"
 	"go-react-rooms/internal/middleware"
+	"go-react-rooms/internal/moderation"
+	"go-react-rooms/internal/notify"
+	"go-react-rooms/internal/repositories/amenities"
+	"go-react-rooms/internal/repositories/contact_requests"
+	"go-react-rooms/internal/repositories/listing_flags"
 	"go-react-rooms/internal/repositories/listing_images"
 	"go-react-rooms/internal/repositories/listings"
 	"go-react-rooms/internal/repositories/messages"
+	"go-react-rooms/internal/repositories/notifications"
+	"go-react-rooms/internal/repositories/reports"
+	"go-react-rooms/internal/repositories/roommate_preferences"
 	"go-react-rooms/internal/repositories/rooms"
+	"go-react-rooms/internal/repositories/saved_listings"
+	"go-react-rooms/internal/repositories/saved_searches"
 	"go-react-rooms/internal/repositories/users"
+	"go-react-rooms/internal/roommate"
+	"go-react-rooms/internal/savedsearch"
+	"go-react-rooms/internal/scheduler"
 	"go-react-rooms/internal/security"
 	"go-react-rooms/internal/storage"
 	"go-react-rooms/internal/ws"
+	"log"
 	"net/http"
 	"time"
 )
@@ -32,6 +50,8 @@ type App struct {
 	DB      *db.Postgres
 	Redis   *cache.Redis
 	Handler http.Handler
+	// stops background jobs started by New
+	stopBackground context.CancelFunc
 }
 
 func New(cfg config.Config) (*App, error) {
@@ -75,6 +95,18 @@ func New(cfg config.Config) (*App, error) {
 		DB: pg.DB,
 	}
 	sessionStore := auth.NewSessionStore(rd.Client)
+	// suspension markers only live in redis, bring them in line with the database in case redis lost or kept stale ones
+	if err := sessionStore.SyncSuspended(context.Background(), userRepo.ListSuspendedIDs); err != nil {
+		log.Printf("sync suspended users: %v", err)
+	}
+
+	// expvar metrics, memstats and the command line are for admins only
+	var debugVarsHandler http.Handler
+	debugVarsHandler = expvar.Handler()
+	debugVarsHandler = middleware.RequireAdmin(userRepo.IsAdmin, debugVarsHandler)
+	debugVarsHandler = middleware.RequireAuth(sessionStore, debugVarsHandler)
+	mux.Handle("/debug/vars", debugVarsHandler)
+
 	authHandler := auth.Handlers{
 		Users:    userRepo,
 		Sessions: sessionStore,
@@ -113,22 +145,69 @@ func New(cfg config.Config) (*App, error) {
 	listingRepo := listings.Repo{
 		DB: pg.DB,
 	}
+	hub := ws.NewHub(rd.Client)
+	go hub.Run()
 	roomHandler := chat.Handlers{
 		Rooms:    roomRepo,
 		Messages: messagesRepo,
+		Hub:      hub,
 	}
 	listingImagesRepo := listing_images.Repo{
 		DB: pg.DB,
 	}
+	listingFlagsRepo := listing_flags.Repo{
+		DB: pg.DB,
+	}
+	reportsRepo := reports.Repo{
+		DB: pg.DB,
+	}
+	notificationsRepo := notifications.Repo{
+		DB: pg.DB,
+	}
+	amenitiesRepo := amenities.Repo{
+		DB: pg.DB,
+	}
+	savedListingsRepo := saved_listings.Repo{
+		DB: pg.DB,
+	}
+	contactRequestsRepo := contact_requests.Repo{
+		DB: pg.DB,
+	}
+	roommatePreferencesRepo := roommate_preferences.Repo{
+		DB: pg.DB,
+	}
+	savedSearchesRepo := saved_searches.Repo{
+		DB: pg.DB,
+	}
 	ctx := context.Background()
-	s3Storage, err := storage.NewS3Storage(ctx)
+	objectStore, err := storage.NewObjectStore(ctx, storage.Options{
+		Driver:        cfg.StorageDriver,
+		LocalDir:      cfg.StorageLocalDir,
+		PublicURL:     cfg.StoragePublicURL,
+		SigningSecret: cfg.StorageSigningSecret,
+		MaxUploadSize: cfg.StorageMaxUploadBytes,
+	})
 	if err != nil {
 		return nil, err
 	}
+	urlSigner := storage.NewURLSigner(objectStore, rd.Client, cfg.StorageCDNBaseURL)
 	listingHandler := listing.Handler{
-		Listings:      listingRepo,
-		ListingImages: listingImagesRepo,
-		S3:            s3Storage,
+		Listings:            listingRepo,
+		ListingImages:       listingImagesRepo,
+		Amenities:           amenitiesRepo,
+		SavedListings:       savedListingsRepo,
+		Notifications:       notificationsRepo,
+		RoommatePreferences: roommatePreferencesRepo,
+		Flags:               listingFlagsRepo,
+		SearchAlerts: savedsearch.Matcher{
+			SavedSearches: savedSearchesRepo,
+			Amenities:     amenitiesRepo,
+			Notifications: notificationsRepo,
+		},
+		Storage:       objectStore,
+		URLs:          urlSigner,
+		UploadSlots:   storage.NewUploadSlots(rd.Client),
+		MaxUploadSize: cfg.StorageMaxUploadBytes,
 		DB:            pg.DB,
 	}
 	roomsHandler := middleware.RequireAuth(sessionStore, http.HandlerFunc(roomHandler.HandleRooms))
@@ -147,6 +226,42 @@ func New(cfg config.Config) (*App, error) {
 	listMessagesHandler = middleware.RequireAuth(sessionStore, listMessagesHandler)
 	mux.Handle("/rooms/messages", listMessagesHandler)
 
+	// edit/delete a message (edits are the sender's, deletes also the room owner's and moderators')
+	var messageHandler http.Handler
+	messageHandler = http.HandlerFunc(roomHandler.HandleMessage)
+	messageHandler = middleware.RequireAuth(sessionStore, messageHandler)
+	messageHandler = security.CSRFMiddleware(messageHandler)
+	messageHandler = security.BodyLimit(1<<20, messageHandler)
+	mux.Handle("/rooms/messages/{id}", messageHandler)
+
+	// edit history of a message
+	var messageHistoryHandler http.Handler
+	messageHistoryHandler = http.HandlerFunc(roomHandler.MessageHistory)
+	messageHistoryHandler = middleware.RequireAuth(sessionStore, messageHistoryHandler)
+	mux.Handle("/rooms/messages/{id}/history", messageHistoryHandler)
+
+	// thread view, replies to a message
+	var listRepliesHandler http.Handler
+	listRepliesHandler = http.HandlerFunc(roomHandler.ListReplies)
+	listRepliesHandler = middleware.RequireAuth(sessionStore, listRepliesHandler)
+	mux.Handle("/rooms/messages/{id}/replies", listRepliesHandler)
+
+	// room owner makes members moderators
+	var memberRoleHandler http.Handler
+	memberRoleHandler = http.HandlerFunc(roomHandler.SetMemberRole)
+	memberRoleHandler = middleware.RequireAuth(sessionStore, memberRoleHandler)
+	memberRoleHandler = security.CSRFMiddleware(memberRoleHandler)
+	memberRoleHandler = security.BodyLimit(1<<20, memberRoleHandler)
+	mux.Handle("/rooms/{id}/members/role", memberRoleHandler)
+
+	// mark a room read up to a message
+	var markRoomReadHandler http.Handler
+	markRoomReadHandler = http.HandlerFunc(roomHandler.MarkRead)
+	markRoomReadHandler = middleware.RequireAuth(sessionStore, markRoomReadHandler)
+	markRoomReadHandler = security.CSRFMiddleware(markRoomReadHandler)
+	markRoomReadHandler = security.BodyLimit(1<<20, markRoomReadHandler)
+	mux.Handle("/rooms/read", markRoomReadHandler)
+
 	// create listing
 	var createListingHandler http.Handler
 	createListingHandler = http.HandlerFunc(listingHandler.CreateListing)
@@ -158,22 +273,325 @@ func New(cfg config.Config) (*App, error) {
 	// get all listings
 	var listListingsHandler http.Handler
 	listListingsHandler = http.HandlerFunc(listingHandler.ListListings)
+	listListingsHandler = middleware.OptionalAuth(sessionStore, listListingsHandler)
 	listListingsHandler = security.CSRFMiddleware(listListingsHandler)
 	mux.Handle("/listings", listListingsHandler)
 
+	// listings around a point or inside a map viewport
+	var listingsMapHandler http.Handler
+	listingsMapHandler = http.HandlerFunc(listingHandler.ListingsMap)
+	listingsMapHandler = middleware.OptionalAuth(sessionStore, listingsMapHandler)
+	listingsMapHandler = security.CSRFMiddleware(listingsMapHandler)
+	mux.Handle("/listings/map", listingsMapHandler)
+
+	// get/update/delete a listing (updates and deletes are restricted to the owner)
+	var listingDetailHandler http.Handler
+	listingDetailHandler = http.HandlerFunc(listingHandler.HandleListing)
+	listingDetailHandler = middleware.OptionalAuth(sessionStore, listingDetailHandler)
+	listingDetailHandler = security.CSRFMiddleware(listingDetailHandler)
+	listingDetailHandler = security.BodyLimit(1<<20, listingDetailHandler)
+	mux.Handle("/listings/{id}", listingDetailHandler)
+
+	// presigned upload slots for listing images, the browser uploads straight to storage
+	var imageUploadsHandler http.Handler
+	imageUploadsHandler = http.HandlerFunc(listingHandler.CreateImageUploads)
+	imageUploadsHandler = middleware.RequireAuth(sessionStore, imageUploadsHandler)
+	imageUploadsHandler = security.CSRFMiddleware(imageUploadsHandler)
+	imageUploadsHandler = security.BodyLimit(1<<20, imageUploadsHandler)
+	mux.Handle("/listings/{id}/images/uploads", imageUploadsHandler)
+
+	// record finished direct uploads as listing images
+	var completeImageUploadsHandler http.Handler
+	completeImageUploadsHandler = http.HandlerFunc(listingHandler.CompleteImageUploads)
+	completeImageUploadsHandler = middleware.RequireAuth(sessionStore, completeImageUploadsHandler)
+	completeImageUploadsHandler = security.CSRFMiddleware(completeImageUploadsHandler)
+	completeImageUploadsHandler = security.BodyLimit(1<<20, completeImageUploadsHandler)
+	mux.Handle("/listings/{id}/images/complete", completeImageUploadsHandler)
+
+	// reorder the images of a listing
+	var reorderImagesHandler http.Handler
+	reorderImagesHandler = http.HandlerFunc(listingHandler.ReorderImages)
+	reorderImagesHandler = middleware.RequireAuth(sessionStore, reorderImagesHandler)
+	reorderImagesHandler = security.CSRFMiddleware(reorderImagesHandler)
+	reorderImagesHandler = security.BodyLimit(1<<20, reorderImagesHandler)
+	mux.Handle("/listings/{id}/images/order", reorderImagesHandler)
+
+	// edit the alt text of or delete one listing image
+	var listingImageHandler http.Handler
+	listingImageHandler = http.HandlerFunc(listingHandler.HandleImage)
+	listingImageHandler = middleware.RequireAuth(sessionStore, listingImageHandler)
+	listingImageHandler = security.CSRFMiddleware(listingImageHandler)
+	listingImageHandler = security.BodyLimit(1<<20, listingImageHandler)
+	mux.Handle("/listings/{id}/images/{imageId}", listingImageHandler)
+
+	// make one image the listing thumbnail
+	var setThumbnailHandler http.Handler
+	setThumbnailHandler = http.HandlerFunc(listingHandler.SetThumbnail)
+	setThumbnailHandler = middleware.RequireAuth(sessionStore, setThumbnailHandler)
+	setThumbnailHandler = security.CSRFMiddleware(setThumbnailHandler)
+	mux.Handle("/listings/{id}/images/{imageId}/thumbnail", setThumbnailHandler)
+
+	// publish a listing
+	var publishListingHandler http.Handler
+	publishListingHandler = http.HandlerFunc(listingHandler.PublishListing)
+	publishListingHandler = middleware.RequireAuth(sessionStore, publishListingHandler)
+	publishListingHandler = security.CSRFMiddleware(publishListingHandler)
+	mux.Handle("/listings/{id}/publish", publishListingHandler)
+
+	// unpublish a listing
+	var unpublishListingHandler http.Handler
+	unpublishListingHandler = http.HandlerFunc(listingHandler.UnpublishListing)
+	unpublishListingHandler = middleware.RequireAuth(sessionStore, unpublishListingHandler)
+	unpublishListingHandler = security.CSRFMiddleware(unpublishListingHandler)
+	mux.Handle("/listings/{id}/unpublish", unpublishListingHandler)
+
+	// mark a listing as rented
+	var markListingRentedHandler http.Handler
+	markListingRentedHandler = http.HandlerFunc(listingHandler.MarkListingRented)
+	markListingRentedHandler = middleware.RequireAuth(sessionStore, markListingRentedHandler)
+	markListingRentedHandler = security.CSRFMiddleware(markListingRentedHandler)
+	mux.Handle("/listings/{id}/mark-rented", markListingRentedHandler)
+
+	// archive a listing
+	var archiveListingHandler http.Handler
+	archiveListingHandler = http.HandlerFunc(listingHandler.ArchiveListing)
+	archiveListingHandler = middleware.RequireAuth(sessionStore, archiveListingHandler)
+	archiveListingHandler = security.CSRFMiddleware(archiveListingHandler)
+	mux.Handle("/listings/{id}/archive", archiveListingHandler)
+
+	// renew an active listing so it does not expire as stale
+	var renewListingHandler http.Handler
+	renewListingHandler = http.HandlerFunc(listingHandler.RenewListing)
+	renewListingHandler = middleware.RequireAuth(sessionStore, renewListingHandler)
+	renewListingHandler = security.CSRFMiddleware(renewListingHandler)
+	mux.Handle("/listings/{id}/renew", renewListingHandler)
+
+	// listing status history (owner only)
+	var listingHistoryHandler http.Handler
+	listingHistoryHandler = http.HandlerFunc(listingHandler.ListingStatusHistory)
+	listingHistoryHandler = middleware.RequireAuth(sessionStore, listingHistoryHandler)
+	mux.Handle("/listings/{id}/history", listingHistoryHandler)
+
+	// save/unsave a listing
+	var saveListingHandler http.Handler
+	saveListingHandler = http.HandlerFunc(listingHandler.HandleSave)
+	saveListingHandler = middleware.RequireAuth(sessionStore, saveListingHandler)
+	saveListingHandler = security.CSRFMiddleware(saveListingHandler)
+	mux.Handle("/listings/{id}/save", saveListingHandler)
+
+	// list saved listings
+	var savedListingsHandler http.Handler
+	savedListingsHandler = http.HandlerFunc(listingHandler.ListSavedListings)
+	savedListingsHandler = middleware.RequireAuth(sessionStore, savedListingsHandler)
+	mux.Handle("/saved-listings", savedListingsHandler)
+
+	// contact requests between seekers and listing owners
+	contactHandler := contact.Handlers{
+		ContactRequests: contactRequestsRepo,
+		Rooms:           roomRepo,
+		Notifications:   notificationsRepo,
+		DB:              pg.DB,
+	}
+
+	var contactListingHandler http.Handler
+	contactListingHandler = http.HandlerFunc(contactHandler.CreateRequest)
+	contactListingHandler = middleware.RequireAuth(sessionStore, contactListingHandler)
+	contactListingHandler = security.CSRFMiddleware(contactListingHandler)
+	contactListingHandler = security.BodyLimit(1<<20, contactListingHandler)
+	mux.Handle("/listings/{id}/contact", contactListingHandler)
+
+	var contactRequestsHandler http.Handler
+	contactRequestsHandler = http.HandlerFunc(contactHandler.ListRequests)
+	contactRequestsHandler = middleware.RequireAuth(sessionStore, contactRequestsHandler)
+	mux.Handle("/contact-requests", contactRequestsHandler)
+
+	var acceptContactHandler http.Handler
+	acceptContactHandler = http.HandlerFunc(contactHandler.AcceptRequest)
+	acceptContactHandler = middleware.RequireAuth(sessionStore, acceptContactHandler)
+	acceptContactHandler = security.CSRFMiddleware(acceptContactHandler)
+	mux.Handle("/contact-requests/{id}/accept", acceptContactHandler)
+
+	var declineContactHandler http.Handler
+	declineContactHandler = http.HandlerFunc(contactHandler.DeclineRequest)
+	declineContactHandler = middleware.RequireAuth(sessionStore, declineContactHandler)
+	declineContactHandler = security.CSRFMiddleware(declineContactHandler)
+	mux.Handle("/contact-requests/{id}/decline", declineContactHandler)
+
+	var closeContactHandler http.Handler
+	closeContactHandler = http.HandlerFunc(contactHandler.CloseRequest)
+	closeContactHandler = middleware.RequireAuth(sessionStore, closeContactHandler)
+	closeContactHandler = security.CSRFMiddleware(closeContactHandler)
+	mux.Handle("/contact-requests/{id}/close", closeContactHandler)
+
+	// roommate preferences of the logged in user
+	roommateHandler := roommate.Handlers{
+		Preferences: roommatePreferencesRepo,
+		Amenities:   amenitiesRepo,
+		DB:          pg.DB,
+	}
+	var roommatePreferencesHandler http.Handler
+	roommatePreferencesHandler = http.HandlerFunc(roommateHandler.HandlePreferences)
+	roommatePreferencesHandler = middleware.RequireAuth(sessionStore, roommatePreferencesHandler)
+	roommatePreferencesHandler = security.CSRFMiddleware(roommatePreferencesHandler)
+	roommatePreferencesHandler = security.BodyLimit(1<<20, roommatePreferencesHandler)
+	mux.Handle("/roommate-preferences", roommatePreferencesHandler)
+
+	// roommates ranked by compatibility with the caller's preferences
+	var recommendedRoommatesHandler http.Handler
+	recommendedRoommatesHandler = http.HandlerFunc(roommateHandler.RecommendedRoommates)
+	recommendedRoommatesHandler = middleware.RequireAuth(sessionStore, recommendedRoommatesHandler)
+	mux.Handle("/roommates/recommended", recommendedRoommatesHandler)
+
+	// listings ranked against the caller's roommate preferences
+	var recommendedListingsHandler http.Handler
+	recommendedListingsHandler = http.HandlerFunc(listingHandler.RecommendedListings)
+	recommendedListingsHandler = middleware.RequireAuth(sessionStore, recommendedListingsHandler)
+	mux.Handle("/listings/recommended", recommendedListingsHandler)
+
+	// saved searches with new listing alerts
+	savedSearchHandler := savedsearch.Handlers{
+		SavedSearches: savedSearchesRepo,
+		Amenities:     amenitiesRepo,
+	}
+
+	var savedSearchesHandler http.Handler
+	savedSearchesHandler = http.HandlerFunc(savedSearchHandler.HandleSavedSearches)
+	savedSearchesHandler = middleware.RequireAuth(sessionStore, savedSearchesHandler)
+	savedSearchesHandler = security.CSRFMiddleware(savedSearchesHandler)
+	savedSearchesHandler = security.BodyLimit(1<<20, savedSearchesHandler)
+	mux.Handle("/saved-searches", savedSearchesHandler)
+
+	var savedSearchItemHandler http.Handler
+	savedSearchItemHandler = http.HandlerFunc(savedSearchHandler.HandleSavedSearch)
+	savedSearchItemHandler = middleware.RequireAuth(sessionStore, savedSearchItemHandler)
+	savedSearchItemHandler = security.CSRFMiddleware(savedSearchItemHandler)
+	savedSearchItemHandler = security.BodyLimit(1<<20, savedSearchItemHandler)
+	mux.Handle("/saved-searches/{id}", savedSearchItemHandler)
+
+	// amenity catalog
+	amenityHandler := amenity.Handlers{
+		Amenities: amenitiesRepo,
+	}
+	mux.HandleFunc("/amenities", amenityHandler.ListAmenities)
+
 	// get image/view URL
-	uploadHandler := storage.NewUploadHandler(s3Storage)
+	uploadHandler := storage.NewUploadHandler(urlSigner)
 	var getImageHandler http.Handler
 	getImageHandler = http.HandlerFunc(uploadHandler.GetImageURL)
 	getImageHandler = security.CSRFMiddleware(getImageHandler)
 	mux.Handle("/images/url", getImageHandler)
 
+	// local object storage, the signed URL is the authorization so no session or CSRF check
+	if localStore, ok := objectStore.(*storage.LocalStorage); ok {
+		mux.Handle(storage.LocalRoutePrefix+"{key...}", localStore)
+	}
+
+	// notifications
+	notifyHandler := notify.Handlers{
+		Notifications: notificationsRepo,
+	}
+	var listNotificationsHandler http.Handler
+	listNotificationsHandler = http.HandlerFunc(notifyHandler.ListNotifications)
+	listNotificationsHandler = middleware.RequireAuth(sessionStore, listNotificationsHandler)
+	mux.Handle("/notifications", listNotificationsHandler)
+
+	var markNotificationsReadHandler http.Handler
+	markNotificationsReadHandler = http.HandlerFunc(notifyHandler.MarkRead)
+	markNotificationsReadHandler = middleware.RequireAuth(sessionStore, markNotificationsReadHandler)
+	markNotificationsReadHandler = security.CSRFMiddleware(markNotificationsReadHandler)
+	mux.Handle("/notifications/read", markNotificationsReadHandler)
+
 	// websockets
-	hub := ws.NewHub()
-	go hub.Run()
-	wsHandler := ws.NewHandler(hub, sessionStore, roomRepo, messagesRepo)
+	wsHandler := ws.NewHandler(hub, sessionStore, ws.NewPresence(rd.Client), roomRepo, messagesRepo)
 	mux.Handle("/ws", wsHandler)
 
+	// reports and the admin moderation queue
+	moderationHandler := moderation.Handlers{
+		Reports:  reportsRepo,
+		Flags:    listingFlagsRepo,
+		Listings: listingRepo,
+		Messages: messagesRepo,
+		Rooms:    roomRepo,
+		Users:    userRepo,
+		Sessions: sessionStore,
+		Hub:      hub,
+		DB:       pg.DB,
+	}
+
+	var createReportHandler http.Handler
+	createReportHandler = http.HandlerFunc(moderationHandler.CreateReport)
+	createReportHandler = middleware.RequireAuth(sessionStore, createReportHandler)
+	createReportHandler = security.CSRFMiddleware(createReportHandler)
+	createReportHandler = security.RateLimitMiddleware(rateLimiter, "reports", 20, time.Hour, createReportHandler)
+	createReportHandler = security.BodyLimit(1<<20, createReportHandler)
+	mux.Handle("/reports", createReportHandler)
+
+	var listReportsHandler http.Handler
+	listReportsHandler = http.HandlerFunc(moderationHandler.ListReports)
+	listReportsHandler = middleware.RequireAuth(sessionStore, listReportsHandler)
+	mux.Handle("/admin/reports", listReportsHandler)
+
+	var reportActionHandler http.Handler
+	reportActionHandler = http.HandlerFunc(moderationHandler.ActOnReport)
+	reportActionHandler = middleware.RequireAuth(sessionStore, reportActionHandler)
+	reportActionHandler = security.CSRFMiddleware(reportActionHandler)
+	reportActionHandler = security.BodyLimit(1<<20, reportActionHandler)
+	mux.Handle("/admin/reports/{id}/actions", reportActionHandler)
+
+	var listListingFlagsHandler http.Handler
+	listListingFlagsHandler = http.HandlerFunc(moderationHandler.ListListingFlags)
+	listListingFlagsHandler = middleware.RequireAuth(sessionStore, listListingFlagsHandler)
+	mux.Handle("/admin/listing-flags", listListingFlagsHandler)
+
+	var resolveListingFlagHandler http.Handler
+	resolveListingFlagHandler = http.HandlerFunc(moderationHandler.ResolveListingFlag)
+	resolveListingFlagHandler = middleware.RequireAuth(sessionStore, resolveListingFlagHandler)
+	resolveListingFlagHandler = security.CSRFMiddleware(resolveListingFlagHandler)
+	resolveListingFlagHandler = security.BodyLimit(1<<20, resolveListingFlagHandler)
+	mux.Handle("/admin/listing-flags/{id}/resolve", resolveListingFlagHandler)
+
+	var unsuspendUserHandler http.Handler
+	unsuspendUserHandler = http.HandlerFunc(moderationHandler.UnsuspendUser)
+	unsuspendUserHandler = middleware.RequireAuth(sessionStore, unsuspendUserHandler)
+	unsuspendUserHandler = security.CSRFMiddleware(unsuspendUserHandler)
+	mux.Handle("/admin/users/{id}/unsuspend", unsuspendUserHandler)
+
+	// background jobs, locked in redis so only one replica runs each tick
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	if cfg.SchedulerEnabled {
+		expiryJob := listing.ExpiryJob{
+			Listings:      listingRepo,
+			Notifications: notificationsRepo,
+			StaleAfter:    time.Duration(cfg.ListingStaleDays) * 24 * time.Hour,
+			ReminderLead:  time.Duration(cfg.ListingReminderDays) * 24 * time.Hour,
+		}
+		mail, err := mailer.New(cfg.MailDriver, cfg.MailFrom)
+		if err != nil {
+			stopBackground()
+			return nil, err
+		}
+		digestJob := savedsearch.DigestJob{
+			SavedSearches: savedSearchesRepo,
+			Mailer:        mail,
+			AppURL:        cfg.AppURL,
+		}
+		objectGCJob := listing.ObjectGCJob{
+			ListingImages: listingImagesRepo,
+			Storage:       objectStore,
+			GracePeriod:   time.Duration(cfg.ObjectGCGraceHours) * time.Hour,
+			DryRun:        cfg.ObjectGCDryRun,
+		}
+		jobs := scheduler.NewScheduler(rd.Client,
+			scheduler.Job{Name: "listing-expiry", Interval: 15 * time.Minute, Run: expiryJob.Run},
+			scheduler.Job{Name: "saved-search-digest", Interval: time.Duration(cfg.SavedSearchDigestHours) * time.Hour, Run: digestJob.Run},
+			scheduler.Job{Name: "object-gc", Interval: time.Duration(cfg.ObjectGCIntervalHours) * time.Hour, Run: objectGCJob.Run},
+			scheduler.Job{Name: "suspension-sync", Interval: 10 * time.Minute, Run: func(ctx context.Context) error {
+				return sessionStore.SyncSuspended(ctx, userRepo.ListSuspendedIDs)
+			}},
+		)
+		go jobs.Run(backgroundCtx)
+	}
+
 	var handler http.Handler = mux
 	handler = security.SecurityHeaders(handler)
 	handler = httpserver.NewHandler(httpserver.CORSConfig{
@@ -186,6 +604,8 @@ func New(cfg config.Config) (*App, error) {
 		DB:      pg,
 		Redis:   rd,
 		Handler: handler,
+
+		stopBackground: stopBackground,
 	}, nil
 }
 
@@ -198,6 +618,9 @@ func (a *App) Server() *http.Server {
 }
 
 func (a *App) Close() {
+	if a.stopBackground != nil {
+		a.stopBackground()
+	}
 	if a.Redis != nil {
 		_ = a.Redis.Client.Close()
 	}
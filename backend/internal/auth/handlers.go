@@ -90,6 +90,10 @@ func (h Handlers) Login(w http.ResponseWriter, r *http.Request) {
 		functions.WriteError(w, http.StatusUnauthorized, "invalid credentials")
 		return
 	}
+	if u.SuspendedAt != nil {
+		functions.WriteError(w, http.StatusForbidden, ErrUserSuspended.Error())
+		return
+	}
 
 	sid, err := h.Sessions.NewSessionID()
 	if err != nil {
@@ -21,9 +21,10 @@ func Me(usersRepo users.Repo) http.HandlerFunc {
 		}
 
 		functions.WriteJSON(w, http.StatusOK, map[string]any{
-			"id":    u.ID,
-			"email": u.Email,
-			"name":  u.Name,
+			"id":      u.ID,
+			"email":   u.Email,
+			"name":    u.Name,
+			"isAdmin": u.IsAdmin,
 		})
 	}
 }
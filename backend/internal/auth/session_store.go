@@ -5,22 +5,28 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+var ErrUserSuspended = errors.New("account suspended")
+
 type SessionStore struct {
 	Redis     *redis.Client
 	TTL       time.Duration
 	KeyPrefix string
+	// marks suspended users, their sessions stop resolving without having to find and delete them
+	SuspendedPrefix string
 }
 
 func NewSessionStore(rdb *redis.Client) *SessionStore {
 	return &SessionStore{
-		Redis:     rdb,
-		TTL:       7 * 24 * time.Hour,
-		KeyPrefix: "session:",
+		Redis:           rdb,
+		TTL:             7 * 24 * time.Hour,
+		KeyPrefix:       "session:",
+		SuspendedPrefix: "suspended_user:",
 	}
 }
 
@@ -52,5 +58,59 @@ func (s *SessionStore) Get(ctx context.Context, sessionID string) (string, error
 	if err == redis.Nil {
 		return "", errors.New("session not found")
 	}
-	return userID, err
+	if err != nil {
+		return "", err
+	}
+
+	suspended, err := s.Redis.Exists(ctx, s.SuspendedPrefix+userID).Result()
+	if err != nil {
+		return "", err
+	}
+	if suspended > 0 {
+		return "", ErrUserSuspended
+	}
+	return userID, nil
+}
+
+// Suspend rejects every session of the user until Unsuspend, users.suspended_at is the source of truth
+// and SyncSuspended brings the markers back in line with it
+func (s *SessionStore) Suspend(ctx context.Context, userID string) error {
+	return s.Redis.Set(ctx, s.SuspendedPrefix+userID, "1", 0).Err()
+}
+
+func (s *SessionStore) Unsuspend(ctx context.Context, userID string) error {
+	return s.Redis.Del(ctx, s.SuspendedPrefix+userID).Err()
+}
+
+// SyncSuspended makes the markers match the users load returns: missing markers are added and
+// markers of users who are no longer suspended are removed. Redis is scanned before load runs so a
+// suspension committed in between is seen by load and its marker survives
+func (s *SessionStore) SyncSuspended(ctx context.Context, load func(ctx context.Context) ([]string, error)) error {
+	marked := make(map[string]struct{})
+	iter := s.Redis.Scan(ctx, 0, s.SuspendedPrefix+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		marked[strings.TrimPrefix(iter.Val(), s.SuspendedPrefix)] = struct{}{}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	userIDs, err := load(ctx)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.Redis.Pipeline()
+	for _, userID := range userIDs {
+		pipe.Set(ctx, s.SuspendedPrefix+userID, "1", 0)
+		delete(marked, userID)
+	}
+	for userID := range marked {
+		pipe.Del(ctx, s.SuspendedPrefix+userID)
+	}
+	if pipe.Len() == 0 {
+		return nil
+	}
+	_, err = pipe.Exec(ctx)
+	return err
 }
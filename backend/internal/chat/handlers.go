@@ -7,6 +7,7 @@ import (
 	"go-react-rooms/internal/middleware"
 	"go-react-rooms/internal/repositories/messages"
 	"go-react-rooms/internal/repositories/rooms"
+	"go-react-rooms/internal/ws"
 	"net/http"
 	"strconv"
 	"strings"
@@ -16,6 +17,8 @@ import (
 type Handlers struct {
 	Rooms    rooms.Repo
 	Messages messages.Repo
+	// read receipts go out to connected room members
+	Hub *ws.Hub
 }
 
 type createRoomReq struct {
@@ -26,6 +29,11 @@ type joinRoomReq struct {
 	RoomID string `json:"roomId"`
 }
 
+type markReadReq struct {
+	RoomID    string `json:"roomId"`
+	MessageID string `json:"messageId"`
+}
+
 func (handler Handlers) CreateRoom(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
@@ -105,6 +113,10 @@ func (handler Handlers) JoinRoom(w http.ResponseWriter, r *http.Request) {
 			functions.WriteError(w, http.StatusConflict, err.Error())
 			return
 		}
+		if errors.Is(err, rooms.ErrPrivateRoom) {
+			functions.WriteError(w, http.StatusForbidden, err.Error())
+			return
+		}
 		functions.WriteError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
@@ -158,6 +170,12 @@ func (handler Handlers) ListMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	reads, err := handler.Rooms.ListReads(r.Context(), roomID)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not list read receipts")
+		return
+	}
+
 	//	provide a next cursor for pagination (oldest item in this page)
 	var nextCursor string
 	if len(messages) > 0 {
@@ -167,6 +185,63 @@ func (handler Handlers) ListMessages(w http.ResponseWriter, r *http.Request) {
 	functions.WriteJSON(w, http.StatusOK, map[string]any{
 		"messages":   messages,
 		"nextBefore": nextCursor,
+		"reads":      reads,
 		"serverTime": time.Now().UTC().Format(time.RFC3339),
 	})
 }
+
+// MarkRead is the REST equivalent of the read envelope on /ws, for clients that are not connected
+func (handler Handlers) MarkRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return
+	}
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req markReadReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	req.RoomID = strings.TrimSpace(req.RoomID)
+	req.MessageID = strings.TrimSpace(req.MessageID)
+
+	fields := make(map[string]string)
+	if req.RoomID == "" {
+		fields["roomId"] = "is required"
+	}
+	if req.MessageID == "" {
+		fields["messageId"] = "is required"
+	}
+	if len(fields) > 0 {
+		functions.WriteFieldErrors(w, fields)
+		return
+	}
+
+	isMember, err := handler.Rooms.IsMember(r.Context(), req.RoomID, userID)
+	if err != nil || !isMember {
+		functions.WriteError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	state, advanced, err := handler.Rooms.MarkRead(r.Context(), req.RoomID, userID, req.MessageID)
+	if err != nil {
+		if errors.Is(err, messages.ErrMessageNotFound) {
+			functions.WriteError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		functions.WriteError(w, http.StatusInternalServerError, "could not save read position")
+		return
+	}
+	if advanced {
+		handler.Hub.Broadcast(req.RoomID, ws.ReadReceipt(state))
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"advanced": advanced,
+	})
+}
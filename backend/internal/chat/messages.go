@@ -0,0 +1,299 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/middleware"
+	"go-react-rooms/internal/repositories/messages"
+	"go-react-rooms/internal/repositories/rooms"
+	"go-react-rooms/internal/ws"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// edited bodies follow the same limit as messages sent over /ws, whose frames are capped at 4096 bytes
+const maxMessageLength = 4000
+
+var errForbidden = errors.New("forbidden")
+
+type editMessageReq struct {
+	Body string `json:"body"`
+}
+
+type setMemberRoleReq struct {
+	UserID string `json:"userId"`
+	Role   string `json:"role"`
+}
+
+// HandleMessage routes /rooms/messages/{id} by method
+func (handler Handlers) HandleMessage(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPatch:
+		handler.EditMessage(w, r)
+	case http.MethodDelete:
+		handler.DeleteMessage(w, r)
+	default:
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// EditMessage changes the body of the caller's own message, the previous body goes to the history
+func (handler Handlers) EditMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req editMessageReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	body := strings.TrimSpace(req.Body)
+	if body == "" {
+		functions.WriteFieldErrors(w, map[string]string{"body": "is required"})
+		return
+	}
+	if len(body) > maxMessageLength {
+		functions.WriteFieldErrors(w, map[string]string{
+			"body": fmt.Sprintf("must be at most %d characters", maxMessageLength),
+		})
+		return
+	}
+
+	message, err := handler.Messages.Get(r.Context(), strings.TrimSpace(r.PathValue("id")))
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	// owners and moderators remove messages but never put words in someone else's mouth
+	if message.SenderID != userID {
+		writeMessageError(w, errForbidden)
+		return
+	}
+	if message.DeletedAt != nil {
+		writeMessageError(w, messages.ErrMessageDeleted)
+		return
+	}
+
+	message, changed, err := handler.Messages.Edit(r.Context(), message.ID, userID, body)
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	if changed {
+		handler.Hub.Broadcast(message.RoomID, ws.MessageUpdated(message))
+	}
+
+	functions.WriteJSON(w, http.StatusOK, message)
+}
+
+// DeleteMessage replaces a message with a tombstone, allowed for its sender and the room's owner and moderators
+func (handler Handlers) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	message, err := handler.Messages.Get(r.Context(), strings.TrimSpace(r.PathValue("id")))
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	if err := handler.canModerate(r, message, userID); err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	message, err = handler.Messages.SoftDelete(r.Context(), message.ID, userID)
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	handler.Hub.Broadcast(message.RoomID, ws.MessageDeleted(message))
+
+	functions.WriteJSON(w, http.StatusOK, message)
+}
+
+// MessageHistory lists the previous bodies of a message to its sender and the room's owner and moderators
+func (handler Handlers) MessageHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use GET")
+		return
+	}
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	message, err := handler.Messages.Get(r.Context(), strings.TrimSpace(r.PathValue("id")))
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	if err := handler.canModerate(r, message, userID); err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	edits, err := handler.Messages.ListEdits(r.Context(), message.ID)
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"message": message,
+		"edits":   edits,
+	})
+}
+
+// ListReplies is the thread view of a message, newest replies first and paged with ?before= like ListMessages
+func (handler Handlers) ListReplies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use GET")
+		return
+	}
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	parent, err := handler.Messages.Get(r.Context(), strings.TrimSpace(r.PathValue("id")))
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	isMember, err := handler.Rooms.IsMember(r.Context(), parent.RoomID, userID)
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+	if !isMember {
+		// the thread is not shown to people outside the room, like the message itself
+		writeMessageError(w, messages.ErrMessageNotFound)
+		return
+	}
+
+	before := strings.TrimSpace(r.URL.Query().Get("before"))
+
+	limit := 50
+	if requestLimit := strings.TrimSpace(r.URL.Query().Get("limit")); requestLimit != "" {
+		if requestLimitToInt, err := strconv.Atoi(requestLimit); err == nil {
+			limit = requestLimitToInt
+		}
+	}
+
+	replies, err := handler.Messages.ListReplies(r.Context(), parent.ID, before, limit)
+	if err != nil {
+		writeMessageError(w, err)
+		return
+	}
+
+	//	provide a next cursor for pagination (oldest reply in this page)
+	var nextCursor string
+	if len(replies) > 0 {
+		nextCursor = replies[len(replies)-1].ID
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"parent":     parent,
+		"replies":    replies,
+		"nextBefore": nextCursor,
+		"serverTime": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// SetMemberRole lets a room's owner make members moderators and take it back
+func (handler Handlers) SetMemberRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return
+	}
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req setMemberRoleReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	req.UserID = strings.TrimSpace(req.UserID)
+	req.Role = strings.TrimSpace(req.Role)
+
+	fields := make(map[string]string)
+	if req.UserID == "" {
+		fields["userId"] = "is required"
+	} else if req.UserID == userID {
+		fields["userId"] = "the owner's role can not change"
+	}
+	if req.Role != rooms.RoleModerator && req.Role != rooms.RoleMember {
+		fields["role"] = "must be moderator or member"
+	}
+	if len(fields) > 0 {
+		functions.WriteFieldErrors(w, fields)
+		return
+	}
+
+	roomID := strings.TrimSpace(r.PathValue("id"))
+	role, err := handler.Rooms.MemberRole(r.Context(), roomID, userID)
+	if err != nil || role != rooms.RoleOwner {
+		functions.WriteError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	if err := handler.Rooms.SetRole(r.Context(), roomID, req.UserID, req.Role); err != nil {
+		if errors.Is(err, rooms.ErrNotMember) {
+			functions.WriteError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		functions.WriteError(w, http.StatusInternalServerError, "could not change role")
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"roomId": roomID,
+		"userId": req.UserID,
+		"role":   req.Role,
+	})
+}
+
+// canModerate allows the sender of a message and the owner and moderators of its room
+func (handler Handlers) canModerate(r *http.Request, message messages.Message, userID string) error {
+	role, err := handler.Rooms.MemberRole(r.Context(), message.RoomID, userID)
+	if errors.Is(err, rooms.ErrNotMember) {
+		// the message is not shown to people outside the room
+		return messages.ErrMessageNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if message.SenderID == userID || role == rooms.RoleOwner || role == rooms.RoleModerator {
+		return nil
+	}
+	return errForbidden
+}
+
+func writeMessageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, messages.ErrMessageNotFound):
+		functions.WriteError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errForbidden):
+		functions.WriteError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, messages.ErrMessageDeleted):
+		functions.WriteError(w, http.StatusConflict, err.Error())
+	default:
+		functions.WriteError(w, http.StatusInternalServerError, "message request failed")
+	}
+}
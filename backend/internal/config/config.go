@@ -1,8 +1,11 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -13,6 +16,27 @@ type Config struct {
 	DomainURL   string
 	DatabaseURL string
 	RedisURL    string
+	// listing expiry job
+	SchedulerEnabled    bool
+	ListingStaleDays    int
+	ListingReminderDays int
+	// saved search email digests
+	AppURL                 string
+	MailDriver             string
+	MailFrom               string
+	SavedSearchDigestHours int
+	// object storage, s3 or local
+	StorageDriver         string
+	StorageLocalDir       string
+	StoragePublicURL      string
+	StorageSigningSecret  string
+	StorageMaxUploadBytes int64
+	// serve images from this base URL (a CDN in front of the bucket) instead of presigning
+	StorageCDNBaseURL string
+	// orphaned object garbage collection
+	ObjectGCIntervalHours int
+	ObjectGCGraceHours    int
+	ObjectGCDryRun        bool
 }
 
 func LoadConfig() Config {
@@ -33,12 +57,60 @@ func LoadConfig() Config {
 	databaseURL := getEnv("DATABASE_URL", "")
 	redisURL := getEnv("REDIS_URL", "")
 
+	schedulerEnabled := getEnv("SCHEDULER_ENABLED", "true") == "true"
+	listingStaleDays := getEnvInt("LISTING_STALE_DAYS", 60)
+	listingReminderDays := getEnvInt("LISTING_REMINDER_DAYS", 7)
+
+	appURL := getEnv("APP_URL", "http://localhost:5173")
+	mailDriver := getEnv("MAIL_DRIVER", "log")
+	mailFrom := getEnv("MAIL_FROM", "no-reply@localhost")
+	savedSearchDigestHours := getEnvInt("SAVED_SEARCH_DIGEST_HOURS", 24)
+
+	storageDriver := getEnv("STORAGE_DRIVER", "s3")
+	storageLocalDir := getEnv("STORAGE_LOCAL_DIR", "./data/objects")
+	storagePublicURL := getEnv("STORAGE_PUBLIC_URL", "http://localhost:"+port)
+	storageSigningSecret := getEnv("STORAGE_SIGNING_SECRET", "")
+	storageMaxUploadMB := getEnvInt("STORAGE_MAX_UPLOAD_MB", 10)
+	storageCDNBaseURL := getEnv("STORAGE_CDN_BASE_URL", "")
+
+	objectGCIntervalHours := getEnvInt("OBJECT_GC_INTERVAL_HOURS", 6)
+	objectGCGraceHours := getEnvInt("OBJECT_GC_GRACE_HOURS", 24)
+	objectGCDryRun := getEnv("OBJECT_GC_DRY_RUN", "false") == "true"
+
 	if databaseURL == "" {
 		log.Fatal("DATABASE_URL not found")
 	}
 	if redisURL == "" {
 		log.Fatal("REDIS_URL not found")
 	}
+	if listingStaleDays <= 0 {
+		log.Fatal("LISTING_STALE_DAYS must be greater than 0")
+	}
+	// owners are reminded ahead of the expiry, so the lead must fit inside the stale window
+	if listingReminderDays < 0 || listingReminderDays >= listingStaleDays {
+		log.Fatal("LISTING_REMINDER_DAYS must be 0 or more and less than LISTING_STALE_DAYS")
+	}
+	if savedSearchDigestHours <= 0 {
+		log.Fatal("SAVED_SEARCH_DIGEST_HOURS must be greater than 0")
+	}
+	if storageMaxUploadMB <= 0 {
+		log.Fatal("STORAGE_MAX_UPLOAD_MB must be greater than 0")
+	}
+	if objectGCIntervalHours <= 0 {
+		log.Fatal("OBJECT_GC_INTERVAL_HOURS must be greater than 0")
+	}
+	// pending direct uploads must outlive their presigned URL before they count as orphans
+	if objectGCGraceHours < 1 {
+		log.Fatal("OBJECT_GC_GRACE_HOURS must be at least 1")
+	}
+	if storageDriver == "local" && storageSigningSecret == "" {
+		if appEnv != "development" {
+			log.Fatal("STORAGE_SIGNING_SECRET is required when STORAGE_DRIVER=local")
+		}
+		// signed URLs stop working across restarts, fine for local development
+		storageSigningSecret = randomSecret()
+		log.Println("STORAGE_SIGNING_SECRET not set, using a random secret for this process")
+	}
 
 	return Config{
 		AppEnv:      appEnv,
@@ -47,6 +119,26 @@ func LoadConfig() Config {
 		DomainURL:   domainURL,
 		DatabaseURL: databaseURL,
 		RedisURL:    redisURL,
+
+		SchedulerEnabled:    schedulerEnabled,
+		ListingStaleDays:    listingStaleDays,
+		ListingReminderDays: listingReminderDays,
+
+		AppURL:                 appURL,
+		MailDriver:             mailDriver,
+		MailFrom:               mailFrom,
+		SavedSearchDigestHours: savedSearchDigestHours,
+
+		StorageDriver:         storageDriver,
+		StorageLocalDir:       storageLocalDir,
+		StoragePublicURL:      storagePublicURL,
+		StorageSigningSecret:  storageSigningSecret,
+		StorageMaxUploadBytes: int64(storageMaxUploadMB) << 20,
+		StorageCDNBaseURL:     storageCDNBaseURL,
+
+		ObjectGCIntervalHours: objectGCIntervalHours,
+		ObjectGCGraceHours:    objectGCGraceHours,
+		ObjectGCDryRun:        objectGCDryRun,
 	}
 }
 
@@ -57,3 +149,23 @@ func getEnv(key string, fallback string) string {
 	}
 	return value
 }
+
+func randomSecret() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("generate secret: %v", err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s must be an integer", key)
+	}
+	return n
+}
@@ -0,0 +1,325 @@
+package contact
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/middleware"
+	"go-react-rooms/internal/repositories/contact_requests"
+	"go-react-rooms/internal/repositories/notifications"
+	"go-react-rooms/internal/repositories/rooms"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	NotificationRequestReceived = "contact_request.received"
+	NotificationRequestAccepted = "contact_request.accepted"
+	NotificationRequestDeclined = "contact_request.declined"
+)
+
+type Handlers struct {
+	ContactRequests contact_requests.Repo
+	Rooms           rooms.Repo
+	Notifications   notifications.Repo
+	DB              *sql.DB
+}
+
+type createRequestReq struct {
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+}
+
+// CreateRequest sends a contact request to the owner of /listings/{id}/contact
+func (handler Handlers) CreateRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req createRequestReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Message == "" {
+		functions.WriteError(w, http.StatusBadRequest, "message is required")
+		return
+	}
+	if len(req.Message) > 4000 {
+		functions.WriteError(w, http.StatusBadRequest, "message is too long")
+		return
+	}
+
+	var subject *string
+	if s := strings.TrimSpace(req.Subject); s != "" {
+		subject = &s
+	}
+
+	request, err := handler.ContactRequests.Create(r.Context(), contact_requests.InsertParams{
+		ListingID:    strings.TrimSpace(r.PathValue("id")),
+		SenderUserID: userID,
+		Subject:      subject,
+		Message:      req.Message,
+	})
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	handler.notify(r.Context(), request.RecipientUserID, NotificationRequestReceived,
+		"New contact request",
+		fmt.Sprintf("%s is interested in “%s”.", request.SenderName, request.ListingTitle),
+		request,
+	)
+
+	functions.WriteJSON(w, http.StatusCreated, request)
+}
+
+// ListRequests returns the caller's inbox (requests on their listings) or, with ?box=sent, the requests they sent
+func (handler Handlers) ListRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use GET")
+		return
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	switch status {
+	case "", contact_requests.StatusOpen, contact_requests.StatusAccepted, contact_requests.StatusDeclined, contact_requests.StatusClosed:
+	default:
+		functions.WriteError(w, http.StatusBadRequest, "invalid status")
+		return
+	}
+
+	var items []contact_requests.ContactRequest
+	var err error
+	if r.URL.Query().Get("box") == "sent" {
+		items, err = handler.ContactRequests.ListSent(r.Context(), userID, status)
+	} else {
+		items, err = handler.ContactRequests.ListInbox(r.Context(), userID, status)
+	}
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not list contact requests")
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"requests": items,
+	})
+}
+
+// AcceptRequest accepts an open request and opens a private chat room with both users
+func (handler Handlers) AcceptRequest(w http.ResponseWriter, r *http.Request) {
+	request, ok := handler.loadForRecipient(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := handler.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to start transaction")
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// claim the request first, a concurrent accept waits on the row and then finds it accepted
+	err = handler.ContactRequests.TransitionTx(r.Context(), tx, request.ID, []string{contact_requests.StatusOpen}, contact_requests.StatusAccepted)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	roomID, err := handler.createRoomTx(r.Context(), tx, request)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not create chat room")
+		return
+	}
+
+	if err := handler.ContactRequests.SetRoomTx(r.Context(), tx, request.ID, roomID); err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not link chat room")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to commit transaction")
+		return
+	}
+
+	request, err = handler.ContactRequests.GetByID(r.Context(), request.ID)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	handler.notify(r.Context(), request.SenderUserID, NotificationRequestAccepted,
+		"Contact request accepted",
+		fmt.Sprintf("%s accepted your request about “%s”. You can now chat.", request.RecipientName, request.ListingTitle),
+		request,
+	)
+
+	functions.WriteJSON(w, http.StatusOK, request)
+}
+
+func (handler Handlers) DeclineRequest(w http.ResponseWriter, r *http.Request) {
+	request, ok := handler.loadForRecipient(w, r)
+	if !ok {
+		return
+	}
+
+	err := handler.ContactRequests.Transition(r.Context(), request.ID, []string{contact_requests.StatusOpen}, contact_requests.StatusDeclined)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	request.Status = contact_requests.StatusDeclined
+
+	handler.notify(r.Context(), request.SenderUserID, NotificationRequestDeclined,
+		"Contact request declined",
+		fmt.Sprintf("Your request about “%s” was declined.", request.ListingTitle),
+		request,
+	)
+
+	functions.WriteJSON(w, http.StatusOK, request)
+}
+
+// CloseRequest closes a request: the owner can close open or accepted requests, the sender can withdraw an open one
+func (handler Handlers) CloseRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	request, err := handler.ContactRequests.GetByID(r.Context(), strings.TrimSpace(r.PathValue("id")))
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	var from []string
+	switch userID {
+	case request.RecipientUserID:
+		from = []string{contact_requests.StatusOpen, contact_requests.StatusAccepted}
+	case request.SenderUserID:
+		from = []string{contact_requests.StatusOpen}
+	default:
+		writeRequestError(w, contact_requests.ErrRequestNotFound)
+		return
+	}
+
+	if err := handler.ContactRequests.Transition(r.Context(), request.ID, from, contact_requests.StatusClosed); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	request.Status = contact_requests.StatusClosed
+	functions.WriteJSON(w, http.StatusOK, request)
+}
+
+// loadForRecipient loads the request from the path, only the listing owner it was sent to may act on it
+func (handler Handlers) loadForRecipient(w http.ResponseWriter, r *http.Request) (contact_requests.ContactRequest, bool) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return contact_requests.ContactRequest{}, false
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return contact_requests.ContactRequest{}, false
+	}
+
+	request, err := handler.ContactRequests.GetByID(r.Context(), strings.TrimSpace(r.PathValue("id")))
+	if err != nil {
+		writeRequestError(w, err)
+		return contact_requests.ContactRequest{}, false
+	}
+
+	if request.RecipientUserID != userID {
+		// senders can see their request but not answer it, anyone else must not learn it exists
+		if request.SenderUserID == userID {
+			functions.WriteError(w, http.StatusForbidden, "only the listing owner can answer this request")
+		} else {
+			writeRequestError(w, contact_requests.ErrRequestNotFound)
+		}
+		return contact_requests.ContactRequest{}, false
+	}
+
+	return request, true
+}
+
+// createRoomTx opens a private chat room owned by the listing owner with both users as its only members
+func (handler Handlers) createRoomTx(ctx context.Context, tx *sql.Tx, request contact_requests.ContactRequest) (string, error) {
+	// room names are unique per creator, the request id suffix keeps them apart
+	name := fmt.Sprintf("%s · %s (#%s)", request.ListingTitle, request.SenderName, request.ID[:8])
+
+	room, err := handler.Rooms.CreatePrivateTx(ctx, tx, name, request.RecipientUserID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := handler.Rooms.AddPrivateMemberTx(ctx, tx, room.ID, request.RecipientUserID); err != nil {
+		return "", err
+	}
+	if err := handler.Rooms.AddPrivateMemberTx(ctx, tx, room.ID, request.SenderUserID); err != nil {
+		return "", err
+	}
+
+	return room.ID, nil
+}
+
+func (handler Handlers) notify(ctx context.Context, userID string, notificationType string, title string, body string, request contact_requests.ContactRequest) {
+	_, err := handler.Notifications.Insert(ctx, notifications.InsertParams{
+		UserID: userID,
+		Type:   notificationType,
+		Title:  title,
+		Body:   &body,
+		Data: map[string]any{
+			"contactRequestId": request.ID,
+			"listingId":        request.ListingID,
+			"roomId":           request.RoomID,
+		},
+	})
+	if err != nil {
+		log.Printf("contact requests: notify %s: %v", userID, err)
+	}
+}
+
+func writeRequestError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, contact_requests.ErrListingNotFound), errors.Is(err, contact_requests.ErrRequestNotFound):
+		functions.WriteError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, contact_requests.ErrOwnListing):
+		functions.WriteError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, contact_requests.ErrAlreadyOpen), errors.Is(err, contact_requests.ErrInvalidTransition):
+		functions.WriteError(w, http.StatusConflict, err.Error())
+	default:
+		functions.WriteError(w, http.StatusInternalServerError, "contact request failed")
+	}
+}
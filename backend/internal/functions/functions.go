@@ -6,7 +6,8 @@ import (
 )
 
 type APIError struct {
-	Error string `json:"error"`
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"fields,omitempty"`
 }
 
 func WriteJSON(w http.ResponseWriter, status int, v any) {
@@ -18,3 +19,8 @@ func WriteJSON(w http.ResponseWriter, status int, v any) {
 func WriteError(w http.ResponseWriter, status int, msg string) {
 	WriteJSON(w, status, APIError{Error: msg})
 }
+
+// WriteFieldErrors writes a 400 with one message per invalid request field
+func WriteFieldErrors(w http.ResponseWriter, fields map[string]string) {
+	WriteJSON(w, http.StatusBadRequest, APIError{Error: "validation failed", Fields: fields})
+}
@@ -0,0 +1,204 @@
+// Package imaging turns uploaded listing photos into the resized variants we serve.
+// Every variant is re-encoded from decoded pixels, so EXIF, GPS and any other metadata
+// in the upload never reaches storage.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"math/bits"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	VariantThumbnail = "thumbnail"
+	VariantCard      = "card"
+	VariantFull      = "full"
+)
+
+// Spec bounds a variant, images are scaled down to fit and never scaled up
+type Spec struct {
+	Name      string
+	MaxWidth  int
+	MaxHeight int
+}
+
+var Specs = []Spec{
+	{Name: VariantThumbnail, MaxWidth: 320, MaxHeight: 320},
+	{Name: VariantCard, MaxWidth: 800, MaxHeight: 600},
+	{Name: VariantFull, MaxWidth: 2048, MaxHeight: 2048},
+}
+
+// MaxPixels rejects uploads that would take too much memory to decode, a 24MP photo decodes to about 100MB
+const MaxPixels = 24_000_000
+
+// maxConcurrent bounds how many uploads are decoded at once across all requests, together with
+// MaxPixels it caps the memory image processing can take
+const maxConcurrent = 2
+
+var processSlots = make(chan struct{}, maxConcurrent)
+
+const jpegQuality = 85
+
+var ErrUnsupportedImage = errors.New("unsupported or corrupt image")
+var ErrImageTooLarge = errors.New("image dimensions are too large")
+
+type Variant struct {
+	Name        string
+	Width       int
+	Height      int
+	ContentType string
+	Data        []byte
+}
+
+// Result holds the variants in Specs order, Width and Height are those of the full variant
+type Result struct {
+	Width    int
+	Height   int
+	Variants []Variant
+	// Hash is the perceptual hash of the upright image, see DHash
+	Hash uint64
+}
+
+// Process decodes a JPEG, PNG or WebP upload, applies its EXIF orientation and renders every Spec as JPEG
+func Process(data []byte) (Result, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Result{}, ErrUnsupportedImage
+	}
+	if format != "jpeg" && format != "png" && format != "webp" {
+		return Result{}, ErrUnsupportedImage
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return Result{}, ErrUnsupportedImage
+	}
+	if config.Width*config.Height > MaxPixels {
+		return Result{}, ErrImageTooLarge
+	}
+
+	processSlots <- struct{}{}
+	defer func() {
+		<-processSlots
+	}()
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Result{}, ErrUnsupportedImage
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = jpegOrientation(data)
+	}
+
+	// the canvas is only as large as the biggest variant, it is scaled before orienting so no full
+	// resolution copy of the upload is made. Orientations 5 to 8 swap the axes
+	srcWidth, srcHeight := decoded.Bounds().Dx(), decoded.Bounds().Dy()
+	uprightWidth, uprightHeight := srcWidth, srcHeight
+	if orientation >= 5 {
+		uprightWidth, uprightHeight = srcHeight, srcWidth
+	}
+	canvasWidth, canvasHeight := fit(uprightWidth, uprightHeight, maxVariantWidth(), maxVariantHeight())
+	if orientation >= 5 {
+		canvasWidth, canvasHeight = canvasHeight, canvasWidth
+	}
+
+	// JPEG has no alpha, transparent areas are flattened onto white
+	canvas := image.NewRGBA(image.Rect(0, 0, canvasWidth, canvasHeight))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	if canvasWidth == srcWidth && canvasHeight == srcHeight {
+		draw.Draw(canvas, canvas.Bounds(), decoded, decoded.Bounds().Min, draw.Over)
+	} else {
+		draw.CatmullRom.Scale(canvas, canvas.Bounds(), decoded, decoded.Bounds(), draw.Over, nil)
+	}
+
+	canvas = orient(canvas, orientation)
+
+	result := Result{Hash: DHash(canvas)}
+	for _, spec := range Specs {
+		width, height := fit(canvas.Bounds().Dx(), canvas.Bounds().Dy(), spec.MaxWidth, spec.MaxHeight)
+
+		var scaled image.Image = canvas
+		if width != canvas.Bounds().Dx() || height != canvas.Bounds().Dy() {
+			resized := image.NewRGBA(image.Rect(0, 0, width, height))
+			draw.CatmullRom.Scale(resized, resized.Bounds(), canvas, canvas.Bounds(), draw.Src, nil)
+			scaled = resized
+		}
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return Result{}, fmt.Errorf("encode %s variant: %w", spec.Name, err)
+		}
+
+		result.Variants = append(result.Variants, Variant{
+			Name:        spec.Name,
+			Width:       width,
+			Height:      height,
+			ContentType: "image/jpeg",
+			Data:        buf.Bytes(),
+		})
+		if spec.Name == VariantFull {
+			result.Width = width
+			result.Height = height
+		}
+	}
+
+	return result, nil
+}
+
+// DHash is a 64 bit difference hash: the image is shrunk to 9x8 gray pixels and each bit tells whether
+// a pixel is brighter than its right neighbour. Re-encoding, resizing and light edits barely change it,
+// so a small Distance between two hashes means the same photo
+func DHash(img image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.BiLinear.Scale(small, small.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// Distance is the number of differing bits between two hashes, 0 for identical photos
+func Distance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func maxVariantWidth() int {
+	width := 0
+	for _, spec := range Specs {
+		width = max(width, spec.MaxWidth)
+	}
+	return width
+}
+
+func maxVariantHeight() int {
+	height := 0
+	for _, spec := range Specs {
+		height = max(height, spec.MaxHeight)
+	}
+	return height
+}
+
+// fit scales width x height down to fit inside maxWidth x maxHeight, keeping the aspect ratio
+func fit(width int, height int, maxWidth int, maxHeight int) (int, int) {
+	if width <= maxWidth && height <= maxHeight {
+		return width, height
+	}
+
+	scale := min(float64(maxWidth)/float64(width), float64(maxHeight)/float64(height))
+	return max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5))
+}
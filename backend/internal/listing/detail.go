@@ -0,0 +1,331 @@
+package listing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/middleware"
+	"go-react-rooms/internal/repositories/amenities"
+	"go-react-rooms/internal/repositories/listing_flags"
+	"go-react-rooms/internal/repositories/listing_images"
+	"go-react-rooms/internal/repositories/listings"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type ListingDetailResponse struct {
+	Listing   listings.Listing              `json:"listing"`
+	Images    []listing_images.ListingImage `json:"images"`
+	Amenities []amenities.Amenity           `json:"amenities"`
+}
+
+type updateListingReq struct {
+	Title            *string    `json:"title"`
+	Description      *string    `json:"description"`
+	AddressLine1     *string    `json:"addressLine1"`
+	AddressLine2     *string    `json:"addressLine2"`
+	City             *string    `json:"city"`
+	Province         *string    `json:"province"`
+	Country          *string    `json:"country"`
+	PostalCode       *string    `json:"postalCode"`
+	Latitude         *float64   `json:"latitude"`
+	Longitude        *float64   `json:"longitude"`
+	Bedrooms         *int       `json:"bedrooms"`
+	Bathrooms        *float64   `json:"bathrooms"`
+	Area             *float64   `json:"area"`
+	AreaUnit         *string    `json:"areaUnit"`
+	Price            *float64   `json:"price"`
+	Currency         *string    `json:"currency"`
+	AvailableFrom    *time.Time `json:"availableFrom"`
+	AvailableUntil   *time.Time `json:"availableUntil"`
+	MinLeaseDays     *int       `json:"minLeaseDays"`
+	IsFurnished      *bool      `json:"isFurnished"`
+	PetsAllowed      *bool      `json:"petsAllowed"`
+	SmokingAllowed   *bool      `json:"smokingAllowed"`
+	ParkingAvailable *bool      `json:"parkingAvailable"`
+	// replaces the listing amenities when present
+	Amenities *[]string `json:"amenities"`
+}
+
+// HandleListing routes /listings/{id} by method
+func (handler Handler) HandleListing(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handler.GetListing(w, r)
+	case http.MethodPatch:
+		handler.UpdateListing(w, r)
+	case http.MethodDelete:
+		handler.DeleteListing(w, r)
+	default:
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (handler Handler) GetListing(w http.ResponseWriter, r *http.Request) {
+	listingID := strings.TrimSpace(r.PathValue("id"))
+
+	listing, err := handler.Listings.GetByID(r.Context(), listingID)
+	if err != nil {
+		writeListingError(w, err)
+		return
+	}
+
+	// listings that are not live are only visible to their owner
+	if listing.Status != listings.StatusActive {
+		userID, _ := middleware.UserIDFromContext(r.Context())
+		if userID != listing.UserID {
+			writeListingError(w, listings.ErrListingNotFound)
+			return
+		}
+	}
+
+	images, err := handler.ListingImages.ListByListing(r.Context(), listing.ID)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not load listing images")
+		return
+	}
+
+	listingAmenities, err := handler.Amenities.ListForListing(r.Context(), listing.ID)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not load listing amenities")
+		return
+	}
+
+	handler.presignImages(r.Context(), images)
+	handler.markSaved(r.Context(), []*listings.Listing{&listing})
+
+	functions.WriteJSON(w, http.StatusOK, ListingDetailResponse{
+		Listing:   listing,
+		Images:    images,
+		Amenities: listingAmenities,
+	})
+}
+
+func (handler Handler) UpdateListing(w http.ResponseWriter, r *http.Request) {
+	current, ok := handler.authorizeOwner(w, r)
+	if !ok {
+		return
+	}
+	listingID := current.ID
+
+	var req updateListingReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tx, err := handler.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to start transaction")
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	listing, err := handler.Listings.UpdateTx(r.Context(), tx, listingID, listings.UpdateParams{
+		Title:            req.Title,
+		Description:      req.Description,
+		AddressLine1:     req.AddressLine1,
+		AddressLine2:     req.AddressLine2,
+		City:             req.City,
+		Province:         req.Province,
+		Country:          req.Country,
+		PostalCode:       req.PostalCode,
+		Latitude:         req.Latitude,
+		Longitude:        req.Longitude,
+		Bedrooms:         req.Bedrooms,
+		Bathrooms:        req.Bathrooms,
+		Area:             req.Area,
+		AreaUnit:         req.AreaUnit,
+		Price:            req.Price,
+		Currency:         req.Currency,
+		AvailableFrom:    req.AvailableFrom,
+		AvailableUntil:   req.AvailableUntil,
+		MinLeaseDays:     req.MinLeaseDays,
+		IsFurnished:      req.IsFurnished,
+		PetsAllowed:      req.PetsAllowed,
+		SmokingAllowed:   req.SmokingAllowed,
+		ParkingAvailable: req.ParkingAvailable,
+	})
+	if err != nil {
+		writeListingError(w, err)
+		return
+	}
+
+	if req.Amenities != nil {
+		if err := handler.Amenities.SetForListingTx(r.Context(), tx, listingID, *req.Amenities); err != nil {
+			writeAmenityError(w, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to commit transaction")
+		return
+	}
+
+	handler.notifyListingChanges(r.Context(), current, listing)
+
+	functions.WriteJSON(w, http.StatusOK, listing)
+}
+
+func (handler Handler) DeleteListing(w http.ResponseWriter, r *http.Request) {
+	owned, ok := handler.authorizeOwner(w, r)
+	if !ok {
+		return
+	}
+	listingID := owned.ID
+
+	images, err := handler.ListingImages.ListByListing(r.Context(), listingID)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not load listing images")
+		return
+	}
+
+	// images rows go away with the listing (ON DELETE CASCADE)
+	if err := handler.Listings.Delete(r.Context(), listingID); err != nil {
+		writeListingError(w, err)
+		return
+	}
+
+	for _, image := range images {
+		deleteObjects(r.Context(), handler.Storage, image.ObjectKeys())
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+}
+
+// authorizeOwner loads the listing from the path and checks the caller owns it
+// it writes the error response itself and returns false when the request must stop
+func (handler Handler) authorizeOwner(w http.ResponseWriter, r *http.Request) (listings.Listing, bool) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return listings.Listing{}, false
+	}
+
+	listingID := strings.TrimSpace(r.PathValue("id"))
+	if listingID == "" {
+		functions.WriteError(w, http.StatusBadRequest, "listing id is required")
+		return listings.Listing{}, false
+	}
+
+	listing, err := handler.Listings.GetByID(r.Context(), listingID)
+	if err != nil {
+		writeListingError(w, err)
+		return listings.Listing{}, false
+	}
+
+	allowed, err := handler.Listings.UserOwnsListing(r.Context(), userID, listingID)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to verify listing ownership")
+		return listings.Listing{}, false
+	}
+	if !allowed {
+		functions.WriteError(w, http.StatusForbidden, "you do not own this listing")
+		return listings.Listing{}, false
+	}
+
+	return listing, true
+}
+
+// presignImages signs the full image as URL and every variant in one batch, so the client can
+// pick the size it shows
+func (handler Handler) presignImages(ctx context.Context, images []listing_images.ListingImage) {
+	var keys []string
+	for _, image := range images {
+		keys = append(keys, image.S3Key)
+		for _, key := range image.VariantKeys {
+			keys = append(keys, key)
+		}
+	}
+
+	urls := handler.URLs.SignMany(ctx, keys)
+	for i := range images {
+		images[i].URL = urls[images[i].S3Key]
+
+		if len(images[i].VariantKeys) == 0 {
+			continue
+		}
+		images[i].Variants = make(map[string]string, len(images[i].VariantKeys))
+		for variant, key := range images[i].VariantKeys {
+			if url, ok := urls[key]; ok {
+				images[i].Variants[variant] = url
+			}
+		}
+	}
+}
+
+func (req updateListingReq) validate() error {
+	required := []struct {
+		field string
+		value *string
+	}{
+		{"title", req.Title},
+		{"addressLine1", req.AddressLine1},
+		{"city", req.City},
+		{"province", req.Province},
+		{"country", req.Country},
+		{"areaUnit", req.AreaUnit},
+		{"currency", req.Currency},
+	}
+	for _, r := range required {
+		if r.value != nil && strings.TrimSpace(*r.value) == "" {
+			return errors.New(r.field + " cannot be empty")
+		}
+	}
+
+	if req.Latitude != nil && (*req.Latitude < -90 || *req.Latitude > 90) {
+		return errors.New("latitude must be between -90 and 90")
+	}
+	if req.Longitude != nil && (*req.Longitude < -180 || *req.Longitude > 180) {
+		return errors.New("longitude must be between -180 and 180")
+	}
+	if req.Price != nil && *req.Price <= 0 {
+		return errors.New("price must be greater than 0")
+	}
+	if req.Bedrooms != nil && *req.Bedrooms < 0 {
+		return errors.New("bedrooms cannot be negative")
+	}
+	if req.Bathrooms != nil && *req.Bathrooms < 0 {
+		return errors.New("bathrooms cannot be negative")
+	}
+	if req.Area != nil && *req.Area <= 0 {
+		return errors.New("area must be greater than 0")
+	}
+	if req.MinLeaseDays != nil && *req.MinLeaseDays <= 0 {
+		return errors.New("minLeaseDays must be greater than 0")
+	}
+
+	return nil
+}
+
+func writeListingError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, listings.ErrListingNotFound):
+		functions.WriteError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, listings.ErrInvalidListing), errors.Is(err, listings.ErrInvalidStatus):
+		functions.WriteError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, listings.ErrInvalidTransition), errors.Is(err, listings.ErrListingNotActive), errors.Is(err, listing_flags.ErrUnderReview):
+		functions.WriteError(w, http.StatusConflict, err.Error())
+	default:
+		functions.WriteError(w, http.StatusInternalServerError, "listing request failed")
+	}
+}
+
+func writeAmenityError(w http.ResponseWriter, err error) {
+	var unknown amenities.UnknownAmenityError
+	if errors.As(err, &unknown) {
+		functions.WriteError(w, http.StatusBadRequest, unknown.Error())
+		return
+	}
+	functions.WriteError(w, http.StatusInternalServerError, "failed to save listing amenities")
+}
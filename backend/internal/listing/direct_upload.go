@@ -0,0 +1,421 @@
+package listing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/imaging"
+	"go-react-rooms/internal/middleware"
+	"go-react-rooms/internal/repositories/listing_images"
+	"go-react-rooms/internal/storage"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// most slots handed out by one request
+	maxUploadSlots = 10
+	// most images one listing can have
+	maxListingImages = 20
+)
+
+type uploadSlotsReq struct {
+	Files []struct {
+		ContentType string `json:"contentType"`
+		Size        int64  `json:"size"`
+	} `json:"files"`
+}
+
+type uploadSlotResponse struct {
+	Key         string    `json:"key"`
+	URL         string    `json:"url"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type completeUploadsReq struct {
+	Uploads []struct {
+		Key     string `json:"key"`
+		AltText string `json:"altText"`
+	} `json:"uploads"`
+	// makes this upload the listing thumbnail, by default the first upload becomes the
+	// thumbnail when the listing has none
+	ThumbnailKey string `json:"thumbnailKey"`
+}
+
+// CreateImageUploads hands out presigned upload slots for a listing the caller owns,
+// the browser PUTs each file straight to storage and then calls CompleteImageUploads
+func (handler Handler) CreateImageUploads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return
+	}
+
+	listing, ok := handler.authorizeOwner(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := middleware.UserIDFromContext(r.Context())
+
+	var req uploadSlotsReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if len(req.Files) == 0 {
+		functions.WriteError(w, http.StatusBadRequest, "at least one file is required")
+		return
+	}
+	if len(req.Files) > maxUploadSlots {
+		functions.WriteError(w, http.StatusBadRequest, fmt.Sprintf("at most %d files can be uploaded at once", maxUploadSlots))
+		return
+	}
+
+	fields := make(map[string]string)
+	for i, file := range req.Files {
+		if !storage.IsAllowedImageType(file.ContentType) {
+			fields[fmt.Sprintf("files[%d].contentType", i)] = "must be image/jpeg, image/png or image/webp"
+		}
+		if file.Size <= 0 {
+			fields[fmt.Sprintf("files[%d].size", i)] = "must be greater than 0"
+		} else if file.Size > handler.MaxUploadSize {
+			fields[fmt.Sprintf("files[%d].size", i)] = fmt.Sprintf("must be at most %d bytes", handler.MaxUploadSize)
+		}
+	}
+	if len(fields) > 0 {
+		functions.WriteFieldErrors(w, fields)
+		return
+	}
+
+	state, err := handler.ListingImages.ImageState(r.Context(), listing.ID)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to load listing images")
+		return
+	}
+	if state.Count+len(req.Files) > maxListingImages {
+		functions.WriteError(w, http.StatusConflict, fmt.Sprintf("a listing can have at most %d images", maxListingImages))
+		return
+	}
+
+	expiresAt := time.Now().Add(storage.DefaultPresignExpiry)
+	uploads := make([]uploadSlotResponse, 0, len(req.Files))
+
+	for _, file := range req.Files {
+		presigned, err := storage.CreatePresignedImageUploadURL(r.Context(), handler.Storage, listing.ID, file.ContentType)
+		if err != nil {
+			functions.WriteError(w, http.StatusInternalServerError, "failed to create upload URL")
+			return
+		}
+
+		err = handler.UploadSlots.Save(r.Context(), storage.UploadSlot{
+			Key:         presigned.Key,
+			ListingID:   listing.ID,
+			UserID:      userID,
+			ContentType: file.ContentType,
+			Size:        file.Size,
+		})
+		if err != nil {
+			functions.WriteError(w, http.StatusInternalServerError, "failed to reserve upload")
+			return
+		}
+
+		uploads = append(uploads, uploadSlotResponse{
+			Key:         presigned.Key,
+			URL:         presigned.URL,
+			ContentType: file.ContentType,
+			Size:        file.Size,
+			ExpiresAt:   expiresAt,
+		})
+	}
+
+	functions.WriteJSON(w, http.StatusCreated, map[string]any{
+		"uploads": uploads,
+	})
+}
+
+// CompleteImageUploads checks that each uploaded object exists with the size and content type
+// its slot was issued for, processes it into variants and records the images on the listing
+func (handler Handler) CompleteImageUploads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return
+	}
+
+	listing, ok := handler.authorizeOwner(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := middleware.UserIDFromContext(r.Context())
+
+	var req completeUploadsReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if len(req.Uploads) == 0 {
+		functions.WriteError(w, http.StatusBadRequest, "at least one upload is required")
+		return
+	}
+	if len(req.Uploads) > maxUploadSlots {
+		functions.WriteError(w, http.StatusBadRequest, fmt.Sprintf("at most %d uploads can be completed at once", maxUploadSlots))
+		return
+	}
+
+	fields := make(map[string]string)
+	seen := make(map[string]bool, len(req.Uploads))
+	thumbnailFound := req.ThumbnailKey == ""
+
+	// slots are claimed up front so a concurrent complete of the same keys fails instead of recording
+	// the uploads twice, they are given back when this request does not record the images
+	var claimed []storage.UploadSlot
+	releaseClaims := func() {
+		for _, slot := range claimed {
+			if err := handler.UploadSlots.Save(context.WithoutCancel(r.Context()), slot); err != nil {
+				log.Printf("image uploads: give back slot %s: %v", slot.Key, err)
+			}
+		}
+	}
+
+	for i, upload := range req.Uploads {
+		field := fmt.Sprintf("uploads[%d].key", i)
+		key := strings.TrimSpace(upload.Key)
+		if key == "" {
+			fields[field] = "is required"
+			continue
+		}
+		if seen[key] {
+			fields[field] = "is listed more than once"
+			continue
+		}
+		seen[key] = true
+		if key == req.ThumbnailKey {
+			thumbnailFound = true
+		}
+		if len(strings.TrimSpace(upload.AltText)) > maxAltTextLength {
+			fields[fmt.Sprintf("uploads[%d].altText", i)] = fmt.Sprintf("must be at most %d characters", maxAltTextLength)
+		}
+
+		slot, message := handler.claimUpload(r, listing.ID, userID, key)
+		if message != "" {
+			fields[field] = message
+			continue
+		}
+		claimed = append(claimed, slot)
+	}
+	if !thumbnailFound {
+		fields["thumbnailKey"] = "must be one of the completed uploads"
+	}
+	if len(fields) > 0 {
+		releaseClaims()
+		functions.WriteFieldErrors(w, fields)
+		return
+	}
+
+	// the raw uploads are decoded and re-encoded into variants, only the variants are kept
+	storedImages := make([]storedImage, 0, len(req.Uploads))
+	cleanupStored := func() {
+		for _, stored := range storedImages {
+			deleteObjects(r.Context(), handler.Storage, stored.keys())
+		}
+		releaseClaims()
+	}
+
+	for i, upload := range req.Uploads {
+		key := strings.TrimSpace(upload.Key)
+
+		processed, err := handler.processUpload(r, key)
+		if errors.Is(err, imaging.ErrUnsupportedImage) || errors.Is(err, imaging.ErrImageTooLarge) {
+			cleanupStored()
+			handler.rejectUpload(r, key)
+			functions.WriteFieldErrors(w, map[string]string{
+				fmt.Sprintf("uploads[%d].key", i): err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			cleanupStored()
+			functions.WriteError(w, http.StatusInternalServerError, "failed to process uploaded image")
+			return
+		}
+
+		stored, err := storeListingImage(r.Context(), handler.Storage, listing.ID, processed)
+		if err != nil {
+			cleanupStored()
+			functions.WriteError(w, http.StatusInternalServerError, "failed to store image")
+			return
+		}
+		storedImages = append(storedImages, stored)
+	}
+
+	hashes := make([]uint64, len(storedImages))
+	for i, stored := range storedImages {
+		hashes[i] = stored.Hash
+	}
+	duplicates, err := handler.findDuplicatePhotos(r.Context(), userID, hashes)
+	if err != nil {
+		cleanupStored()
+		functions.WriteError(w, http.StatusInternalServerError, "failed to check listing images")
+		return
+	}
+
+	tx, err := handler.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		cleanupStored()
+		functions.WriteError(w, http.StatusInternalServerError, "failed to start transaction")
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	state, err := handler.ListingImages.ImageStateTx(r.Context(), tx, listing.ID)
+	if err != nil {
+		cleanupStored()
+		functions.WriteError(w, http.StatusInternalServerError, "failed to load listing images")
+		return
+	}
+	if state.Count+len(req.Uploads) > maxListingImages {
+		cleanupStored()
+		functions.WriteError(w, http.StatusConflict, fmt.Sprintf("a listing can have at most %d images", maxListingImages))
+		return
+	}
+
+	thumbnailKey := req.ThumbnailKey
+	if thumbnailKey != "" && state.HasThumbnail {
+		if err := handler.ListingImages.ClearThumbnailTx(r.Context(), tx, listing.ID); err != nil {
+			cleanupStored()
+			functions.WriteError(w, http.StatusInternalServerError, "failed to update thumbnail")
+			return
+		}
+	}
+	if thumbnailKey == "" && !state.HasThumbnail {
+		thumbnailKey = strings.TrimSpace(req.Uploads[0].Key)
+	}
+
+	keys := make([]string, 0, len(req.Uploads))
+	created := make([]listing_images.ListingImage, 0, len(req.Uploads))
+
+	for i, upload := range req.Uploads {
+		key := strings.TrimSpace(upload.Key)
+		keys = append(keys, key)
+
+		params := storedImages[i].insertParams(listing.ID, parseOptionalString(strings.TrimSpace(upload.AltText)), state.NextSortOrder+i, key == thumbnailKey)
+		image, err := handler.ListingImages.InsertListingImageTx(r.Context(), tx, params)
+		if err != nil {
+			cleanupStored()
+			functions.WriteError(w, http.StatusInternalServerError, "failed to save image metadata")
+			return
+		}
+		created = append(created, image)
+	}
+
+	// a live listing that now shows someone else's photos goes offline until a moderator clears it
+	updated, tookOffline := listing, false
+	if len(duplicates) > 0 {
+		if err := handler.flagDuplicatePhotosTx(r.Context(), tx, listing.ID, created, duplicates); err != nil {
+			cleanupStored()
+			functions.WriteError(w, http.StatusInternalServerError, "failed to flag listing")
+			return
+		}
+		updated, tookOffline, err = handler.takeOfflineTx(r.Context(), tx, listing)
+		if err != nil {
+			cleanupStored()
+			functions.WriteError(w, http.StatusInternalServerError, "failed to update listing status")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		cleanupStored()
+		functions.WriteError(w, http.StatusInternalServerError, "failed to commit transaction")
+		return
+	}
+
+	if tookOffline {
+		handler.notifyListingChanges(r.Context(), listing, updated)
+	}
+
+	// the images are recorded and the slots used up, leftover raw uploads are only wasted space
+	deleteObjects(r.Context(), handler.Storage, keys)
+
+	handler.presignImages(r.Context(), created)
+
+	functions.WriteJSON(w, http.StatusCreated, map[string]any{
+		"images":      created,
+		"underReview": len(duplicates) > 0,
+	})
+}
+
+// processUpload downloads a raw upload and renders its variants
+func (handler Handler) processUpload(r *http.Request, key string) (imaging.Result, error) {
+	body, err := handler.Storage.Get(r.Context(), key)
+	if err != nil {
+		return imaging.Result{}, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, handler.MaxUploadSize))
+	if err != nil {
+		return imaging.Result{}, err
+	}
+	return imaging.Process(data)
+}
+
+// claimUpload claims the upload's slot and returns why the upload can not be completed, or "" when it can.
+// An object that does not match its slot is rejected, the client has to request a new slot
+func (handler Handler) claimUpload(r *http.Request, listingID string, userID string, key string) (storage.UploadSlot, string) {
+	slot, err := handler.UploadSlots.Claim(r.Context(), key)
+	if errors.Is(err, storage.ErrUploadSlotNotFound) {
+		return storage.UploadSlot{}, "upload slot not found or expired"
+	}
+	if err != nil {
+		return storage.UploadSlot{}, "could not load upload slot"
+	}
+	if slot.ListingID != listingID || slot.UserID != userID {
+		// not this caller's slot, leave it to its owner
+		if err := handler.UploadSlots.Save(r.Context(), slot); err != nil {
+			log.Printf("image uploads: give back slot %s: %v", key, err)
+		}
+		return storage.UploadSlot{}, "upload slot not found or expired"
+	}
+
+	info, err := handler.Storage.Head(r.Context(), key)
+	if err != nil {
+		// the client may still be uploading, the slot stays usable
+		if saveErr := handler.UploadSlots.Save(r.Context(), slot); saveErr != nil {
+			log.Printf("image uploads: give back slot %s: %v", key, saveErr)
+		}
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return storage.UploadSlot{}, "file has not been uploaded"
+		}
+		return storage.UploadSlot{}, "could not check uploaded file"
+	}
+
+	reason := ""
+	if info.Size != slot.Size {
+		reason = fmt.Sprintf("uploaded file is %d bytes, expected %d", info.Size, slot.Size)
+	} else if mediaType, _, _ := mime.ParseMediaType(info.ContentType); mediaType != slot.ContentType {
+		reason = fmt.Sprintf("uploaded file is %s, expected %s", info.ContentType, slot.ContentType)
+	}
+	if reason == "" {
+		return slot, ""
+	}
+
+	handler.rejectUpload(r, key)
+	return storage.UploadSlot{}, reason
+}
+
+// rejectUpload deletes an upload that can not become an image together with its slot
+func (handler Handler) rejectUpload(r *http.Request, key string) {
+	deleteObjects(r.Context(), handler.Storage, []string{key})
+	if err := handler.UploadSlots.Delete(r.Context(), key); err != nil {
+		log.Printf("image uploads: release slot %s: %v", key, err)
+	}
+}
@@ -0,0 +1,72 @@
+package listing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"go-react-rooms/internal/repositories/listing_flags"
+	"go-react-rooms/internal/repositories/listing_images"
+	"go-react-rooms/internal/repositories/listings"
+	"log"
+)
+
+// maxPhotoDistance is how many of the 64 hash bits two photos may differ in and still count as the
+// same photo, enough for re-encodes, resizes and small crops without matching unrelated rooms
+const maxPhotoDistance = 6
+
+// duplicatePhoto is one entry in the details of a duplicate_photos flag
+type duplicatePhoto struct {
+	ImageID          string `json:"imageId"`
+	MatchedImageID   string `json:"matchedImageId"`
+	MatchedListingID string `json:"matchedListingId"`
+	Distance         int    `json:"distance"`
+}
+
+// findDuplicatePhotos looks for photos of other users' listings that match the new photos, the
+// matches refer to hashes by position
+func (handler Handler) findDuplicatePhotos(ctx context.Context, userID string, hashes []uint64) ([]listing_images.SimilarImage, error) {
+	signed := make([]int64, len(hashes))
+	for i, hash := range hashes {
+		signed[i] = int64(hash)
+	}
+	return handler.ListingImages.FindSimilar(ctx, userID, signed, maxPhotoDistance)
+}
+
+// flagDuplicatePhotosTx puts the listing in the moderation queue, images are the new images in the
+// order of the hashes the matches were found for
+func (handler Handler) flagDuplicatePhotosTx(ctx context.Context, tx *sql.Tx, listingID string, images []listing_images.ListingImage, matches []listing_images.SimilarImage) error {
+	details := make([]duplicatePhoto, 0, len(matches))
+	for _, match := range matches {
+		details = append(details, duplicatePhoto{
+			ImageID:          images[match.Index].ID,
+			MatchedImageID:   match.ImageID,
+			MatchedListingID: match.ListingID,
+			Distance:         match.Distance,
+		})
+	}
+
+	if _, err := handler.Flags.OpenTx(ctx, tx, listingID, listing_flags.ReasonDuplicatePhotos, details); err != nil {
+		return err
+	}
+
+	log.Printf("listing flags: %s reuses %d photos of other listings", listingID, len(details))
+	return nil
+}
+
+// takeOfflineTx moves a live listing that was just flagged back to inactive, it stays there until a
+// moderator resolves the flag. Returns whether the listing changed
+func (handler Handler) takeOfflineTx(ctx context.Context, tx *sql.Tx, listing listings.Listing) (listings.Listing, bool, error) {
+	if listing.Status != listings.StatusActive {
+		return listing, false, nil
+	}
+
+	updated, err := handler.Listings.ChangeStatusTx(ctx, tx, listing.ID, "", listings.StatusInactive)
+	// the listing left active since it was loaded, there is nothing to take down
+	if errors.Is(err, listings.ErrInvalidTransition) {
+		return listing, false, nil
+	}
+	if err != nil {
+		return listing, false, err
+	}
+	return updated, true, nil
+}
@@ -0,0 +1,132 @@
+package listing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-react-rooms/internal/repositories/listings"
+	"go-react-rooms/internal/repositories/notifications"
+	"time"
+)
+
+const (
+	NotificationRenewalReminder = "listing.renewal_reminder"
+	NotificationListingExpired  = "listing.expired"
+)
+
+// ExpiryListings is the part of listings.Repo the expiry job uses
+type ExpiryListings interface {
+	ListDueForReminder(ctx context.Context, remindBy time.Time, staleBefore time.Time) ([]listings.Listing, error)
+	MarkRenewalReminded(ctx context.Context, listingID string, at time.Time) error
+	ListExpired(ctx context.Context, today time.Time, staleBefore time.Time) ([]listings.Listing, error)
+	Expire(ctx context.Context, listingID string, today time.Time, staleBefore time.Time, at time.Time) (listings.Listing, error)
+}
+
+// ExpiryNotifications is the part of notifications.Repo the expiry job uses
+type ExpiryNotifications interface {
+	Insert(ctx context.Context, params notifications.InsertParams) (notifications.Notification, error)
+}
+
+// ExpiryJob deactivates active listings that are past their available_until date or were not
+// touched for StaleAfter, and reminds owners ReminderLead before that happens.
+// A listing reminded in a run is left for a later run so the owner always gets the reminder first
+type ExpiryJob struct {
+	Listings      ExpiryListings
+	Notifications ExpiryNotifications
+	StaleAfter    time.Duration
+	ReminderLead  time.Duration
+	// Now is the job clock, time.Now when nil
+	Now func() time.Time
+}
+
+func (job ExpiryJob) Run(ctx context.Context) error {
+	now := time.Now()
+	if job.Now != nil {
+		now = job.Now()
+	}
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	staleBefore := now.Add(-job.StaleAfter)
+
+	reminded, err := job.remind(ctx, now, today, staleBefore)
+	if err != nil {
+		return err
+	}
+	return job.expire(ctx, now, today, staleBefore, reminded)
+}
+
+// remind notifies the owners of listings about to expire and returns the reminded listing IDs
+func (job ExpiryJob) remind(ctx context.Context, now time.Time, today time.Time, staleBefore time.Time) (map[string]bool, error) {
+	due, err := job.Listings.ListDueForReminder(ctx, today.Add(job.ReminderLead), staleBefore.Add(job.ReminderLead))
+	if err != nil {
+		return nil, fmt.Errorf("list listings due for reminder: %w", err)
+	}
+
+	reminded := make(map[string]bool, len(due))
+	for _, listing := range due {
+		expiresOn := listing.UpdatedAt.Add(job.StaleAfter)
+		if listing.AvailableUntil != nil && listing.AvailableUntil.AddDate(0, 0, 1).Before(expiresOn) {
+			expiresOn = listing.AvailableUntil.AddDate(0, 0, 1)
+		}
+
+		body := fmt.Sprintf("“%s” will be unpublished on %s. Renew it or update its availability to keep it visible.", listing.Title, expiresOn.Format("January 2, 2006"))
+		_, err := job.Notifications.Insert(ctx, notifications.InsertParams{
+			UserID: listing.UserID,
+			Type:   NotificationRenewalReminder,
+			Title:  "Your listing is about to expire",
+			Body:   &body,
+			Data: map[string]any{
+				"listingId": listing.ID,
+				"expiresAt": expiresOn,
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("queue renewal reminder for listing %s: %w", listing.ID, err)
+		}
+
+		if err := job.Listings.MarkRenewalReminded(ctx, listing.ID, now); err != nil {
+			return nil, fmt.Errorf("mark listing %s reminded: %w", listing.ID, err)
+		}
+		reminded[listing.ID] = true
+	}
+
+	return reminded, nil
+}
+
+func (job ExpiryJob) expire(ctx context.Context, now time.Time, today time.Time, staleBefore time.Time, reminded map[string]bool) error {
+	expired, err := job.Listings.ListExpired(ctx, today, staleBefore)
+	if err != nil {
+		return fmt.Errorf("list expired listings: %w", err)
+	}
+
+	for _, listing := range expired {
+		if reminded[listing.ID] {
+			continue
+		}
+
+		_, err := job.Listings.Expire(ctx, listing.ID, today, staleBefore, now)
+		if errors.Is(err, listings.ErrListingNotExpired) {
+			// renewed or unpublished since it was listed
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("deactivate listing %s: %w", listing.ID, err)
+		}
+
+		body := fmt.Sprintf("“%s” is no longer visible in search. Publish it again once it is up to date.", listing.Title)
+		_, err = job.Notifications.Insert(ctx, notifications.InsertParams{
+			UserID: listing.UserID,
+			Type:   NotificationListingExpired,
+			Title:  "Your listing was unpublished",
+			Body:   &body,
+			Data: map[string]any{
+				"listingId": listing.ID,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("notify expiry of listing %s: %w", listing.ID, err)
+		}
+	}
+
+	return nil
+}
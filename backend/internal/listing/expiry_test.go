@@ -0,0 +1,161 @@
+package listing
+
+import (
+	"context"
+	"go-react-rooms/internal/repositories/listings"
+	"go-react-rooms/internal/repositories/notifications"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeExpiryListings applies the same rules as the SQL in listings/expiry.go to an in memory listing
+type fakeExpiryListings struct {
+	listing    listings.Listing
+	remindedAt *time.Time
+}
+
+func (fake *fakeExpiryListings) expiredBy(date time.Time, staleBefore time.Time) bool {
+	if fake.listing.Status != listings.StatusActive {
+		return false
+	}
+	if fake.listing.AvailableUntil != nil && fake.listing.AvailableUntil.Before(date) {
+		return true
+	}
+	return fake.listing.UpdatedAt.Before(staleBefore)
+}
+
+func (fake *fakeExpiryListings) ListDueForReminder(ctx context.Context, remindBy time.Time, staleBefore time.Time) ([]listings.Listing, error) {
+	if fake.remindedAt != nil && !fake.remindedAt.Before(fake.listing.UpdatedAt) {
+		return nil, nil
+	}
+	if !fake.expiredBy(remindBy, staleBefore) {
+		return nil, nil
+	}
+	return []listings.Listing{fake.listing}, nil
+}
+
+func (fake *fakeExpiryListings) MarkRenewalReminded(ctx context.Context, listingID string, at time.Time) error {
+	fake.remindedAt = &at
+	return nil
+}
+
+func (fake *fakeExpiryListings) ListExpired(ctx context.Context, today time.Time, staleBefore time.Time) ([]listings.Listing, error) {
+	if !fake.expiredBy(today, staleBefore) {
+		return nil, nil
+	}
+	return []listings.Listing{fake.listing}, nil
+}
+
+func (fake *fakeExpiryListings) Expire(ctx context.Context, listingID string, today time.Time, staleBefore time.Time, at time.Time) (listings.Listing, error) {
+	if !fake.expiredBy(today, staleBefore) {
+		return listings.Listing{}, listings.ErrListingNotExpired
+	}
+	fake.listing.Status = listings.StatusInactive
+	fake.listing.UpdatedAt = at
+	return fake.listing, nil
+}
+
+type fakeExpiryNotifications struct {
+	types []string
+}
+
+func (fake *fakeExpiryNotifications) Insert(ctx context.Context, params notifications.InsertParams) (notifications.Notification, error) {
+	fake.types = append(fake.types, params.Type)
+	return notifications.Notification{UserID: params.UserID, Type: params.Type}, nil
+}
+
+func TestExpiryJobRemindsBeforeExpiring(t *testing.T) {
+	start := time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	date := func(days int) *time.Time {
+		value := time.Date(2026, time.March, 1+days, 0, 0, 0, 0, time.UTC)
+		return &value
+	}
+
+	type run struct {
+		at        time.Time
+		types     []string
+		wantState string
+	}
+
+	tests := []struct {
+		name           string
+		updatedAt      time.Time
+		availableUntil *time.Time
+		runs           []run
+	}{
+		{
+			name:      "stale listing is reminded first and expires once stale",
+			updatedAt: start,
+			runs: []run{
+				{start.Add(20 * day), nil, listings.StatusActive},
+				{start.Add(23*day + time.Hour), []string{NotificationRenewalReminder}, listings.StatusActive},
+				{start.Add(24 * day), nil, listings.StatusActive},
+				{start.Add(30*day + time.Hour), []string{NotificationListingExpired}, listings.StatusInactive},
+				{start.Add(31 * day), nil, listings.StatusInactive},
+			},
+		},
+		{
+			name:      "already stale listing is not expired in the run that reminds it",
+			updatedAt: start.Add(-40 * day),
+			runs: []run{
+				{start, []string{NotificationRenewalReminder}, listings.StatusActive},
+				{start.Add(time.Hour), []string{NotificationListingExpired}, listings.StatusInactive},
+			},
+		},
+		{
+			name:           "listing past its available until date is reminded then expired",
+			updatedAt:      start,
+			availableUntil: date(5),
+			runs: []run{
+				{start.Add(2 * day), []string{NotificationRenewalReminder}, listings.StatusActive},
+				{start.Add(5 * day), nil, listings.StatusActive},
+				{start.Add(6 * day), []string{NotificationListingExpired}, listings.StatusInactive},
+			},
+		},
+		{
+			name:           "listing already past its date is reminded before it expires",
+			updatedAt:      start,
+			availableUntil: date(-1),
+			runs: []run{
+				{start, []string{NotificationRenewalReminder}, listings.StatusActive},
+				{start.Add(day), []string{NotificationListingExpired}, listings.StatusInactive},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeListings := &fakeExpiryListings{listing: listings.Listing{
+				ID:             "listing-1",
+				UserID:         "owner-1",
+				Title:          "Room near campus",
+				Status:         listings.StatusActive,
+				UpdatedAt:      test.updatedAt,
+				AvailableUntil: test.availableUntil,
+			}}
+
+			for i, run := range test.runs {
+				fakeNotifications := &fakeExpiryNotifications{}
+				job := ExpiryJob{
+					Listings:      fakeListings,
+					Notifications: fakeNotifications,
+					StaleAfter:    30 * day,
+					ReminderLead:  7 * day,
+					Now:           func() time.Time { return run.at },
+				}
+
+				if err := job.Run(context.Background()); err != nil {
+					t.Fatalf("run %d: %v", i, err)
+				}
+				if !reflect.DeepEqual(fakeNotifications.types, run.types) {
+					t.Errorf("run %d: notifications = %v, want %v", i, fakeNotifications.types, run.types)
+				}
+				if fakeListings.listing.Status != run.wantState {
+					t.Errorf("run %d: status = %s, want %s", i, fakeListings.listing.Status, run.wantState)
+				}
+			}
+		})
+	}
+}
@@ -1,27 +1,52 @@
 package listing
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/imaging"
 	"go-react-rooms/internal/middleware"
+	"go-react-rooms/internal/repositories/amenities"
+	"go-react-rooms/internal/repositories/listing_flags"
 	"go-react-rooms/internal/repositories/listing_images"
 	"go-react-rooms/internal/repositories/listings"
+	"go-react-rooms/internal/repositories/notifications"
+	"go-react-rooms/internal/repositories/roommate_preferences"
+	"go-react-rooms/internal/repositories/saved_listings"
+	"go-react-rooms/internal/savedsearch"
 	"go-react-rooms/internal/storage"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 type Handler struct {
-	Listings      listings.Repo
-	ListingImages listing_images.Repo
-	S3            *storage.S3Storage
+	Listings            listings.Repo
+	ListingImages       listing_images.Repo
+	Amenities           amenities.Repo
+	SavedListings       saved_listings.Repo
+	Notifications       notifications.Repo
+	RoommatePreferences roommate_preferences.Repo
+	// moderation queue, listings reusing other users' photos are flagged
+	Flags listing_flags.Repo
+	// alerts saved searches when a listing goes live
+	SearchAlerts savedsearch.Matcher
+	Storage      storage.ObjectStore
+	URLs         *storage.URLSigner
+	// pending direct uploads and the size limit for each file
+	UploadSlots   *storage.UploadSlots
+	MaxUploadSize int64
 	DB            *sql.DB
 }
 
 type CreateListingResponse struct {
-	Listing listings.Listing              `json:"listing"`
-	Images  []listing_images.ListingImage `json:"images"`
+	Listing   listings.Listing              `json:"listing"`
+	Images    []listing_images.ListingImage `json:"images"`
+	Amenities []amenities.Amenity           `json:"amenities"`
+	// the photos match another user's listing, it stays a draft until a moderator clears it
+	UnderReview bool `json:"underReview,omitempty"`
 }
 
 func (h Handler) CreateListing(w http.ResponseWriter, r *http.Request) {
@@ -147,11 +172,20 @@ func (h Handler) CreateListing(w http.ResponseWriter, r *http.Request) {
 		Status:           r.FormValue("status"),
 	}
 
-	if params.Title == "" || params.AddressLine1 == "" || params.City == "" || params.Province == "" || params.Country == "" || params.AreaUnit == "" || params.Currency == "" || params.Status == "" {
+	if params.Title == "" || params.AddressLine1 == "" || params.City == "" || params.Province == "" || params.Country == "" || params.AreaUnit == "" || params.Currency == "" {
 		functions.WriteError(w, http.StatusBadRequest, "missing required listing fields")
 		return
 	}
 
+	// a listing starts as a draft unless it is published right away, later changes go through the status actions
+	if params.Status == "" {
+		params.Status = listings.StatusDraft
+	}
+	if params.Status != listings.StatusDraft && params.Status != listings.StatusActive {
+		functions.WriteError(w, http.StatusBadRequest, "status must be draft or active")
+		return
+	}
+
 	files := r.MultipartForm.File["files"]
 	if len(files) == 0 {
 		functions.WriteError(w, http.StatusBadRequest, "at least one file is required")
@@ -173,18 +207,13 @@ func (h Handler) CreateListing(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	altTextValue := r.FormValue("altText")
-	var altText *string
-	if altTextValue != "" {
-		altText = &altTextValue
-	}
-
-	type validatedFile struct {
-		ContentType string
-		Data        []byte
+	altTexts, err := parseAltTexts(r.MultipartForm.Value["altTexts"], r.FormValue("altText"), len(files))
+	if err != nil {
+		functions.WriteError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	validatedFiles := make([]validatedFile, 0, len(files))
+	processedFiles := make([]imaging.Result, 0, len(files))
 
 	for _, fileHeader := range files {
 		file, err := fileHeader.Open()
@@ -221,10 +250,26 @@ func (h Handler) CreateListing(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		validatedFiles = append(validatedFiles, validatedFile{
-			ContentType: contentType,
-			Data:        data,
-		})
+		processed, err := imaging.Process(data)
+		if err != nil {
+			writeImagingError(w, err)
+			return
+		}
+		processedFiles = append(processedFiles, processed)
+	}
+
+	hashes := make([]uint64, len(processedFiles))
+	for i, processed := range processedFiles {
+		hashes[i] = processed.Hash
+	}
+	duplicates, err := h.findDuplicatePhotos(r.Context(), userID, hashes)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to check listing images")
+		return
+	}
+	// a flagged listing waits for moderation instead of going live
+	if len(duplicates) > 0 {
+		params.Status = listings.StatusDraft
 	}
 
 	tx, err := h.DB.BeginTx(r.Context(), nil)
@@ -233,11 +278,9 @@ func (h Handler) CreateListing(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	uploadedKeys := make([]string, 0, len(validatedFiles))
+	uploadedKeys := make([]string, 0, len(processedFiles)*len(imaging.Specs))
 	cleanupS3 := func() {
-		for _, key := range uploadedKeys {
-			_ = h.S3.DeleteObject(r.Context(), key)
-		}
+		deleteObjects(r.Context(), h.Storage, uploadedKeys)
 	}
 
 	defer func() {
@@ -250,25 +293,29 @@ func (h Handler) CreateListing(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createdImages := make([]listing_images.ListingImage, 0, len(validatedFiles))
+	if err := h.Listings.RecordStatusTx(r.Context(), tx, listing.ID, nil, listing.Status, userID); err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to record listing status")
+		return
+	}
+
+	if err := h.Amenities.SetForListingTx(r.Context(), tx, listing.ID, r.MultipartForm.Value["amenities"]); err != nil {
+		writeAmenityError(w, err)
+		return
+	}
+
+	createdImages := make([]listing_images.ListingImage, 0, len(processedFiles))
 
-	for i, file := range validatedFiles {
-		s3Key, err := h.S3.UploadListingImage(r.Context(), listing.ID, file.ContentType, file.Data)
+	for i, processed := range processedFiles {
+		stored, err := storeListingImage(r.Context(), h.Storage, listing.ID, processed)
 		if err != nil {
 			cleanupS3()
 			functions.WriteError(w, http.StatusInternalServerError, "failed to upload image to S3")
 			return
 		}
 
-		uploadedKeys = append(uploadedKeys, s3Key)
+		uploadedKeys = append(uploadedKeys, stored.keys()...)
 
-		image, err := h.ListingImages.InsertListingImageTx(r.Context(), tx, listing_images.InsertListingImageParams{
-			ListingID:   listing.ID,
-			S3Key:       s3Key,
-			AltText:     altText,
-			SortOrder:   i,
-			IsThumbnail: i == thumbnailIndex,
-		})
+		image, err := h.ListingImages.InsertListingImageTx(r.Context(), tx, stored.insertParams(listing.ID, altTexts[i], i, i == thumbnailIndex))
 		if err != nil {
 			cleanupS3()
 			functions.WriteError(w, http.StatusInternalServerError, "failed to save image metadata")
@@ -278,26 +325,107 @@ func (h Handler) CreateListing(w http.ResponseWriter, r *http.Request) {
 		createdImages = append(createdImages, image)
 	}
 
+	if len(duplicates) > 0 {
+		if err := h.flagDuplicatePhotosTx(r.Context(), tx, listing.ID, createdImages, duplicates); err != nil {
+			cleanupS3()
+			functions.WriteError(w, http.StatusInternalServerError, "failed to flag listing")
+			return
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
 		cleanupS3()
 		functions.WriteError(w, http.StatusInternalServerError, "failed to commit transaction")
 		return
 	}
 
+	// amenities are committed at this point, saved searches filtering on them can match
+	h.SearchAlerts.ListingActivated(r.Context(), listing)
+
+	listingAmenities, err := h.Amenities.ListForListing(r.Context(), listing.ID)
+	if err != nil {
+		listingAmenities = []amenities.Amenity{}
+	}
+
 	functions.WriteJSON(w, http.StatusCreated, CreateListingResponse{
-		Listing: listing,
-		Images:  createdImages,
+		Listing:     listing,
+		Images:      createdImages,
+		Amenities:   listingAmenities,
+		UnderReview: len(duplicates) > 0,
 	})
 }
 
 func (handler Handler) ListListings(w http.ResponseWriter, r *http.Request) {
-	items, err := handler.Listings.GetAllListings(r.Context())
+	if r.Method != http.MethodGet {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use GET")
+		return
+	}
+
+	query := r.URL.Query()
+
+	params, err := parseSearchParams(query)
 	if err != nil {
+		functions.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// the public feed only shows active listings, owners can browse their own listings in any status
+	params.Status = listings.StatusActive
+	if query.Get("mine") == "true" {
+		userID, ok := middleware.UserIDFromContext(r.Context())
+		if !ok || userID == "" {
+			functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		params.UserID = userID
+		params.Status = strings.TrimSpace(query.Get("status"))
+		if params.Status != "" && !listings.IsValidStatus(params.Status) {
+			functions.WriteError(w, http.StatusBadRequest, listings.ErrInvalidStatus.Error())
+			return
+		}
+	}
+
+	result, err := handler.Listings.Search(r.Context(), params)
+	if err != nil {
+		if errors.Is(err, listings.ErrInvalidCursor) || errors.Is(err, listings.ErrInvalidSort) {
+			functions.WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		functions.WriteError(w, http.StatusInternalServerError, "could not list listings")
 		return
 	}
 
-	functions.WriteJSON(w, http.StatusOK, map[string]any{
-		"listings": items,
-	})
+	items := make([]*listings.Listing, len(result.Listings))
+	for i := range result.Listings {
+		items[i] = &result.Listings[i]
+	}
+	handler.presignThumbnails(r.Context(), items)
+	handler.markSaved(r.Context(), items)
+
+	functions.WriteJSON(w, http.StatusOK, result)
+}
+
+// presignThumbnails swaps each thumbnail S3 key for a GET URL, signed in one batch
+// a listing whose thumbnail cannot be signed is still returned, just without its thumbnail
+func (handler Handler) presignThumbnails(ctx context.Context, items []*listings.Listing) {
+	keys := make([]string, 0, len(items))
+	for _, listing := range items {
+		if len(listing.Images) > 0 {
+			keys = append(keys, listing.Images[0].S3Key)
+		}
+	}
+
+	urls := handler.URLs.SignMany(ctx, keys)
+	for _, listing := range items {
+		if len(listing.Images) == 0 {
+			continue
+		}
+		thumbnailURL, ok := urls[listing.Images[0].S3Key]
+		if !ok {
+			listing.Images = nil
+			continue
+		}
+		listing.Images[0].S3Key = thumbnailURL
+	}
 }
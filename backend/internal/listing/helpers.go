@@ -3,6 +3,7 @@ package listing
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,6 +14,27 @@ func parseOptionalString(value string) *string {
 	return &value
 }
 
+// parseAltTexts pairs the altTexts form values with the uploaded files by position,
+// files without their own alt text fall back to the single altText value
+func parseAltTexts(values []string, fallback string, files int) ([]*string, error) {
+	if len(values) > files {
+		return nil, fmt.Errorf("got %d altTexts for %d files", len(values), files)
+	}
+
+	out := make([]*string, files)
+	for i := range out {
+		value := strings.TrimSpace(fallback)
+		if i < len(values) && strings.TrimSpace(values[i]) != "" {
+			value = strings.TrimSpace(values[i])
+		}
+		if len(value) > maxAltTextLength {
+			return nil, fmt.Errorf("altText of file %d must be at most %d characters", i, maxAltTextLength)
+		}
+		out[i] = parseOptionalString(value)
+	}
+	return out, nil
+}
+
 func parseOptionalInt(value string) (*int, error) {
 	if value == "" {
 		return nil, nil
@@ -58,3 +80,36 @@ func parseRequiredBool(value string, field string) (bool, error) {
 	}
 	return b, nil
 }
+
+func parseOptionalFloat(value string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func parseOptionalBool(value string) (*bool, error) {
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// parseOptionalDate accepts either a plain date (2006-01-02) or a RFC3339 datetime
+func parseOptionalDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return &t, nil
+	}
+	return parseOptionalTime(value)
+}
@@ -0,0 +1,108 @@
+package listing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/imaging"
+	"go-react-rooms/internal/repositories/listing_images"
+	"go-react-rooms/internal/storage"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// storedImage is a processed photo whose variants are already in object storage
+type storedImage struct {
+	ID       string
+	Width    int
+	Height   int
+	Hash     uint64
+	Variants []listing_images.VariantParams
+}
+
+// storeListingImage processes a photo and uploads every variant under listings/{id}/images/{imageID}/{variant},
+// when one upload fails the variants already stored are removed again
+func storeListingImage(ctx context.Context, store storage.ObjectStore, listingID string, processed imaging.Result) (storedImage, error) {
+	image := storedImage{
+		ID:     uuid.NewString(),
+		Width:  processed.Width,
+		Height: processed.Height,
+		Hash:   processed.Hash,
+	}
+
+	for _, variant := range processed.Variants {
+		key := storage.BuildListingImageVariantKey(listingID, image.ID, variant.Name)
+		if err := store.Put(ctx, key, variant.ContentType, bytes.NewReader(variant.Data), int64(len(variant.Data))); err != nil {
+			deleteObjects(ctx, store, image.keys())
+			return storedImage{}, err
+		}
+
+		image.Variants = append(image.Variants, listing_images.VariantParams{
+			Variant:     variant.Name,
+			S3Key:       key,
+			ContentType: variant.ContentType,
+			Width:       variant.Width,
+			Height:      variant.Height,
+			SizeBytes:   int64(len(variant.Data)),
+		})
+	}
+
+	return image, nil
+}
+
+func (image storedImage) keys() []string {
+	keys := make([]string, 0, len(image.Variants))
+	for _, variant := range image.Variants {
+		keys = append(keys, variant.S3Key)
+	}
+	return keys
+}
+
+// insertParams describes the image row, s3_key points at the full variant
+func (image storedImage) insertParams(listingID string, altText *string, sortOrder int, isThumbnail bool) listing_images.InsertListingImageParams {
+	hash := int64(image.Hash)
+	params := listing_images.InsertListingImageParams{
+		ID:          image.ID,
+		ListingID:   listingID,
+		AltText:     altText,
+		SortOrder:   sortOrder,
+		IsThumbnail: isThumbnail,
+		Width:       &image.Width,
+		Height:      &image.Height,
+		PHash:       &hash,
+		Variants:    image.Variants,
+	}
+	for _, variant := range image.Variants {
+		if variant.Variant == imaging.VariantFull {
+			params.S3Key = variant.S3Key
+		}
+	}
+	return params
+}
+
+// deleteObjects is best effort, an object left behind is only wasted space until ObjectGCJob finds it.
+// It runs detached from ctx cancellation because cleanup usually follows a failed or finished request
+// whose client may already be gone
+func deleteObjects(ctx context.Context, store storage.ObjectStore, keys []string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+
+	for _, key := range keys {
+		if err := store.Delete(ctx, key); err != nil {
+			log.Printf("listing images: delete object %s: %v", key, err)
+		}
+	}
+}
+
+func writeImagingError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, imaging.ErrUnsupportedImage), errors.Is(err, imaging.ErrImageTooLarge):
+		functions.WriteError(w, http.StatusBadRequest, err.Error())
+	default:
+		functions.WriteError(w, http.StatusInternalServerError, "failed to process image")
+	}
+}
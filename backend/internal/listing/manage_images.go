@@ -0,0 +1,203 @@
+package listing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/repositories/listing_images"
+	"net/http"
+	"strings"
+)
+
+const maxAltTextLength = 300
+
+type reorderImagesReq struct {
+	ImageIDs []string `json:"imageIds"`
+}
+
+type updateImageReq struct {
+	// null or "" clears the alt text
+	AltText *string `json:"altText"`
+}
+
+// ReorderImages sets the order of all images of a listing, the body lists every image id once
+func (handler Handler) ReorderImages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use PUT")
+		return
+	}
+
+	listing, ok := handler.authorizeOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req reorderImagesReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if len(req.ImageIDs) == 0 {
+		functions.WriteError(w, http.StatusBadRequest, "imageIds is required")
+		return
+	}
+
+	tx, err := handler.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to start transaction")
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := handler.ListingImages.ReorderTx(r.Context(), tx, listing.ID, req.ImageIDs); err != nil {
+		writeImageError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to commit transaction")
+		return
+	}
+
+	handler.writeListingImages(w, r, listing.ID)
+}
+
+// HandleImage routes /listings/{id}/images/{imageId}: PATCH edits the alt text, DELETE removes the image
+func (handler Handler) HandleImage(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPatch:
+		handler.updateImage(w, r)
+	case http.MethodDelete:
+		handler.deleteImage(w, r)
+	default:
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (handler Handler) updateImage(w http.ResponseWriter, r *http.Request) {
+	listing, ok := handler.authorizeOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req updateImageReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	var altText *string
+	if req.AltText != nil {
+		altText = parseOptionalString(strings.TrimSpace(*req.AltText))
+	}
+	if altText != nil && len(*altText) > maxAltTextLength {
+		functions.WriteFieldErrors(w, map[string]string{
+			"altText": fmt.Sprintf("must be at most %d characters", maxAltTextLength),
+		})
+		return
+	}
+
+	image, err := handler.ListingImages.SetAltText(r.Context(), listing.ID, r.PathValue("imageId"), altText)
+	if err != nil {
+		writeImageError(w, err)
+		return
+	}
+
+	images := []listing_images.ListingImage{image}
+	handler.presignImages(r.Context(), images)
+
+	functions.WriteJSON(w, http.StatusOK, images[0])
+}
+
+func (handler Handler) deleteImage(w http.ResponseWriter, r *http.Request) {
+	listing, ok := handler.authorizeOwner(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := handler.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to start transaction")
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	deleted, err := handler.ListingImages.DeleteTx(r.Context(), tx, listing.ID, r.PathValue("imageId"))
+	if err != nil {
+		writeImageError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to commit transaction")
+		return
+	}
+
+	deleteObjects(r.Context(), handler.Storage, deleted.ObjectKeys())
+
+	handler.writeListingImages(w, r, listing.ID)
+}
+
+// SetThumbnail makes one image the listing thumbnail
+func (handler Handler) SetThumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return
+	}
+
+	listing, ok := handler.authorizeOwner(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := handler.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to start transaction")
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err := handler.ListingImages.SetThumbnailTx(r.Context(), tx, listing.ID, r.PathValue("imageId")); err != nil {
+		writeImageError(w, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to commit transaction")
+		return
+	}
+
+	handler.writeListingImages(w, r, listing.ID)
+}
+
+// writeListingImages responds with the current images of a listing in display order
+func (handler Handler) writeListingImages(w http.ResponseWriter, r *http.Request, listingID string) {
+	images, err := handler.ListingImages.ListByListing(r.Context(), listingID)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not load listing images")
+		return
+	}
+	handler.presignImages(r.Context(), images)
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"images": images,
+	})
+}
+
+func writeImageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, listing_images.ErrImageNotFound):
+		functions.WriteError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, listing_images.ErrImageOrderMismatch):
+		functions.WriteError(w, http.StatusBadRequest, err.Error())
+	default:
+		functions.WriteError(w, http.StatusInternalServerError, "listing image request failed")
+	}
+}
@@ -0,0 +1,154 @@
+package listing
+
+import (
+	"errors"
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/repositories/listings"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// below this zoom level viewport queries return grid clusters instead of individual listings
+const clusterMaxZoom = 12
+
+type MapResponse struct {
+	Listings []listings.MapListing `json:"listings,omitempty"`
+	Clusters []listings.Cluster    `json:"clusters,omitempty"`
+}
+
+// ListingsMap serves map queries, either around a point:
+//
+//	GET /listings/map?lat=43.65&lng=-79.38&radiusKm=5
+//
+// or inside a viewport, clustered when zoomed out:
+//
+//	GET /listings/map?north=..&south=..&east=..&west=..&zoom=11
+//
+// both forms accept the same filters as /listings
+func (handler Handler) ListingsMap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use GET")
+		return
+	}
+
+	query := r.URL.Query()
+
+	filters, err := parseSearchParams(query)
+	if err != nil {
+		functions.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filters.Status = listings.StatusActive
+
+	var response MapResponse
+
+	if strings.TrimSpace(query.Get("radiusKm")) != "" {
+		params, err := parseRadiusParams(query)
+		if err != nil {
+			functions.WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		params.Filters = filters
+
+		response.Listings, err = handler.Listings.WithinRadius(r.Context(), params)
+		if err != nil {
+			writeMapError(w, err)
+			return
+		}
+	} else {
+		params, err := parseBoundsParams(query)
+		if err != nil {
+			functions.WriteError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		params.Filters = filters
+
+		zoom, err := parseOptionalInt(query.Get("zoom"))
+		if err != nil {
+			functions.WriteError(w, http.StatusBadRequest, "zoom must be an integer")
+			return
+		}
+
+		if zoom != nil && *zoom < clusterMaxZoom {
+			response.Clusters, err = handler.Listings.Clusters(r.Context(), params, listings.ClusterCellSize(*zoom))
+		} else {
+			response.Listings, err = handler.Listings.WithinBounds(r.Context(), params)
+		}
+		if err != nil {
+			writeMapError(w, err)
+			return
+		}
+	}
+
+	items := make([]*listings.Listing, len(response.Listings))
+	for i := range response.Listings {
+		items[i] = &response.Listings[i].Listing
+	}
+	handler.presignThumbnails(r.Context(), items)
+	handler.markSaved(r.Context(), items)
+
+	functions.WriteJSON(w, http.StatusOK, response)
+}
+
+func writeMapError(w http.ResponseWriter, err error) {
+	if errors.Is(err, listings.ErrInvalidBounds) || errors.Is(err, listings.ErrInvalidRadius) {
+		functions.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	functions.WriteError(w, http.StatusInternalServerError, "could not list listings")
+}
+
+func parseRadiusParams(query url.Values) (listings.RadiusParams, error) {
+	var params listings.RadiusParams
+	var err error
+
+	if params.Latitude, err = parseRequiredFloat(query.Get("lat"), "lat"); err != nil {
+		return params, err
+	}
+	if params.Longitude, err = parseRequiredFloat(query.Get("lng"), "lng"); err != nil {
+		return params, err
+	}
+	if params.RadiusKm, err = parseRequiredFloat(query.Get("radiusKm"), "radiusKm"); err != nil {
+		return params, err
+	}
+	if raw := strings.TrimSpace(query.Get("limit")); raw != "" {
+		if params.Limit, err = strconv.Atoi(raw); err != nil {
+			return params, errors.New("limit must be an integer")
+		}
+	}
+
+	return params, nil
+}
+
+func parseBoundsParams(query url.Values) (listings.BoundsParams, error) {
+	var params listings.BoundsParams
+	var err error
+
+	if params.North, err = parseRequiredFloat(query.Get("north"), "north"); err != nil {
+		return params, err
+	}
+	if params.South, err = parseRequiredFloat(query.Get("south"), "south"); err != nil {
+		return params, err
+	}
+	if params.East, err = parseRequiredFloat(query.Get("east"), "east"); err != nil {
+		return params, err
+	}
+	if params.West, err = parseRequiredFloat(query.Get("west"), "west"); err != nil {
+		return params, err
+	}
+	if params.Latitude, err = parseOptionalFloat(query.Get("lat")); err != nil {
+		return params, errors.New("lat must be a number")
+	}
+	if params.Longitude, err = parseOptionalFloat(query.Get("lng")); err != nil {
+		return params, errors.New("lng must be a number")
+	}
+	if raw := strings.TrimSpace(query.Get("limit")); raw != "" {
+		if params.Limit, err = strconv.Atoi(raw); err != nil {
+			return params, errors.New("limit must be an integer")
+		}
+	}
+
+	return params, nil
+}
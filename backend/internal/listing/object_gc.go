@@ -0,0 +1,143 @@
+package listing
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"go-react-rooms/internal/repositories/listing_images"
+	"go-react-rooms/internal/storage"
+	"log"
+	"time"
+)
+
+// listing images, variants and pending direct uploads all live under this prefix
+const listingObjectPrefix = "listings/"
+
+const (
+	objectGCBatchSize = 500
+	// dry runs log each orphan up to this many, the totals are always logged
+	objectGCReportLimit = 100
+)
+
+// objectGCMetrics accumulate over the life of the process and are served on /debug/vars
+var objectGCMetrics = expvar.NewMap("object_gc")
+
+// objectGCReport summarizes one run of ObjectGCJob
+type objectGCReport struct {
+	DryRun bool
+	// objects listed under listingObjectPrefix
+	Scanned int
+	// objects younger than the grace period, never checked
+	Recent int
+	// objects older than the grace period that no image references
+	Orphans     int
+	OrphanBytes int64
+	// only counted when not a dry run
+	Deleted        int
+	BytesReclaimed int64
+	DeleteErrors   int
+}
+
+// ObjectGCJob deletes stored listing objects that no listing_images row references, such as
+// uploads whose request failed before cleanup or direct uploads that were never completed.
+// Objects younger than GracePeriod are left alone since they may belong to an upload in progress
+type ObjectGCJob struct {
+	ListingImages listing_images.Repo
+	Storage       storage.ObjectStore
+	GracePeriod   time.Duration
+	// DryRun reports the orphans without deleting them
+	DryRun bool
+	// Now is the job clock, time.Now when nil
+	Now func() time.Time
+}
+
+func (job ObjectGCJob) Run(ctx context.Context) error {
+	now := time.Now()
+	if job.Now != nil {
+		now = job.Now()
+	}
+	cutoff := now.Add(-job.GracePeriod)
+
+	report := objectGCReport{DryRun: job.DryRun}
+	batch := make([]storage.ObjectInfo, 0, objectGCBatchSize)
+
+	err := job.Storage.List(ctx, listingObjectPrefix, func(object storage.ObjectInfo) error {
+		report.Scanned++
+		if object.LastModified.After(cutoff) {
+			report.Recent++
+			return nil
+		}
+
+		batch = append(batch, object)
+		if len(batch) < objectGCBatchSize {
+			return nil
+		}
+		err := job.sweep(ctx, batch, &report)
+		batch = batch[:0]
+		return err
+	})
+	if err == nil && len(batch) > 0 {
+		err = job.sweep(ctx, batch, &report)
+	}
+
+	job.record(report)
+	if err != nil {
+		return fmt.Errorf("collect orphaned objects: %w", err)
+	}
+
+	if report.DryRun {
+		log.Printf("object gc: dry run, %d objects scanned, %d orphans (%d bytes) would be deleted", report.Scanned, report.Orphans, report.OrphanBytes)
+	} else {
+		log.Printf("object gc: %d objects scanned, %d orphans, %d deleted, %d bytes reclaimed, %d delete errors", report.Scanned, report.Orphans, report.Deleted, report.BytesReclaimed, report.DeleteErrors)
+	}
+	return nil
+}
+
+// sweep deletes the objects of one batch that are not referenced, a failed delete is
+// counted and retried on the next run
+func (job ObjectGCJob) sweep(ctx context.Context, batch []storage.ObjectInfo, report *objectGCReport) error {
+	keys := make([]string, len(batch))
+	for i, object := range batch {
+		keys[i] = object.Key
+	}
+
+	referenced, err := job.ListingImages.ReferencedKeys(ctx, keys)
+	if err != nil {
+		return fmt.Errorf("load referenced keys: %w", err)
+	}
+
+	for _, object := range batch {
+		if referenced[object.Key] {
+			continue
+		}
+
+		report.Orphans++
+		report.OrphanBytes += object.Size
+
+		if job.DryRun {
+			if report.Orphans <= objectGCReportLimit {
+				log.Printf("object gc: dry run, would delete %s (%d bytes, modified %s)", object.Key, object.Size, object.LastModified.Format(time.RFC3339))
+			}
+			continue
+		}
+
+		if err := job.Storage.Delete(ctx, object.Key); err != nil {
+			report.DeleteErrors++
+			log.Printf("object gc: delete %s: %v", object.Key, err)
+			continue
+		}
+		report.Deleted++
+		report.BytesReclaimed += object.Size
+	}
+	return nil
+}
+
+func (job ObjectGCJob) record(report objectGCReport) {
+	objectGCMetrics.Add("runs", 1)
+	objectGCMetrics.Add("objects_scanned", int64(report.Scanned))
+	objectGCMetrics.Add("orphans_found", int64(report.Orphans))
+	objectGCMetrics.Add("orphan_bytes_found", report.OrphanBytes)
+	objectGCMetrics.Add("objects_deleted", int64(report.Deleted))
+	objectGCMetrics.Add("bytes_reclaimed", report.BytesReclaimed)
+	objectGCMetrics.Add("delete_errors", int64(report.DeleteErrors))
+}
@@ -0,0 +1,126 @@
+package listing
+
+import (
+	"errors"
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/matching"
+	"go-react-rooms/internal/middleware"
+	"go-react-rooms/internal/repositories/listings"
+	"go-react-rooms/internal/repositories/roommate_preferences"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// candidates are the newest active listings that pass the hard filters, scored in memory
+const recommendationCandidatePages = 3
+
+type RecommendedListing struct {
+	Listing listings.Listing `json:"listing"`
+	Match   matching.Match   `json:"match"`
+}
+
+// RecommendedListings ranks active listings against the caller's roommate preferences
+//
+//	GET /listings/recommended?limit=20
+func (handler Handler) RecommendedListings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use GET")
+		return
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	limit := 20
+	if requestLimit := strings.TrimSpace(r.URL.Query().Get("limit")); requestLimit != "" {
+		if requestLimitToInt, err := strconv.Atoi(requestLimit); err == nil && requestLimitToInt > 0 && requestLimitToInt <= 50 {
+			limit = requestLimitToInt
+		}
+	}
+
+	preferences, err := handler.RoommatePreferences.Get(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, roommate_preferences.ErrPreferencesNotFound) {
+			functions.WriteError(w, http.StatusNotFound, "set your roommate preferences to get recommendations")
+			return
+		}
+		functions.WriteError(w, http.StatusInternalServerError, "could not load roommate preferences")
+		return
+	}
+
+	wanted, err := handler.Amenities.KeysForPreferences(r.Context(), []string{userID})
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not load preference amenities")
+		return
+	}
+	seeker := matching.Seeker{Preferences: preferences, Amenities: wanted[userID]}
+
+	// province and a price ceiling are hard filters, everything else only affects the score
+	params := listings.SearchParams{
+		Status: listings.StatusActive,
+		Sort:   listings.SortNewest,
+		Limit:  100,
+	}
+	if preferences.PreferredProvince != nil {
+		params.Province = *preferences.PreferredProvince
+	}
+	if preferences.BudgetMax != nil {
+		ceiling := *preferences.BudgetMax * (1 + matching.OverBudgetTolerance)
+		params.PriceMax = &ceiling
+	}
+
+	var candidates []listings.Listing
+	for page := 0; page < recommendationCandidatePages; page++ {
+		result, err := handler.Listings.Search(r.Context(), params)
+		if err != nil {
+			functions.WriteError(w, http.StatusInternalServerError, "could not list listings")
+			return
+		}
+		for _, listing := range result.Listings {
+			if listing.UserID != userID {
+				candidates = append(candidates, listing)
+			}
+		}
+		if result.NextCursor == "" {
+			break
+		}
+		params.Cursor = result.NextCursor
+	}
+
+	ids := make([]string, len(candidates))
+	for i, listing := range candidates {
+		ids[i] = listing.ID
+	}
+	listingAmenities, err := handler.Amenities.KeysForListings(r.Context(), ids)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not load listing amenities")
+		return
+	}
+
+	matches := make([]matching.Match, len(candidates))
+	for i, listing := range candidates {
+		matches[i] = matching.ScoreListing(seeker, listing, listingAmenities[listing.ID])
+	}
+
+	ranked := matching.Rank(matches)
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	out := make([]RecommendedListing, len(ranked))
+	items := make([]*listings.Listing, len(ranked))
+	for i, rank := range ranked {
+		out[i] = RecommendedListing{Listing: candidates[rank.Index], Match: rank.Match}
+		items[i] = &out[i].Listing
+	}
+	handler.presignThumbnails(r.Context(), items)
+	handler.markSaved(r.Context(), items)
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"recommendations": out,
+	})
+}
@@ -0,0 +1,185 @@
+package listing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/middleware"
+	"go-react-rooms/internal/repositories/listings"
+	"go-react-rooms/internal/repositories/notifications"
+	"go-react-rooms/internal/repositories/saved_listings"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	NotificationSavedPriceDrop = "saved_listing.price_drop"
+	NotificationSavedRented    = "saved_listing.rented"
+)
+
+// HandleSave routes /listings/{id}/save: POST saves the listing, DELETE removes it from the saved list
+func (handler Handler) HandleSave(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	listingID := strings.TrimSpace(r.PathValue("id"))
+
+	switch r.Method {
+	case http.MethodPost:
+		listing, err := handler.Listings.GetByID(r.Context(), listingID)
+		if err != nil {
+			writeListingError(w, err)
+			return
+		}
+		if listing.Status != listings.StatusActive && listing.UserID != userID {
+			writeListingError(w, listings.ErrListingNotFound)
+			return
+		}
+
+		if err := handler.SavedListings.Save(r.Context(), userID, listing.ID); err != nil {
+			writeSavedError(w, err)
+			return
+		}
+	case http.MethodDelete:
+		if err := handler.SavedListings.Unsave(r.Context(), userID, listingID); err != nil {
+			writeSavedError(w, err)
+			return
+		}
+	default:
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"listingId": listingID,
+		"isSaved":   r.Method == http.MethodPost,
+	})
+}
+
+func (handler Handler) ListSavedListings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use GET")
+		return
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	before := strings.TrimSpace(r.URL.Query().Get("before"))
+
+	limit := 50
+	if requestLimit := strings.TrimSpace(r.URL.Query().Get("limit")); requestLimit != "" {
+		if requestLimitToInt, err := strconv.Atoi(requestLimit); err == nil {
+			limit = requestLimitToInt
+		}
+	}
+
+	items, err := handler.Listings.ListSavedByUser(r.Context(), userID, before, limit)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not list saved listings")
+		return
+	}
+
+	thumbnails := make([]*listings.Listing, len(items))
+	for i := range items {
+		thumbnails[i] = &items[i].Listing
+	}
+	handler.presignThumbnails(r.Context(), thumbnails)
+
+	var nextCursor string
+	if len(items) > 0 {
+		nextCursor = items[len(items)-1].ID
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"listings":   items,
+		"nextBefore": nextCursor,
+	})
+}
+
+// markSaved sets IsSaved on listings returned to an authenticated caller, anonymous callers are left untouched
+func (handler Handler) markSaved(ctx context.Context, items []*listings.Listing) {
+	userID, ok := middleware.UserIDFromContext(ctx)
+	if !ok || userID == "" || len(items) == 0 {
+		return
+	}
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+
+	saved, err := handler.SavedListings.SavedAmong(ctx, userID, ids)
+	if err != nil {
+		return
+	}
+
+	for _, item := range items {
+		isSaved := saved[item.ID]
+		item.IsSaved = &isSaved
+	}
+}
+
+// notifyListingChanges tells the users who saved a listing about a price drop or the listing being rented
+// price drops only count while the listing is active, savers cannot act on a draft or unpublished listing
+func (handler Handler) notifyListingChanges(ctx context.Context, before listings.Listing, after listings.Listing) {
+	var notificationType, title, body string
+
+	switch {
+	case after.Status == listings.StatusRented && before.Status != listings.StatusRented:
+		notificationType = NotificationSavedRented
+		title = "A saved listing was rented"
+		body = fmt.Sprintf("“%s” is no longer available.", after.Title)
+	case after.Price < before.Price && after.Status == listings.StatusActive:
+		notificationType = NotificationSavedPriceDrop
+		title = "Price drop on a saved listing"
+		body = fmt.Sprintf("“%s” dropped from %.2f to %.2f %s.", after.Title, before.Price, after.Price, after.Currency)
+	default:
+		return
+	}
+
+	savers, err := handler.SavedListings.SaverIDs(ctx, after.ID)
+	if err != nil {
+		log.Printf("saved listing notifications: list savers of %s: %v", after.ID, err)
+		return
+	}
+
+	for _, saverID := range savers {
+		if saverID == after.UserID {
+			continue
+		}
+
+		_, err := handler.Notifications.Insert(ctx, notifications.InsertParams{
+			UserID: saverID,
+			Type:   notificationType,
+			Title:  title,
+			Body:   &body,
+			Data: map[string]any{
+				"listingId": after.ID,
+				"oldPrice":  before.Price,
+				"newPrice":  after.Price,
+				"status":    after.Status,
+			},
+		})
+		if err != nil {
+			log.Printf("saved listing notifications: notify %s: %v", saverID, err)
+		}
+	}
+}
+
+func writeSavedError(w http.ResponseWriter, err error) {
+	if errors.Is(err, saved_listings.ErrListingNotFound) {
+		functions.WriteError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	functions.WriteError(w, http.StatusInternalServerError, "could not update saved listings")
+}
@@ -0,0 +1,75 @@
+package listing
+
+import (
+	"fmt"
+	"go-react-rooms/internal/repositories/amenities"
+	"go-react-rooms/internal/repositories/listings"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const maxSearchQueryLength = 200
+
+// parseSearchParams maps the /listings query string onto repository search params
+func parseSearchParams(query url.Values) (listings.SearchParams, error) {
+	params := listings.SearchParams{
+		Query:    strings.TrimSpace(query.Get("q")),
+		City:     strings.TrimSpace(query.Get("city")),
+		Province: strings.TrimSpace(query.Get("province")),
+		Sort:     strings.TrimSpace(query.Get("sort")),
+		Cursor:   strings.TrimSpace(query.Get("cursor")),
+		// amenities=laundry,dishwasher or repeated amenities= parameters
+		Amenities: amenities.NormalizeKeys(query["amenities"]),
+	}
+
+	if len(params.Query) > maxSearchQueryLength {
+		return params, fmt.Errorf("q must be at most %d characters", maxSearchQueryLength)
+	}
+
+	var err error
+
+	if params.PriceMin, err = parseOptionalFloat(query.Get("priceMin")); err != nil {
+		return params, fmt.Errorf("priceMin must be a number")
+	}
+	if params.PriceMax, err = parseOptionalFloat(query.Get("priceMax")); err != nil {
+		return params, fmt.Errorf("priceMax must be a number")
+	}
+	if params.PriceMin != nil && params.PriceMax != nil && *params.PriceMax < *params.PriceMin {
+		return params, fmt.Errorf("priceMax must be greater than or equal to priceMin")
+	}
+	if params.BedroomsMin, err = parseOptionalInt(query.Get("bedroomsMin")); err != nil {
+		return params, fmt.Errorf("bedroomsMin must be an integer")
+	}
+	if params.BathroomsMin, err = parseOptionalFloat(query.Get("bathroomsMin")); err != nil {
+		return params, fmt.Errorf("bathroomsMin must be a number")
+	}
+	if params.IsFurnished, err = parseOptionalBool(query.Get("isFurnished")); err != nil {
+		return params, fmt.Errorf("isFurnished must be a boolean")
+	}
+	if params.PetsAllowed, err = parseOptionalBool(query.Get("petsAllowed")); err != nil {
+		return params, fmt.Errorf("petsAllowed must be a boolean")
+	}
+	if params.SmokingAllowed, err = parseOptionalBool(query.Get("smokingAllowed")); err != nil {
+		return params, fmt.Errorf("smokingAllowed must be a boolean")
+	}
+	if params.ParkingAvailable, err = parseOptionalBool(query.Get("parkingAvailable")); err != nil {
+		return params, fmt.Errorf("parkingAvailable must be a boolean")
+	}
+	if params.AvailableFrom, err = parseOptionalDate(query.Get("availableFrom")); err != nil {
+		return params, fmt.Errorf("availableFrom must be a date (YYYY-MM-DD) or RFC3339 datetime")
+	}
+	if params.AvailableTo, err = parseOptionalDate(query.Get("availableTo")); err != nil {
+		return params, fmt.Errorf("availableTo must be a date (YYYY-MM-DD) or RFC3339 datetime")
+	}
+
+	if raw := strings.TrimSpace(query.Get("limit")); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil {
+			return params, fmt.Errorf("limit must be an integer")
+		}
+		params.Limit = limit
+	}
+
+	return params, nil
+}
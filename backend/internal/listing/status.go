@@ -0,0 +1,123 @@
+package listing
+
+import (
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/middleware"
+	"go-react-rooms/internal/repositories/listing_flags"
+	"go-react-rooms/internal/repositories/listings"
+	"net/http"
+	"time"
+)
+
+func (handler Handler) PublishListing(w http.ResponseWriter, r *http.Request) {
+	handler.changeStatus(w, r, listings.StatusActive)
+}
+
+func (handler Handler) UnpublishListing(w http.ResponseWriter, r *http.Request) {
+	handler.changeStatus(w, r, listings.StatusInactive)
+}
+
+func (handler Handler) MarkListingRented(w http.ResponseWriter, r *http.Request) {
+	handler.changeStatus(w, r, listings.StatusRented)
+}
+
+func (handler Handler) ArchiveListing(w http.ResponseWriter, r *http.Request) {
+	handler.changeStatus(w, r, listings.StatusArchived)
+}
+
+func (handler Handler) changeStatus(w http.ResponseWriter, r *http.Request, to string) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return
+	}
+
+	current, ok := handler.authorizeOwner(w, r)
+	if !ok {
+		return
+	}
+
+	userID, _ := middleware.UserIDFromContext(r.Context())
+
+	tx, err := handler.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to start transaction")
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	listing, err := handler.Listings.ChangeStatusTx(r.Context(), tx, current.ID, userID, to)
+	if err != nil {
+		writeListingError(w, err)
+		return
+	}
+
+	// checked with the listing row locked, flags are opened under the same lock
+	if to == listings.StatusActive {
+		underReview, err := handler.Flags.IsHeldTx(r.Context(), tx, listing.ID)
+		if err != nil {
+			functions.WriteError(w, http.StatusInternalServerError, "failed to check listing moderation")
+			return
+		}
+		if underReview {
+			writeListingError(w, listing_flags.ErrUnderReview)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to commit transaction")
+		return
+	}
+
+	handler.notifyListingChanges(r.Context(), current, listing)
+	if current.Status != listings.StatusActive {
+		handler.SearchAlerts.ListingActivated(r.Context(), listing)
+	}
+
+	functions.WriteJSON(w, http.StatusOK, listing)
+}
+
+func (handler Handler) ListingStatusHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use GET")
+		return
+	}
+
+	owned, ok := handler.authorizeOwner(w, r)
+	if !ok {
+		return
+	}
+
+	history, err := handler.Listings.StatusHistory(r.Context(), owned.ID)
+	if err != nil {
+		writeListingError(w, err)
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"history": history,
+	})
+}
+
+// RenewListing keeps an active listing from being deactivated as stale
+func (handler Handler) RenewListing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return
+	}
+
+	owned, ok := handler.authorizeOwner(w, r)
+	if !ok {
+		return
+	}
+
+	listing, err := handler.Listings.Renew(r.Context(), owned.ID, time.Now())
+	if err != nil {
+		writeListingError(w, err)
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusOK, listing)
+}
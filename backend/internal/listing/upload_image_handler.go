@@ -2,6 +2,7 @@ package listing
 
 import (
 	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/imaging"
 	"go-react-rooms/internal/middleware"
 	"go-react-rooms/internal/repositories/listing_images"
 	"go-react-rooms/internal/repositories/listings"
@@ -14,14 +15,14 @@ import (
 type ImageUploadHandler struct {
 	Listings      listings.Repo
 	ListingImages listing_images.Repo
-	S3            *storage.S3Storage
+	Storage       storage.ObjectStore
 }
 
-func NewImageUploadhandler(listingsRepo listings.Repo, listingImagesRepo listing_images.Repo, s3Storage *storage.S3Storage) *ImageUploadHandler {
+func NewImageUploadhandler(listingsRepo listings.Repo, listingImagesRepo listing_images.Repo, store storage.ObjectStore) *ImageUploadHandler {
 	return &ImageUploadHandler{
 		Listings:      listingsRepo,
 		ListingImages: listingImagesRepo,
-		S3:            s3Storage,
+		Storage:       store,
 	}
 }
 
@@ -66,10 +67,10 @@ func (handler *ImageUploadHandler) UploadListingImage(w http.ResponseWriter, r *
 		return
 	}
 
-	altTextValue := r.FormValue("altText")
-	var altText *string
-	if altTextValue != "" {
-		altText = &altTextValue
+	altTexts, err := parseAltTexts(r.MultipartForm.Value["altTexts"], r.FormValue("altText"), len(files))
+	if err != nil {
+		functions.WriteError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	thumbnailIndex := 0
@@ -88,12 +89,10 @@ func (handler *ImageUploadHandler) UploadListingImage(w http.ResponseWriter, r *
 	}
 
 	createdImages := make([]listing_images.ListingImage, 0, len(files))
-	uploadedKeys := make([]string, 0, len(files))
+	uploadedKeys := make([]string, 0, len(files)*len(imaging.Specs))
 
 	cleanupUploaded := func() {
-		for _, key := range uploadedKeys {
-			_ = handler.S3.DeleteObject(r.Context(), key)
-		}
+		deleteObjects(r.Context(), handler.Storage, uploadedKeys)
 	}
 
 	for i, fileHeader := range files {
@@ -136,22 +135,23 @@ func (handler *ImageUploadHandler) UploadListingImage(w http.ResponseWriter, r *
 			return
 		}
 
-		s3Key, err := handler.S3.UploadListingImage(r.Context(), listingID, contentType, data)
+		processed, err := imaging.Process(data)
+		if err != nil {
+			cleanupUploaded()
+			writeImagingError(w, err)
+			return
+		}
+
+		stored, err := storeListingImage(r.Context(), handler.Storage, listingID, processed)
 		if err != nil {
 			cleanupUploaded()
 			functions.WriteError(w, http.StatusInternalServerError, "failed to upload file to S3")
 			return
 		}
 
-		uploadedKeys = append(uploadedKeys, s3Key)
+		uploadedKeys = append(uploadedKeys, stored.keys()...)
 
-		image, err := handler.ListingImages.InsertListingImage(r.Context(), listing_images.InsertListingImageParams{
-			ListingID:   listingID,
-			S3Key:       s3Key,
-			AltText:     altText,
-			SortOrder:   i,
-			IsThumbnail: i == thumbnailIndex,
-		})
+		image, err := handler.ListingImages.InsertListingImage(r.Context(), stored.insertParams(listingID, altTexts[i], i, i == thumbnailIndex))
 		if err != nil {
 			cleanupUploaded()
 			functions.WriteError(w, http.StatusInternalServerError, "failed to save image metadata")
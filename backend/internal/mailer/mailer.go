@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// Mailer delivers transactional email, implementations are picked by config
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// LogMailer writes messages to the application log instead of sending them, it is the development default
+type LogMailer struct {
+	From string
+}
+
+func (mailer LogMailer) Send(ctx context.Context, message Message) error {
+	log.Printf("mail from=%s to=%s subject=%q\n%s", mailer.From, message.To, message.Subject, message.Text)
+	return nil
+}
+
+// New returns the mailer for a driver name
+func New(driver string, from string) (Mailer, error) {
+	switch driver {
+	case "", "log":
+		return LogMailer{From: from}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", driver)
+	}
+}
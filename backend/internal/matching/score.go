@@ -0,0 +1,343 @@
+// Package matching scores how well listings and other roommates fit a seeker's roommate preferences.
+// Scoring is pure: callers load the data and this package only compares it.
+package matching
+
+import (
+	"fmt"
+	"go-react-rooms/internal/repositories/listings"
+	"go-react-rooms/internal/repositories/roommate_preferences"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	ComponentBudget    = "budget"
+	ComponentLocation  = "location"
+	ComponentBedrooms  = "bedrooms"
+	ComponentBathrooms = "bathrooms"
+	ComponentFurnished = "furnished"
+	ComponentPets      = "pets"
+	ComponentSmoking   = "smoking"
+	ComponentParking   = "parking"
+	ComponentAmenities = "amenities"
+	ComponentMoveIn    = "moveIn"
+	ComponentAge       = "age"
+)
+
+// how far above budget_max a listing still gets partial budget credit, as a fraction of budget_max
+const OverBudgetTolerance = 0.25
+
+// how many days past the wanted move-in date availability still gets partial credit
+const moveInToleranceDays = 30
+
+var weights = map[string]float64{
+	ComponentBudget:    3,
+	ComponentLocation:  2.5,
+	ComponentMoveIn:    1.5,
+	ComponentBedrooms:  1.5,
+	ComponentBathrooms: 0.5,
+	ComponentAmenities: 1,
+	ComponentPets:      1,
+	ComponentSmoking:   1,
+	ComponentFurnished: 0.75,
+	ComponentParking:   0.75,
+	ComponentAge:       1,
+}
+
+// Component is one criterion of a match, Score is between 0 and 1
+type Component struct {
+	Key    string  `json:"key"`
+	Score  float64 `json:"score"`
+	Weight float64 `json:"weight"`
+	Reason string  `json:"reason"`
+}
+
+// Match is the weighted average of its components as a 0-100 score
+// Criteria the seeker left empty are not scored at all rather than counted as a match
+type Match struct {
+	Score      int         `json:"score"`
+	Components []Component `json:"components"`
+}
+
+// Seeker is the side the matches are computed for
+type Seeker struct {
+	Preferences roommate_preferences.Preferences
+	Amenities   []string
+}
+
+type scorer struct {
+	components []Component
+}
+
+func (s *scorer) add(key string, score float64, reason string) {
+	s.components = append(s.components, Component{
+		Key:    key,
+		Score:  math.Round(clamp(score)*100) / 100,
+		Weight: weights[key],
+		Reason: reason,
+	})
+}
+
+func (s *scorer) match() Match {
+	var total, weightSum float64
+	for _, component := range s.components {
+		total += component.Score * component.Weight
+		weightSum += component.Weight
+	}
+
+	out := Match{Components: s.components}
+	if out.Components == nil {
+		out.Components = []Component{}
+	}
+	if weightSum > 0 {
+		out.Score = int(math.Round(total / weightSum * 100))
+	}
+	return out
+}
+
+// ScoreListing rates a listing for a seeker, listingAmenities are amenity keys
+func ScoreListing(seeker Seeker, listing listings.Listing, listingAmenities []string) Match {
+	prefs := seeker.Preferences
+	var s scorer
+
+	if prefs.BudgetMin != nil || prefs.BudgetMax != nil {
+		score, reason := budgetScore(listing.Price, prefs.BudgetMin, prefs.BudgetMax)
+		s.add(ComponentBudget, score, reason)
+	}
+
+	if prefs.PreferredCity != nil || prefs.PreferredProvince != nil {
+		score, reason := locationScore(prefs.PreferredCity, prefs.PreferredProvince, &listing.City, &listing.Province)
+		s.add(ComponentLocation, score, reason)
+	}
+
+	if prefs.PreferredBedrooms != nil {
+		wanted := *prefs.PreferredBedrooms
+		switch {
+		case listing.Bedrooms >= wanted:
+			s.add(ComponentBedrooms, 1, fmt.Sprintf("%d bedrooms, you want %d", listing.Bedrooms, wanted))
+		default:
+			s.add(ComponentBedrooms, float64(listing.Bedrooms)/float64(wanted), fmt.Sprintf("only %d of the %d bedrooms you want", listing.Bedrooms, wanted))
+		}
+	}
+
+	if prefs.PreferredBathrooms != nil {
+		wanted := *prefs.PreferredBathrooms
+		switch {
+		case listing.Bathrooms >= wanted:
+			s.add(ComponentBathrooms, 1, fmt.Sprintf("%g bathrooms, you want %g", listing.Bathrooms, wanted))
+		default:
+			s.add(ComponentBathrooms, listing.Bathrooms/wanted, fmt.Sprintf("only %g of the %g bathrooms you want", listing.Bathrooms, wanted))
+		}
+	}
+
+	if prefs.FurnishedPreferred != nil {
+		s.add(ComponentFurnished, boolScore(listing.IsFurnished == *prefs.FurnishedPreferred),
+			choose(listing.IsFurnished, "furnished", "unfurnished")+choose(listing.IsFurnished == *prefs.FurnishedPreferred, ", as you prefer", ", not what you prefer"))
+	}
+
+	if prefs.PetsOK != nil {
+		s.add(ComponentPets, boolScore(listing.PetsAllowed == *prefs.PetsOK),
+			choose(listing.PetsAllowed, "pets allowed", "no pets")+choose(listing.PetsAllowed == *prefs.PetsOK, ", matches your preference", ", does not match your preference"))
+	}
+
+	if prefs.SmokingOK != nil {
+		s.add(ComponentSmoking, boolScore(listing.SmokingAllowed == *prefs.SmokingOK),
+			choose(listing.SmokingAllowed, "smoking allowed", "no smoking")+choose(listing.SmokingAllowed == *prefs.SmokingOK, ", matches your preference", ", does not match your preference"))
+	}
+
+	// not needing parking is satisfied by any listing, so only a need is scored
+	if prefs.ParkingNeeded != nil && *prefs.ParkingNeeded {
+		s.add(ComponentParking, boolScore(listing.ParkingAvailable), choose(listing.ParkingAvailable, "parking available", "no parking"))
+	}
+
+	if len(seeker.Amenities) > 0 {
+		have := overlap(seeker.Amenities, listingAmenities)
+		s.add(ComponentAmenities, float64(len(have))/float64(len(seeker.Amenities)),
+			fmt.Sprintf("has %d of the %d amenities you want", len(have), len(seeker.Amenities)))
+	}
+
+	if prefs.MoveInDate != nil {
+		score, reason := moveInScore(*prefs.MoveInDate, listing.AvailableFrom, listing.AvailableUntil)
+		s.add(ComponentMoveIn, score, reason)
+	}
+
+	return s.match()
+}
+
+// ScoreRoommate rates how compatible two seekers are, the result is symmetric
+func ScoreRoommate(seeker Seeker, other Seeker) Match {
+	a := seeker.Preferences
+	b := other.Preferences
+	var s scorer
+
+	if (a.BudgetMin != nil || a.BudgetMax != nil) && (b.BudgetMin != nil || b.BudgetMax != nil) {
+		score, reason := rangeOverlapScore(a.BudgetMin, a.BudgetMax, b.BudgetMin, b.BudgetMax, "budgets")
+		s.add(ComponentBudget, score, reason)
+	}
+
+	if (a.PreferredCity != nil || a.PreferredProvince != nil) && (b.PreferredCity != nil || b.PreferredProvince != nil) {
+		score, reason := locationScore(a.PreferredCity, a.PreferredProvince, b.PreferredCity, b.PreferredProvince)
+		s.add(ComponentLocation, score, reason)
+	}
+
+	if a.MoveInDate != nil && b.MoveInDate != nil {
+		days := math.Abs(a.MoveInDate.Sub(*b.MoveInDate).Hours() / 24)
+		s.add(ComponentMoveIn, 1-days/moveInToleranceDays, fmt.Sprintf("move-in dates %d days apart", int(math.Round(days))))
+	}
+
+	if a.PreferredBedrooms != nil && b.PreferredBedrooms != nil {
+		same := *a.PreferredBedrooms == *b.PreferredBedrooms
+		s.add(ComponentBedrooms, boolScore(same), choose(same, "want the same number of bedrooms", "want a different number of bedrooms"))
+	}
+
+	if a.FurnishedPreferred != nil && b.FurnishedPreferred != nil {
+		same := *a.FurnishedPreferred == *b.FurnishedPreferred
+		s.add(ComponentFurnished, boolScore(same), choose(same, "same furnishing preference", "different furnishing preference"))
+	}
+
+	if a.PetsOK != nil && b.PetsOK != nil {
+		same := *a.PetsOK == *b.PetsOK
+		s.add(ComponentPets, boolScore(same), choose(same, "agree on pets", "disagree on pets"))
+	}
+
+	if a.SmokingOK != nil && b.SmokingOK != nil {
+		same := *a.SmokingOK == *b.SmokingOK
+		s.add(ComponentSmoking, boolScore(same), choose(same, "agree on smoking", "disagree on smoking"))
+	}
+
+	if (a.MinAge != nil || a.MaxAge != nil) && (b.MinAge != nil || b.MaxAge != nil) {
+		score, reason := rangeOverlapScore(intToFloat(a.MinAge), intToFloat(a.MaxAge), intToFloat(b.MinAge), intToFloat(b.MaxAge), "age ranges")
+		s.add(ComponentAge, score, reason)
+	}
+
+	if len(seeker.Amenities) > 0 && len(other.Amenities) > 0 {
+		shared := overlap(seeker.Amenities, other.Amenities)
+		union := len(seeker.Amenities) + len(other.Amenities) - len(shared)
+		s.add(ComponentAmenities, float64(len(shared))/float64(union), fmt.Sprintf("%d amenities in common", len(shared)))
+	}
+
+	return s.match()
+}
+
+// Ranked pairs an item index with its match so callers can sort any slice
+type Ranked struct {
+	Index int
+	Match Match
+}
+
+// Rank sorts matches best first, ties keep their input order
+func Rank(matches []Match) []Ranked {
+	out := make([]Ranked, len(matches))
+	for i, match := range matches {
+		out[i] = Ranked{Index: i, Match: match}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Match.Score > out[j].Match.Score
+	})
+	return out
+}
+
+func budgetScore(price float64, budgetMin *float64, budgetMax *float64) (float64, string) {
+	if budgetMax != nil && price > *budgetMax {
+		if *budgetMax <= 0 {
+			return 0, "over your budget"
+		}
+		over := (price - *budgetMax) / *budgetMax
+		return 1 - over/OverBudgetTolerance, fmt.Sprintf("%.0f%% over your budget", over*100)
+	}
+	if budgetMin != nil && price < *budgetMin {
+		// cheaper than expected is still affordable, only slightly less likely to be what they want
+		return 0.8, "below your budget range"
+	}
+	return 1, "within your budget"
+}
+
+func locationScore(cityA *string, provinceA *string, cityB *string, provinceB *string) (float64, string) {
+	if cityA != nil && cityB != nil && strings.EqualFold(*cityA, *cityB) {
+		return 1, "same city: " + *cityB
+	}
+	if provinceA != nil && provinceB != nil && strings.EqualFold(*provinceA, *provinceB) {
+		if cityA == nil || cityB == nil {
+			return 1, "same province: " + *provinceB
+		}
+		return 0.5, "same province, different city"
+	}
+	return 0, "different area"
+}
+
+func moveInScore(moveIn time.Time, availableFrom time.Time, availableUntil *time.Time) (float64, string) {
+	if availableUntil != nil && availableUntil.Before(moveIn) {
+		return 0, "no longer available on your move-in date"
+	}
+	if !availableFrom.After(moveIn) {
+		return 1, "available by your move-in date"
+	}
+	days := availableFrom.Sub(moveIn).Hours() / 24
+	return 1 - days/moveInToleranceDays, fmt.Sprintf("available %d days after your move-in date", int(math.Ceil(days)))
+}
+
+// rangeOverlapScore is the overlap of two ranges relative to the narrower one, open ends are unbounded
+func rangeOverlapScore(minA *float64, maxA *float64, minB *float64, maxB *float64, what string) (float64, string) {
+	low := math.Max(valueOr(minA, math.Inf(-1)), valueOr(minB, math.Inf(-1)))
+	high := math.Min(valueOr(maxA, math.Inf(1)), valueOr(maxB, math.Inf(1)))
+	if high < low {
+		return 0, what + " do not overlap"
+	}
+
+	widthA := valueOr(maxA, math.Inf(1)) - valueOr(minA, math.Inf(-1))
+	widthB := valueOr(maxB, math.Inf(1)) - valueOr(minB, math.Inf(-1))
+	narrower := math.Min(widthA, widthB)
+	if math.IsInf(narrower, 1) || narrower == 0 || math.IsInf(high-low, 1) {
+		return 1, what + " overlap"
+	}
+	return (high - low) / narrower, what + " partly overlap"
+}
+
+func overlap(wanted []string, have []string) []string {
+	set := make(map[string]struct{}, len(have))
+	for _, key := range have {
+		set[key] = struct{}{}
+	}
+	var out []string
+	for _, key := range wanted {
+		if _, ok := set[key]; ok {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+func boolScore(ok bool) float64 {
+	if ok {
+		return 1
+	}
+	return 0
+}
+
+func choose(condition bool, yes string, no string) string {
+	if condition {
+		return yes
+	}
+	return no
+}
+
+func clamp(value float64) float64 {
+	return math.Max(0, math.Min(1, value))
+}
+
+func valueOr(value *float64, fallback float64) float64 {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
+
+func intToFloat(value *int) *float64 {
+	if value == nil {
+		return nil
+	}
+	f := float64(*value)
+	return &f
+}
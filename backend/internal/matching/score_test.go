@@ -0,0 +1,116 @@
+package matching
+
+import (
+	"go-react-rooms/internal/repositories/roommate_preferences"
+	"testing"
+	"time"
+)
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func TestScoreRoommate(t *testing.T) {
+	moveIn := time.Date(2026, time.September, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		seeker     Seeker
+		other      Seeker
+		score      int
+		components []string
+	}{
+		{
+			name:       "nothing in common to compare",
+			seeker:     Seeker{Preferences: roommate_preferences.Preferences{BudgetMax: ptr(900.0)}},
+			other:      Seeker{Preferences: roommate_preferences.Preferences{PetsOK: ptr(true)}},
+			score:      0,
+			components: []string{},
+		},
+		{
+			name: "same budget and city",
+			seeker: Seeker{Preferences: roommate_preferences.Preferences{
+				BudgetMin: ptr(500.0), BudgetMax: ptr(1000.0), PreferredCity: ptr("Toronto"),
+			}},
+			other: Seeker{Preferences: roommate_preferences.Preferences{
+				BudgetMin: ptr(500.0), BudgetMax: ptr(1000.0), PreferredCity: ptr("toronto"),
+			}},
+			score:      100,
+			components: []string{ComponentBudget, ComponentLocation},
+		},
+		{
+			name: "partly overlapping budgets and a pets disagreement",
+			seeker: Seeker{Preferences: roommate_preferences.Preferences{
+				BudgetMin: ptr(500.0), BudgetMax: ptr(1000.0), PetsOK: ptr(true),
+			}},
+			other: Seeker{Preferences: roommate_preferences.Preferences{
+				BudgetMin: ptr(800.0), BudgetMax: ptr(1500.0), PetsOK: ptr(false),
+			}},
+			// budget 200/500 weighted 3, pets 0 weighted 1
+			score:      30,
+			components: []string{ComponentBudget, ComponentPets},
+		},
+		{
+			name: "move-in dates half the tolerance apart and the same bedrooms",
+			seeker: Seeker{Preferences: roommate_preferences.Preferences{
+				MoveInDate: ptr(moveIn), PreferredBedrooms: ptr(2),
+			}},
+			other: Seeker{Preferences: roommate_preferences.Preferences{
+				MoveInDate: ptr(moveIn.AddDate(0, 0, 15)), PreferredBedrooms: ptr(2),
+			}},
+			score:      75,
+			components: []string{ComponentMoveIn, ComponentBedrooms},
+		},
+		{
+			name:       "open ended age range",
+			seeker:     Seeker{Preferences: roommate_preferences.Preferences{MinAge: ptr(25)}},
+			other:      Seeker{Preferences: roommate_preferences.Preferences{MinAge: ptr(20), MaxAge: ptr(30)}},
+			score:      50,
+			components: []string{ComponentAge},
+		},
+		{
+			name:       "disjoint age ranges",
+			seeker:     Seeker{Preferences: roommate_preferences.Preferences{MinAge: ptr(18), MaxAge: ptr(22)}},
+			other:      Seeker{Preferences: roommate_preferences.Preferences{MinAge: ptr(30), MaxAge: ptr(40)}},
+			score:      0,
+			components: []string{ComponentAge},
+		},
+		{
+			name:       "shared amenities over all wanted amenities",
+			seeker:     Seeker{Amenities: []string{"wifi", "laundry"}},
+			other:      Seeker{Amenities: []string{"wifi", "gym"}},
+			score:      33,
+			components: []string{ComponentAmenities},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, pair := range [][2]Seeker{{test.seeker, test.other}, {test.other, test.seeker}} {
+				match := ScoreRoommate(pair[0], pair[1])
+				if match.Score != test.score {
+					t.Errorf("score = %d, want %d", match.Score, test.score)
+				}
+				if len(match.Components) != len(test.components) {
+					t.Fatalf("components = %+v, want keys %v", match.Components, test.components)
+				}
+				for i, component := range match.Components {
+					if component.Key != test.components[i] {
+						t.Errorf("component %d = %s, want %s", i, component.Key, test.components[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRankKeepsTiesInOrder(t *testing.T) {
+	ranked := Rank([]Match{{Score: 40}, {Score: 90}, {Score: 40}, {Score: 70}})
+
+	want := []int{1, 3, 0, 2}
+	for i, rank := range ranked {
+		if rank.Index != want[i] {
+			t.Errorf("rank %d = index %d, want %d", i, rank.Index, want[i])
+		}
+	}
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"go-react-rooms/internal/auth"
 	"go-react-rooms/internal/functions"
 	"net/http"
@@ -25,6 +26,10 @@ func RequireAuth(sessionStore *auth.SessionStore, next http.Handler) http.Handle
 		}
 
 		userID, err := sessionStore.Get(r.Context(), c.Value)
+		if errors.Is(err, auth.ErrUserSuspended) {
+			functions.WriteError(w, http.StatusForbidden, err.Error())
+			return
+		}
 		if err != nil {
 			functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
 			return
@@ -34,3 +39,47 @@ func RequireAuth(sessionStore *auth.SessionStore, next http.Handler) http.Handle
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// OptionalAuth resolves the session like RequireAuth but lets anonymous requests through
+// handlers check UserIDFromContext themselves when a user is required
+func OptionalAuth(sessionStore *auth.SessionStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(auth.CookieName)
+		if err != nil || c.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID, err := sessionStore.Get(r.Context(), c.Value)
+		if err != nil || userID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userIDKey, userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireAdmin lets only admins through, it goes inside RequireAuth
+func RequireAdmin(isAdmin func(ctx context.Context, userID string) (bool, error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := UserIDFromContext(r.Context())
+		if !ok || userID == "" {
+			functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		admin, err := isAdmin(r.Context(), userID)
+		if err != nil {
+			functions.WriteError(w, http.StatusInternalServerError, "failed to verify admin")
+			return
+		}
+		if !admin {
+			functions.WriteError(w, http.StatusForbidden, "admin only")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
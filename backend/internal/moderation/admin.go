@@ -0,0 +1,345 @@
+package moderation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/repositories/listing_flags"
+	"go-react-rooms/internal/repositories/listings"
+	"go-react-rooms/internal/repositories/messages"
+	"go-react-rooms/internal/repositories/reports"
+	"go-react-rooms/internal/ws"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errActionNotApplicable = errors.New("action does not apply to this report")
+var errTargetGone = errors.New("reported item no longer exists")
+var errSuspendAdmin = errors.New("admins cannot be suspended")
+
+type reportActionReq struct {
+	Action string `json:"action"`
+}
+
+type resolveFlagReq struct {
+	Status string `json:"status"`
+}
+
+// ListReports is the moderation queue, open reports oldest first unless ?status= asks for resolved ones
+func (handler Handlers) ListReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use GET")
+		return
+	}
+
+	if _, ok := handler.requireAdmin(w, r); !ok {
+		return
+	}
+
+	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	if status == "" {
+		status = reports.StatusOpen
+	}
+	if !reports.IsValidStatus(status) {
+		functions.WriteError(w, http.StatusBadRequest, "invalid status")
+		return
+	}
+
+	items, err := handler.Reports.List(r.Context(), status, parseLimit(r))
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not list reports")
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"reports": items,
+	})
+}
+
+// ActOnReport applies a moderator decision to the report and every other open report on the same target
+func (handler Handlers) ActOnReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return
+	}
+
+	adminID, ok := handler.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req reportActionReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	action := strings.TrimSpace(req.Action)
+	switch action {
+	case reports.ActionDismiss, reports.ActionHideListing, reports.ActionDeleteMessage, reports.ActionSuspendUser:
+	default:
+		functions.WriteFieldErrors(w, map[string]string{
+			"action": "must be dismiss, hide_listing, delete_message or suspend_user",
+		})
+		return
+	}
+
+	tx, err := handler.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to start transaction")
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	report, err := handler.Reports.LockOpenTx(r.Context(), tx, strings.TrimSpace(r.PathValue("id")))
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	// applied once the transaction is committed
+	var afterCommit func()
+
+	switch action {
+	case reports.ActionHideListing:
+		err = handler.hideListingTx(r.Context(), tx, report, adminID)
+	case reports.ActionDeleteMessage:
+		afterCommit, err = handler.deleteMessageTx(r.Context(), tx, report, adminID)
+	case reports.ActionSuspendUser:
+		afterCommit, err = handler.suspendUserTx(r.Context(), tx, report)
+	}
+	if err != nil {
+		writeActionError(w, err)
+		return
+	}
+
+	if _, err := handler.Reports.ResolveTx(r.Context(), tx, report, action, adminID); err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to resolve report")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to commit transaction")
+		return
+	}
+
+	if afterCommit != nil {
+		afterCommit()
+	}
+
+	report, err = handler.Reports.Get(r.Context(), report.ID)
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusOK, report)
+}
+
+// hideListingTx takes a reported listing offline and holds it with a flag so the owner can not
+// republish it until a moderator dismisses the flag
+func (handler Handlers) hideListingTx(ctx context.Context, tx *sql.Tx, report reports.Report, adminID string) error {
+	if report.TargetType != reports.TargetListing {
+		return errActionNotApplicable
+	}
+	if report.SubjectUserID == nil {
+		return errTargetGone
+	}
+
+	_, err := handler.Listings.ChangeStatusTx(ctx, tx, report.TargetID, adminID, listings.StatusInactive)
+	// drafts, inactive and archived listings are not public, the flag alone keeps them that way
+	if err != nil && !errors.Is(err, listings.ErrInvalidTransition) {
+		return err
+	}
+
+	details := []map[string]string{{
+		"reportId": report.ID,
+		"reason":   report.Reason,
+	}}
+	_, err = handler.Flags.OpenTx(ctx, tx, report.TargetID, listing_flags.ReasonReported, details)
+	return err
+}
+
+// deleteMessageTx turns a reported message into a tombstone, connected room members are told once it
+// is committed. The body stays in the database so the report keeps its preview
+func (handler Handlers) deleteMessageTx(ctx context.Context, tx *sql.Tx, report reports.Report, adminID string) (func(), error) {
+	if report.TargetType != reports.TargetMessage {
+		return nil, errActionNotApplicable
+	}
+
+	message, err := handler.Messages.SoftDeleteTx(ctx, tx, report.TargetID, adminID)
+	if errors.Is(err, messages.ErrMessageNotFound) || errors.Is(err, messages.ErrMessageDeleted) {
+		return nil, errTargetGone
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		handler.Hub.Broadcast(message.RoomID, ws.MessageDeleted(message))
+	}, nil
+}
+
+// suspendUserTx suspends the user responsible for the reported item. The session marker and the
+// disconnect only happen once the suspension is committed, a marker that fails to be written is
+// restored by the suspension-sync job
+func (handler Handlers) suspendUserTx(ctx context.Context, tx *sql.Tx, report reports.Report) (func(), error) {
+	if report.SubjectUserID == nil {
+		return nil, errTargetGone
+	}
+	userID := *report.SubjectUserID
+
+	isAdmin, err := handler.Users.IsAdmin(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if isAdmin {
+		return nil, errSuspendAdmin
+	}
+
+	if err := handler.Users.SetSuspendedTx(ctx, tx, userID, true); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errTargetGone
+		}
+		return nil, err
+	}
+
+	return func() {
+		if err := handler.Sessions.Suspend(context.WithoutCancel(ctx), userID); err != nil {
+			log.Printf("moderation: set suspension marker of %s: %v", userID, err)
+		}
+		handler.Hub.DisconnectUser(userID)
+	}, nil
+}
+
+// UnsuspendUser lifts a suspension, the user can sign in again and their remaining sessions work again
+func (handler Handlers) UnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return
+	}
+
+	if _, ok := handler.requireAdmin(w, r); !ok {
+		return
+	}
+
+	userID := strings.TrimSpace(r.PathValue("id"))
+	if err := handler.Users.SetSuspended(r.Context(), userID, false); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			functions.WriteError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		functions.WriteError(w, http.StatusInternalServerError, "failed to lift suspension")
+		return
+	}
+	if err := handler.Sessions.Unsuspend(r.Context(), userID); err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to lift suspension")
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"id":        userID,
+		"suspended": false,
+	})
+}
+
+// ListListingFlags lists listings held by moderation: photo matches and hidden reported listings
+func (handler Handlers) ListListingFlags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use GET")
+		return
+	}
+
+	if _, ok := handler.requireAdmin(w, r); !ok {
+		return
+	}
+
+	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	if status == "" {
+		status = listing_flags.StatusOpen
+	}
+	switch status {
+	case listing_flags.StatusOpen, listing_flags.StatusDismissed, listing_flags.StatusConfirmed:
+	default:
+		functions.WriteError(w, http.StatusBadRequest, "invalid status")
+		return
+	}
+
+	items, err := handler.Flags.List(r.Context(), status, parseLimit(r))
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not list listing flags")
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"flags": items,
+	})
+}
+
+// ResolveListingFlag dismisses a flag, which lets the owner publish the listing again, or confirms it,
+// which keeps the listing offline
+func (handler Handlers) ResolveListingFlag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return
+	}
+
+	adminID, ok := handler.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req resolveFlagReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	status := strings.TrimSpace(req.Status)
+	if status != listing_flags.StatusDismissed && status != listing_flags.StatusConfirmed {
+		functions.WriteFieldErrors(w, map[string]string{
+			"status": "must be dismissed or confirmed",
+		})
+		return
+	}
+
+	flag, err := handler.Flags.Resolve(r.Context(), strings.TrimSpace(r.PathValue("id")), status, adminID)
+	if err != nil {
+		switch {
+		case errors.Is(err, listing_flags.ErrFlagNotFound):
+			functions.WriteError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, listing_flags.ErrInvalidTransition):
+			functions.WriteError(w, http.StatusConflict, err.Error())
+		default:
+			functions.WriteError(w, http.StatusInternalServerError, "failed to resolve listing flag")
+		}
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusOK, flag)
+}
+
+func parseLimit(r *http.Request) int {
+	limit := 50
+	if requestLimit := strings.TrimSpace(r.URL.Query().Get("limit")); requestLimit != "" {
+		if requestLimitToInt, err := strconv.Atoi(requestLimit); err == nil {
+			limit = requestLimitToInt
+		}
+	}
+	return limit
+}
+
+func writeActionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errActionNotApplicable):
+		functions.WriteError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, errTargetGone), errors.Is(err, errSuspendAdmin):
+		functions.WriteError(w, http.StatusConflict, err.Error())
+	default:
+		functions.WriteError(w, http.StatusInternalServerError, "moderation action failed")
+	}
+}
@@ -0,0 +1,193 @@
+package moderation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-react-rooms/internal/auth"
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/middleware"
+	"go-react-rooms/internal/repositories/listing_flags"
+	"go-react-rooms/internal/repositories/listings"
+	"go-react-rooms/internal/repositories/messages"
+	"go-react-rooms/internal/repositories/reports"
+	"go-react-rooms/internal/repositories/rooms"
+	"go-react-rooms/internal/repositories/users"
+	"go-react-rooms/internal/ws"
+	"net/http"
+	"strings"
+)
+
+const maxReportDetailsLength = 2000
+
+var errTargetNotFound = errors.New("reported item not found")
+var errOwnTarget = errors.New("you cannot report yourself or your own content")
+
+type Handlers struct {
+	Reports  reports.Repo
+	Flags    listing_flags.Repo
+	Listings listings.Repo
+	Messages messages.Repo
+	Rooms    rooms.Repo
+	Users    users.Repo
+	// suspensions are enforced through the session store, live chat connections are dropped through the hub
+	Sessions *auth.SessionStore
+	Hub      *ws.Hub
+	DB       *sql.DB
+}
+
+type createReportReq struct {
+	TargetType string `json:"targetType"`
+	TargetID   string `json:"targetId"`
+	Reason     string `json:"reason"`
+	Details    string `json:"details"`
+}
+
+// CreateReport lets a user report a listing, a chat message or another user
+func (handler Handlers) CreateReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req createReportReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	params := reports.InsertParams{
+		ReporterID: userID,
+		TargetType: strings.TrimSpace(req.TargetType),
+		TargetID:   strings.TrimSpace(req.TargetID),
+		Reason:     strings.TrimSpace(req.Reason),
+	}
+	if details := strings.TrimSpace(req.Details); details != "" {
+		params.Details = &details
+	}
+
+	fields := make(map[string]string)
+	if !reports.IsValidTargetType(params.TargetType) {
+		fields["targetType"] = "must be listing, message or user"
+	}
+	if params.TargetID == "" {
+		fields["targetId"] = "is required"
+	}
+	if !reports.IsValidReason(params.Reason) {
+		fields["reason"] = "must be spam, scam, inappropriate, harassment or other"
+	}
+	if params.Details != nil && len(*params.Details) > maxReportDetailsLength {
+		fields["details"] = fmt.Sprintf("must be at most %d characters", maxReportDetailsLength)
+	}
+	if len(fields) > 0 {
+		functions.WriteFieldErrors(w, fields)
+		return
+	}
+
+	if err := handler.checkTarget(r.Context(), userID, params.TargetType, params.TargetID); err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	report, err := handler.Reports.Create(r.Context(), params)
+	if err != nil {
+		writeReportError(w, err)
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusCreated, report)
+}
+
+// checkTarget makes sure the reporter can see what they report: a live listing, a message in one of
+// their rooms or an existing user, and that it is not their own
+func (handler Handlers) checkTarget(ctx context.Context, userID string, targetType string, targetID string) error {
+	switch targetType {
+	case reports.TargetListing:
+		listing, err := handler.Listings.GetByID(ctx, targetID)
+		if errors.Is(err, listings.ErrListingNotFound) {
+			return errTargetNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if listing.UserID == userID {
+			return errOwnTarget
+		}
+		if listing.Status != listings.StatusActive {
+			return errTargetNotFound
+		}
+
+	case reports.TargetMessage:
+		message, err := handler.Messages.Get(ctx, targetID)
+		if errors.Is(err, messages.ErrMessageNotFound) {
+			return errTargetNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if message.DeletedAt != nil {
+			return errTargetNotFound
+		}
+		if message.SenderID == userID {
+			return errOwnTarget
+		}
+		isMember, err := handler.Rooms.IsMember(ctx, message.RoomID, userID)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return errTargetNotFound
+		}
+
+	case reports.TargetUser:
+		if targetID == userID {
+			return errOwnTarget
+		}
+		if _, err := handler.Users.GetUserById(ctx, targetID); err != nil {
+			return errTargetNotFound
+		}
+	}
+	return nil
+}
+
+// requireAdmin returns the caller's id when they are an admin and writes the error response otherwise
+func (handler Handlers) requireAdmin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return "", false
+	}
+
+	isAdmin, err := handler.Users.IsAdmin(r.Context(), userID)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to verify admin")
+		return "", false
+	}
+	if !isAdmin {
+		functions.WriteError(w, http.StatusForbidden, "admin only")
+		return "", false
+	}
+
+	return userID, true
+}
+
+func writeReportError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, reports.ErrReportNotFound), errors.Is(err, errTargetNotFound):
+		functions.WriteError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errOwnTarget):
+		functions.WriteError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, reports.ErrAlreadyReported), errors.Is(err, reports.ErrAlreadyResolved):
+		functions.WriteError(w, http.StatusConflict, err.Error())
+	default:
+		functions.WriteError(w, http.StatusInternalServerError, "report request failed")
+	}
+}
@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/middleware"
+	"go-react-rooms/internal/repositories/notifications"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type Handlers struct {
+	Notifications notifications.Repo
+}
+
+type markReadReq struct {
+	// empty marks every notification as read
+	ID string `json:"id"`
+}
+
+func (handler Handlers) ListNotifications(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use GET")
+		return
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+	before := strings.TrimSpace(r.URL.Query().Get("before"))
+
+	limit := 50
+	if requestLimit := strings.TrimSpace(r.URL.Query().Get("limit")); requestLimit != "" {
+		if requestLimitToInt, err := strconv.Atoi(requestLimit); err == nil {
+			limit = requestLimitToInt
+		}
+	}
+
+	items, err := handler.Notifications.ListForUser(r.Context(), userID, unreadOnly, before, limit)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not list notifications")
+		return
+	}
+
+	unread, err := handler.Notifications.CountUnread(r.Context(), userID)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not count notifications")
+		return
+	}
+
+	var nextCursor string
+	if len(items) > 0 {
+		nextCursor = items[len(items)-1].ID
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"notifications": items,
+		"unreadCount":   unread,
+		"nextBefore":    nextCursor,
+	})
+}
+
+func (handler Handlers) MarkRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use POST")
+		return
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req markReadReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if err := handler.Notifications.MarkRead(r.Context(), userID, strings.TrimSpace(req.ID)); err != nil {
+		if errors.Is(err, notifications.ErrNotificationNotFound) {
+			functions.WriteError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		functions.WriteError(w, http.StatusInternalServerError, "could not mark notification as read")
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+}
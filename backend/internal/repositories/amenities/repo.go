@@ -0,0 +1,229 @@
+package amenities
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+type Amenity struct {
+	ID       string  `json:"id"`
+	Key      string  `json:"key"`
+	Label    string  `json:"label"`
+	Category *string `json:"category,omitempty"`
+}
+
+type Repo struct {
+	DB *sql.DB
+}
+
+type UnknownAmenityError struct {
+	Key string
+}
+
+func (err UnknownAmenityError) Error() string {
+	return fmt.Sprintf("unknown amenity: %s", err.Key)
+}
+
+type execQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (repo Repo) List(ctx context.Context) ([]Amenity, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT id::text, key, label, category
+		FROM amenities
+		ORDER BY category ASC NULLS LAST, label ASC
+		`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAmenities(rows)
+}
+
+func (repo Repo) ListForListing(ctx context.Context, listingID string) ([]Amenity, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT a.id::text, a.key, a.label, a.category
+		FROM listing_amenities la
+		JOIN amenities a ON a.id = la.amenity_id
+		WHERE la.listing_id = $1::uuid
+		ORDER BY a.category ASC NULLS LAST, a.label ASC
+		`, listingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAmenities(rows)
+}
+
+func (repo Repo) ListForPreferences(ctx context.Context, userID string) ([]Amenity, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT a.id::text, a.key, a.label, a.category
+		FROM roommate_preference_amenities rpa
+		JOIN amenities a ON a.id = rpa.amenity_id
+		WHERE rpa.user_id = $1::uuid
+		ORDER BY a.category ASC NULLS LAST, a.label ASC
+		`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAmenities(rows)
+}
+
+// checkKeys returns an UnknownAmenityError for the first key missing from the catalog
+func checkKeys(ctx context.Context, db execQueryer, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT k
+		FROM unnest($1::text[]) AS k
+		WHERE NOT EXISTS (SELECT 1 FROM amenities a WHERE a.key = k)
+		LIMIT 1
+		`, pq.Array(keys))
+	if err != nil {
+		return err
+	}
+
+	var unknown string
+	found := rows.Next()
+	if found {
+		err = rows.Scan(&unknown)
+	}
+	_ = rows.Close()
+	if err != nil {
+		return err
+	}
+	if found {
+		return UnknownAmenityError{Key: unknown}
+	}
+	return nil
+}
+
+// ValidateKeys checks that every key exists in the amenity catalog
+func (repo Repo) ValidateKeys(ctx context.Context, keys []string) error {
+	return checkKeys(ctx, repo.DB, keys)
+}
+
+// replaceAmenities replaces the amenities linked to ownerID in a join table with the given keys
+// table and ownerColumn are never user input
+func replaceAmenities(ctx context.Context, db execQueryer, table string, ownerColumn string, ownerID string, keys []string) error {
+	keys = NormalizeKeys(keys)
+
+	if err := checkKeys(ctx, db, keys); err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, `DELETE FROM `+table+` WHERE `+ownerColumn+` = $1::uuid`, ownerID); err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO `+table+` (`+ownerColumn+`, amenity_id)
+		SELECT $1::uuid, a.id
+		FROM amenities a
+		WHERE a.key = ANY($2::text[])
+		ON CONFLICT DO NOTHING
+		`, ownerID, pq.Array(keys))
+	return err
+}
+
+func (repo Repo) SetForListing(ctx context.Context, listingID string, keys []string) error {
+	return replaceAmenities(ctx, repo.DB, "listing_amenities", "listing_id", listingID, keys)
+}
+
+func (repo Repo) SetForListingTx(ctx context.Context, tx *sql.Tx, listingID string, keys []string) error {
+	return replaceAmenities(ctx, tx, "listing_amenities", "listing_id", listingID, keys)
+}
+
+// SetForPreferencesTx replaces the amenities a user wants in their roommate preferences
+func (repo Repo) SetForPreferencesTx(ctx context.Context, tx *sql.Tx, userID string, keys []string) error {
+	return replaceAmenities(ctx, tx, "roommate_preference_amenities", "user_id", userID, keys)
+}
+
+// KeysForListings returns the amenity keys of each listing, listings without amenities are absent
+func (repo Repo) KeysForListings(ctx context.Context, listingIDs []string) (map[string][]string, error) {
+	return repo.keysByOwner(ctx, "listing_amenities", "listing_id", listingIDs)
+}
+
+// KeysForPreferences returns the amenity keys each user wants in a roommate setup
+func (repo Repo) KeysForPreferences(ctx context.Context, userIDs []string) (map[string][]string, error) {
+	return repo.keysByOwner(ctx, "roommate_preference_amenities", "user_id", userIDs)
+}
+
+// keysByOwner groups amenity keys by the owner column of a join table
+// table and ownerColumn are never user input
+func (repo Repo) keysByOwner(ctx context.Context, table string, ownerColumn string, ownerIDs []string) (map[string][]string, error) {
+	out := make(map[string][]string)
+	if len(ownerIDs) == 0 {
+		return out, nil
+	}
+
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT j.`+ownerColumn+`::text, a.key
+		FROM `+table+` j
+		JOIN amenities a ON a.id = j.amenity_id
+		WHERE j.`+ownerColumn+` = ANY($1::uuid[])
+		`, pq.Array(ownerIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ownerID, key string
+		if err := rows.Scan(&ownerID, &key); err != nil {
+			return nil, err
+		}
+		out[ownerID] = append(out[ownerID], key)
+	}
+	return out, rows.Err()
+}
+
+// NormalizeKeys trims, lowercases and de-duplicates amenity keys, it also splits comma separated values
+func NormalizeKeys(values []string) []string {
+	seen := make(map[string]struct{})
+	out := make([]string, 0, len(values))
+
+	for _, value := range values {
+		for _, key := range strings.Split(value, ",") {
+			key = strings.ToLower(strings.TrimSpace(key))
+			if key == "" {
+				continue
+			}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			out = append(out, key)
+		}
+	}
+
+	return out
+}
+
+func scanAmenities(rows *sql.Rows) ([]Amenity, error) {
+	out := make([]Amenity, 0)
+	for rows.Next() {
+		var amenity Amenity
+		if err := rows.Scan(&amenity.ID, &amenity.Key, &amenity.Label, &amenity.Category); err != nil {
+			return nil, err
+		}
+		out = append(out, amenity)
+	}
+	return out, rows.Err()
+}
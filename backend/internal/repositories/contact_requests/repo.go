@@ -0,0 +1,232 @@
+package contact_requests
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	StatusOpen     = "open"
+	StatusAccepted = "accepted"
+	StatusDeclined = "declined"
+	StatusClosed   = "closed"
+)
+
+type ContactRequest struct {
+	ID              string    `json:"id"`
+	ListingID       string    `json:"listingId"`
+	ListingTitle    string    `json:"listingTitle"`
+	SenderUserID    string    `json:"senderUserId"`
+	SenderName      string    `json:"senderName"`
+	RecipientUserID string    `json:"recipientUserId"`
+	RecipientName   string    `json:"recipientName"`
+	Subject         *string   `json:"subject,omitempty"`
+	Message         string    `json:"message"`
+	Status          string    `json:"status"`
+	RoomID          *string   `json:"roomId,omitempty"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+type InsertParams struct {
+	ListingID    string
+	SenderUserID string
+	Subject      *string
+	Message      string
+}
+
+type Repo struct {
+	DB *sql.DB
+}
+
+var ErrListingNotFound = errors.New("listing not found")
+var ErrOwnListing = errors.New("you cannot contact yourself about your own listing")
+var ErrAlreadyOpen = errors.New("you already have an open request for this listing")
+var ErrRequestNotFound = errors.New("contact request not found")
+var ErrInvalidTransition = errors.New("contact request status change not allowed")
+
+const contactRequestColumns = `
+	cr.id::text,
+	cr.listing_id::text,
+	l.title,
+	cr.sender_user_id::text,
+	s.name,
+	cr.recipient_user_id::text,
+	r.name,
+	cr.subject,
+	cr.message,
+	cr.status,
+	cr.room_id::text,
+	cr.created_at,
+	cr.updated_at`
+
+const contactRequestJoins = `
+	JOIN listings l ON l.id = cr.listing_id
+	JOIN users s ON s.id = cr.sender_user_id
+	JOIN users r ON r.id = cr.recipient_user_id`
+
+func scanDest(request *ContactRequest) []any {
+	return []any{
+		&request.ID,
+		&request.ListingID,
+		&request.ListingTitle,
+		&request.SenderUserID,
+		&request.SenderName,
+		&request.RecipientUserID,
+		&request.RecipientName,
+		&request.Subject,
+		&request.Message,
+		&request.Status,
+		&request.RoomID,
+		&request.CreatedAt,
+		&request.UpdatedAt,
+	}
+}
+
+// Create sends a request to the owner of an active listing
+func (repo Repo) Create(ctx context.Context, params InsertParams) (ContactRequest, error) {
+	var id string
+	err := repo.DB.QueryRowContext(ctx, `
+		INSERT INTO contact_requests (listing_id, sender_user_id, recipient_user_id, subject, message)
+		SELECT l.id, $2::uuid, l.user_id, $3, $4
+		FROM listings l
+		WHERE l.id = $1::uuid AND l.status = 'active'
+		RETURNING id::text
+		`, params.ListingID, params.SenderUserID, params.Subject, params.Message).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ContactRequest{}, ErrListingNotFound
+		}
+		var pgErr *pq.Error
+		if errors.As(err, &pgErr) {
+			switch pgErr.Code {
+			case "22P02":
+				return ContactRequest{}, ErrListingNotFound
+			case "23505":
+				return ContactRequest{}, ErrAlreadyOpen
+			case "23514":
+				return ContactRequest{}, ErrOwnListing
+			}
+		}
+		return ContactRequest{}, err
+	}
+
+	return repo.GetByID(ctx, id)
+}
+
+func (repo Repo) GetByID(ctx context.Context, requestID string) (ContactRequest, error) {
+	var request ContactRequest
+	err := repo.DB.QueryRowContext(ctx, `
+		SELECT `+contactRequestColumns+`
+		FROM contact_requests cr
+		`+contactRequestJoins+`
+		WHERE cr.id = $1::uuid
+		`, requestID).Scan(scanDest(&request)...)
+	if err != nil {
+		return ContactRequest{}, mapRequestError(err)
+	}
+	return request, nil
+}
+
+// ListInbox returns requests received by the user for their listings, newest first
+func (repo Repo) ListInbox(ctx context.Context, recipientID string, status string) ([]ContactRequest, error) {
+	return repo.list(ctx, "cr.recipient_user_id", recipientID, status)
+}
+
+// ListSent returns requests the user sent, newest first
+func (repo Repo) ListSent(ctx context.Context, senderID string, status string) ([]ContactRequest, error) {
+	return repo.list(ctx, "cr.sender_user_id", senderID, status)
+}
+
+func (repo Repo) list(ctx context.Context, userColumn string, userID string, status string) ([]ContactRequest, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT `+contactRequestColumns+`
+		FROM contact_requests cr
+		`+contactRequestJoins+`
+		WHERE `+userColumn+` = $1::uuid
+			AND ($2 = '' OR cr.status = $2)
+		ORDER BY cr.created_at DESC, cr.id DESC
+		LIMIT 200
+		`, userID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]ContactRequest, 0)
+	for rows.Next() {
+		var request ContactRequest
+		if err := rows.Scan(scanDest(&request)...); err != nil {
+			return nil, err
+		}
+		out = append(out, request)
+	}
+	return out, rows.Err()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// transition moves a request from one of the given statuses to a new one
+// it fails with ErrInvalidTransition when the request is no longer in an allowed status
+func transition(ctx context.Context, db execer, requestID string, from []string, to string) error {
+	result, err := db.ExecContext(ctx, `
+		UPDATE contact_requests
+		SET status = $3, updated_at = now()
+		WHERE id = $1::uuid AND status = ANY($2::text[])
+		`, requestID, pq.Array(from), to)
+	if err != nil {
+		return mapRequestError(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInvalidTransition
+	}
+	return nil
+}
+
+func (repo Repo) Transition(ctx context.Context, requestID string, from []string, to string) error {
+	return transition(ctx, repo.DB, requestID, from, to)
+}
+
+// TransitionTx also locks the request until tx ends, a concurrent transition waits and then sees the new status
+func (repo Repo) TransitionTx(ctx context.Context, tx *sql.Tx, requestID string, from []string, to string) error {
+	return transition(ctx, tx, requestID, from, to)
+}
+
+func setRoom(ctx context.Context, db execer, requestID string, roomID string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE contact_requests
+		SET room_id = $2::uuid, updated_at = now()
+		WHERE id = $1::uuid
+		`, requestID, roomID)
+	return mapRequestError(err)
+}
+
+func (repo Repo) SetRoom(ctx context.Context, requestID string, roomID string) error {
+	return setRoom(ctx, repo.DB, requestID, roomID)
+}
+
+func (repo Repo) SetRoomTx(ctx context.Context, tx *sql.Tx, requestID string, roomID string) error {
+	return setRoom(ctx, tx, requestID, roomID)
+}
+
+func mapRequestError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRequestNotFound
+	}
+	var pgErr *pq.Error
+	if errors.As(err, &pgErr) && pgErr.Code == "22P02" {
+		return ErrRequestNotFound
+	}
+	return err
+}
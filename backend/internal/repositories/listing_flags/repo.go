@@ -0,0 +1,219 @@
+package listing_flags
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// the listing reuses photos of a listing owned by someone else
+	ReasonDuplicatePhotos = "duplicate_photos"
+	// a moderator hid the listing after a report
+	ReasonReported = "reported"
+)
+
+const (
+	StatusOpen      = "open"
+	StatusDismissed = "dismissed"
+	StatusConfirmed = "confirmed"
+)
+
+// Flag queues a listing for moderation. While it is open, and after a moderator confirmed it,
+// the listing can not go live, dismissing the flag releases the listing
+type Flag struct {
+	ID        string          `json:"id"`
+	ListingID string          `json:"listingId"`
+	Reason    string          `json:"reason"`
+	Status    string          `json:"status"`
+	Details   json.RawMessage `json:"details"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+	// set once a moderator dismissed or confirmed the flag
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+	ResolvedBy *string    `json:"resolvedBy,omitempty"`
+}
+
+var ErrFlagNotFound = errors.New("listing flag not found")
+var ErrInvalidTransition = errors.New("listing flag status change not allowed")
+var ErrUnderReview = errors.New("listing is held by moderation")
+
+type Repo struct {
+	DB *sql.DB
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+const flagColumns = `
+	lf.id::text,
+	lf.listing_id::text,
+	lf.reason,
+	lf.status,
+	lf.details,
+	lf.created_at,
+	lf.updated_at,
+	lf.resolved_at,
+	lf.resolved_by::text`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanFlag(row rowScanner) (Flag, error) {
+	var flag Flag
+	var details []byte
+
+	err := row.Scan(
+		&flag.ID,
+		&flag.ListingID,
+		&flag.Reason,
+		&flag.Status,
+		&details,
+		&flag.CreatedAt,
+		&flag.UpdatedAt,
+		&flag.ResolvedAt,
+		&flag.ResolvedBy,
+	)
+	if err != nil {
+		return Flag{}, mapFlagError(err)
+	}
+
+	flag.Details = details
+	return flag, nil
+}
+
+// open queues the listing, when it already has an open flag for the reason the new details
+// (a JSON array) are appended to that flag instead
+func open(ctx context.Context, db queryRower, listingID string, reason string, details any) (Flag, error) {
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return Flag{}, err
+	}
+
+	return scanFlag(db.QueryRowContext(ctx, `
+		INSERT INTO listing_flags AS lf (listing_id, reason, details)
+		VALUES ($1::uuid, $2, $3::jsonb)
+		ON CONFLICT (listing_id, reason) WHERE status = 'open'
+		DO UPDATE SET
+			details = lf.details || EXCLUDED.details,
+			updated_at = now()
+		RETURNING `+flagColumns,
+		listingID, reason, string(encoded),
+	))
+}
+
+func (repo Repo) Open(ctx context.Context, listingID string, reason string, details any) (Flag, error) {
+	return open(ctx, repo.DB, listingID, reason, details)
+}
+
+func (repo Repo) OpenTx(ctx context.Context, tx *sql.Tx, listingID string, reason string, details any) (Flag, error) {
+	return open(ctx, tx, listingID, reason, details)
+}
+
+// isHeld reports whether an open or confirmed flag keeps the listing from going live
+func isHeld(ctx context.Context, db queryRower, listingID string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM listing_flags
+			WHERE listing_id = $1::uuid AND status IN ('open', 'confirmed')
+		)
+		`, listingID).Scan(&exists)
+	if err != nil {
+		return false, mapFlagError(err)
+	}
+	return exists, nil
+}
+
+func (repo Repo) IsHeld(ctx context.Context, listingID string) (bool, error) {
+	return isHeld(ctx, repo.DB, listingID)
+}
+
+func (repo Repo) IsHeldTx(ctx context.Context, tx *sql.Tx, listingID string) (bool, error) {
+	return isHeld(ctx, tx, listingID)
+}
+
+// List returns flags with the given status, oldest first so the queue is worked in order
+func (repo Repo) List(ctx context.Context, status string, limit int) ([]Flag, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT `+flagColumns+`
+		FROM listing_flags lf
+		WHERE lf.status = $1
+		ORDER BY lf.created_at ASC, lf.id ASC
+		LIMIT $2
+		`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Flag, 0)
+	for rows.Next() {
+		flag, err := scanFlag(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, flag)
+	}
+	return out, rows.Err()
+}
+
+// Resolve records a moderator decision: an open flag can be dismissed or confirmed, a confirmed
+// flag can still be dismissed to release the listing. Other changes fail with ErrInvalidTransition
+func (repo Repo) Resolve(ctx context.Context, flagID string, status string, resolvedBy string) (Flag, error) {
+	from := []string{StatusOpen}
+	if status == StatusDismissed {
+		from = append(from, StatusConfirmed)
+	}
+
+	flag, err := scanFlag(repo.DB.QueryRowContext(ctx, `
+		UPDATE listing_flags lf
+		SET
+			status = $2,
+			resolved_at = now(),
+			resolved_by = $3::uuid,
+			updated_at = now()
+		WHERE lf.id = $1::uuid AND lf.status = ANY($4::text[])
+		RETURNING `+flagColumns,
+		flagID, status, resolvedBy, pq.Array(from),
+	))
+	if !errors.Is(err, ErrFlagNotFound) {
+		return flag, err
+	}
+
+	// tell an unknown flag apart from one that can not make this change
+	var exists bool
+	if err := repo.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM listing_flags WHERE id = $1::uuid)`, flagID).Scan(&exists); err != nil {
+		return Flag{}, mapFlagError(err)
+	}
+	if exists {
+		return Flag{}, ErrInvalidTransition
+	}
+	return Flag{}, ErrFlagNotFound
+}
+
+func mapFlagError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrFlagNotFound
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "22P02" {
+		return ErrFlagNotFound
+	}
+	return err
+}
@@ -3,7 +3,11 @@ package listing_images
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type ListingImage struct {
@@ -13,15 +17,53 @@ type ListingImage struct {
 	AltText     *string   `json:"altText,omitempty"`
 	SortOrder   int       `json:"sortOrder"`
 	IsThumbnail bool      `json:"isThumbnail"`
+	Width       *int      `json:"width,omitempty"`
+	Height      *int      `json:"height,omitempty"`
 	CreatedAt   time.Time `json:"createdAt"`
+	// variant name -> object key, empty for images stored before processing existed
+	VariantKeys map[string]string `json:"-"`
+	// presigned GET URL of the full image, only set on responses
+	URL string `json:"url,omitempty"`
+	// variant name -> presigned GET URL, only set on responses
+	Variants map[string]string `json:"variants,omitempty"`
+}
+
+var ErrImageNotFound = errors.New("listing image not found")
+var ErrImageOrderMismatch = errors.New("image order must list every image of the listing exactly once")
+var ErrDistanceTooLarge = errors.New("photo hash distance is too large to look up")
+
+type VariantParams struct {
+	Variant     string
+	S3Key       string
+	ContentType string
+	Width       int
+	Height      int
+	SizeBytes   int64
 }
 
 type InsertListingImageParams struct {
+	// optional, the image id is part of the variant keys so it is chosen before the upload
+	ID          string
 	ListingID   string
 	S3Key       string
 	AltText     *string
 	SortOrder   int
 	IsThumbnail bool
+	Width       *int
+	Height      *int
+	// perceptual hash, see imaging.DHash
+	PHash    *int64
+	Variants []VariantParams
+}
+
+// SimilarImage is an image of another user's listing that looks like one of the hashes searched for
+type SimilarImage struct {
+	// position of the matched hash in the hashes passed to FindSimilar
+	Index     int    `json:"-"`
+	ImageID   string `json:"imageId"`
+	ListingID string `json:"listingId"`
+	// number of differing hash bits, 0 is the same photo
+	Distance int `json:"distance"`
 }
 
 type Repo struct {
@@ -32,43 +74,114 @@ type queryRower interface {
 	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
+// ObjectKeys lists every stored object of the image
+func (image ListingImage) ObjectKeys() []string {
+	keys := []string{image.S3Key}
+	for _, key := range image.VariantKeys {
+		if key != image.S3Key {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+const imageColumns = `
+	li.id::text,
+	li.listing_id::text,
+	li.s3_key,
+	li.alt_text,
+	li.sort_order,
+	li.is_thumbnail,
+	li.width,
+	li.height,
+	li.created_at`
+
+func imageScanDest(image *ListingImage) []any {
+	return []any{
+		&image.ID,
+		&image.ListingID,
+		&image.S3Key,
+		&image.AltText,
+		&image.SortOrder,
+		&image.IsThumbnail,
+		&image.Width,
+		&image.Height,
+		&image.CreatedAt,
+	}
+}
+
+// insertListingImage writes the image and its variants in one statement so callers without a transaction
+// never leave an image with only some of its variants
 func insertListingImage(ctx context.Context, db queryRower, params InsertListingImageParams) (ListingImage, error) {
 	var image ListingImage
 
+	variants := make([]string, len(params.Variants))
+	keys := make([]string, len(params.Variants))
+	contentTypes := make([]string, len(params.Variants))
+	widths := make([]int64, len(params.Variants))
+	heights := make([]int64, len(params.Variants))
+	sizes := make([]int64, len(params.Variants))
+	for i, variant := range params.Variants {
+		variants[i] = variant.Variant
+		keys[i] = variant.S3Key
+		contentTypes[i] = variant.ContentType
+		widths[i] = int64(variant.Width)
+		heights[i] = int64(variant.Height)
+		sizes[i] = variant.SizeBytes
+	}
+
 	err := db.QueryRowContext(ctx, `
-		INSERT INTO listing_images (
-			listing_id,
-		    s3_key,
-		    alt_text,
-			sort_order,
-		    is_thumbnail
+		WITH li AS (
+			INSERT INTO listing_images (
+				id,
+				listing_id,
+				s3_key,
+				alt_text,
+				sort_order,
+				is_thumbnail,
+				width,
+				height,
+				phash
+			)
+			VALUES (coalesce(nullif($1, '')::uuid, uuid_generate_v4()), $2, $3, $4, $5, $6, $7, $8, $15)
+			RETURNING *
+		), variants AS (
+			INSERT INTO listing_image_variants (image_id, variant, s3_key, content_type, width, height, size_bytes)
+			SELECT li.id, v.variant, v.s3_key, v.content_type, v.width, v.height, v.size_bytes
+			FROM li, unnest($9::text[], $10::text[], $11::text[], $12::int[], $13::int[], $14::bigint[])
+				AS v(variant, s3_key, content_type, width, height, size_bytes)
 		)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING
-			id::text,
-			listing_id::text,
-			s3_key,
-			alt_text,
-			sort_order,
-			is_thumbnail,
-			created_at
+		SELECT `+imageColumns+`
+		FROM li
 		`,
+		params.ID,
 		params.ListingID,
 		params.S3Key,
 		params.AltText,
 		params.SortOrder,
 		params.IsThumbnail,
-	).Scan(
-		&image.ID,
-		&image.ListingID,
-		&image.S3Key,
-		&image.AltText,
-		&image.SortOrder,
-		&image.IsThumbnail,
-		&image.CreatedAt,
-	)
+		params.Width,
+		params.Height,
+		pq.Array(variants),
+		pq.Array(keys),
+		pq.Array(contentTypes),
+		pq.Array(widths),
+		pq.Array(heights),
+		pq.Array(sizes),
+		params.PHash,
+	).Scan(imageScanDest(&image)...)
+	if err != nil {
+		return ListingImage{}, err
+	}
+
+	if len(params.Variants) > 0 {
+		image.VariantKeys = make(map[string]string, len(params.Variants))
+		for _, variant := range params.Variants {
+			image.VariantKeys[variant.Variant] = variant.S3Key
+		}
+	}
 
-	return image, err
+	return image, nil
 }
 
 func (repo Repo) InsertListingImage(ctx context.Context, params InsertListingImageParams) (ListingImage, error) {
@@ -78,3 +191,360 @@ func (repo Repo) InsertListingImage(ctx context.Context, params InsertListingIma
 func (repo Repo) InsertListingImageTx(ctx context.Context, tx *sql.Tx, params InsertListingImageParams) (ListingImage, error) {
 	return insertListingImage(ctx, tx, params)
 }
+
+func (repo Repo) ListByListing(ctx context.Context, listingID string) ([]ListingImage, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT
+			`+imageColumns+`,
+			`+variantKeysColumn+`
+		FROM listing_images li
+		WHERE li.listing_id = $1::uuid
+		ORDER BY li.sort_order ASC, li.created_at ASC
+		`, listingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]ListingImage, 0)
+	for rows.Next() {
+		image, err := scanImageWithVariants(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, image)
+	}
+	return out, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// variantKeysColumn aggregates the variant keys of li into a json object
+const variantKeysColumn = `(
+	SELECT jsonb_object_agg(v.variant, v.s3_key)
+	FROM listing_image_variants v
+	WHERE v.image_id = li.id
+)`
+
+// scanImageWithVariants scans imageColumns followed by variantKeysColumn
+func scanImageWithVariants(row rowScanner) (ListingImage, error) {
+	var image ListingImage
+	var variantKeys []byte
+	if err := row.Scan(append(imageScanDest(&image), &variantKeys)...); err != nil {
+		return ListingImage{}, err
+	}
+	if variantKeys != nil {
+		if err := json.Unmarshal(variantKeys, &image.VariantKeys); err != nil {
+			return ListingImage{}, err
+		}
+	}
+	return image, nil
+}
+
+func (repo Repo) Get(ctx context.Context, listingID string, imageID string) (ListingImage, error) {
+	image, err := scanImageWithVariants(repo.DB.QueryRowContext(ctx, `
+		SELECT
+			`+imageColumns+`,
+			`+variantKeysColumn+`
+		FROM listing_images li
+		WHERE li.id = $1::uuid AND li.listing_id = $2::uuid
+		`, imageID, listingID))
+	if err != nil {
+		return ListingImage{}, mapImageError(err)
+	}
+	return image, nil
+}
+
+// SetAltText replaces the alt text of one image, nil clears it
+func (repo Repo) SetAltText(ctx context.Context, listingID string, imageID string, altText *string) (ListingImage, error) {
+	image, err := scanImageWithVariants(repo.DB.QueryRowContext(ctx, `
+		UPDATE listing_images li
+		SET alt_text = $3
+		WHERE li.id = $1::uuid AND li.listing_id = $2::uuid
+		RETURNING
+			`+imageColumns+`,
+			`+variantKeysColumn+`
+		`, imageID, listingID, altText))
+	if err != nil {
+		return ListingImage{}, mapImageError(err)
+	}
+	return image, nil
+}
+
+// ReorderTx sets sort_order from the position of each id, imageIDs must be exactly the images of the listing
+func (repo Repo) ReorderTx(ctx context.Context, tx *sql.Tx, listingID string, imageIDs []string) error {
+	if err := lockListing(ctx, tx, listingID); err != nil {
+		return err
+	}
+
+	// the listing is locked, so no image can be added or removed between this check and the update
+	var matching, total int
+	err := tx.QueryRowContext(ctx, `
+		SELECT
+			count(*) FILTER (WHERE li.id::text = ANY($2::text[])),
+			count(*)
+		FROM listing_images li
+		WHERE li.listing_id = $1::uuid
+		`, listingID, pq.Array(imageIDs)).Scan(&matching, &total)
+	if err != nil {
+		return err
+	}
+	if matching != total || total != len(imageIDs) {
+		return ErrImageOrderMismatch
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE listing_images li
+		SET sort_order = o.position - 1
+		FROM unnest($2::text[]) WITH ORDINALITY AS o(id, position)
+		WHERE li.listing_id = $1::uuid AND li.id::text = o.id
+		`, listingID, pq.Array(imageIDs))
+	return err
+}
+
+// SetThumbnailTx moves the thumbnail flag to one image, the old flag is cleared first
+// so uniq_listing_images_single_thumbnail never sees two thumbnails
+func (repo Repo) SetThumbnailTx(ctx context.Context, tx *sql.Tx, listingID string, imageID string) error {
+	if err := lockListing(ctx, tx, listingID); err != nil {
+		return err
+	}
+	if err := repo.ClearThumbnailTx(ctx, tx, listingID); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE listing_images
+		SET is_thumbnail = true
+		WHERE id = $1::uuid AND listing_id = $2::uuid
+		`, imageID, listingID)
+	if err != nil {
+		return mapImageError(err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrImageNotFound
+	}
+	return nil
+}
+
+// DeleteTx removes one image and returns it so the caller can delete its objects after commit,
+// when it was the thumbnail the first remaining image takes over
+func (repo Repo) DeleteTx(ctx context.Context, tx *sql.Tx, listingID string, imageID string) (ListingImage, error) {
+	if err := lockListing(ctx, tx, listingID); err != nil {
+		return ListingImage{}, err
+	}
+
+	// variants are collected before the delete cascades them away
+	image, err := scanImageWithVariants(tx.QueryRowContext(ctx, `
+		SELECT
+			`+imageColumns+`,
+			`+variantKeysColumn+`
+		FROM listing_images li
+		WHERE li.id = $1::uuid AND li.listing_id = $2::uuid
+		`, imageID, listingID))
+	if err != nil {
+		return ListingImage{}, mapImageError(err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM listing_images WHERE id = $1::uuid`, image.ID); err != nil {
+		return ListingImage{}, err
+	}
+
+	if image.IsThumbnail {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE listing_images
+			SET is_thumbnail = true
+			WHERE id = (
+				SELECT id
+				FROM listing_images
+				WHERE listing_id = $1::uuid
+				ORDER BY sort_order ASC, created_at ASC
+				LIMIT 1
+			)
+			`, listingID)
+		if err != nil {
+			return ListingImage{}, err
+		}
+	}
+
+	return image, nil
+}
+
+func mapImageError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrImageNotFound
+	}
+
+	var pgErr *pq.Error
+	if errors.As(err, &pgErr) && pgErr.Code == "22P02" {
+		return ErrImageNotFound
+	}
+	return err
+}
+
+// ImageState summarizes the images a listing already has, new images are appended after them
+type ImageState struct {
+	Count         int
+	NextSortOrder int
+	HasThumbnail  bool
+}
+
+func imageState(ctx context.Context, db queryRower, listingID string) (ImageState, error) {
+	var state ImageState
+	err := db.QueryRowContext(ctx, `
+		SELECT
+			count(*),
+			coalesce(max(sort_order) + 1, 0),
+			coalesce(bool_or(is_thumbnail), false)
+		FROM listing_images
+		WHERE listing_id = $1::uuid
+		`, listingID).Scan(&state.Count, &state.NextSortOrder, &state.HasThumbnail)
+	return state, err
+}
+
+func (repo Repo) ImageState(ctx context.Context, listingID string) (ImageState, error) {
+	return imageState(ctx, repo.DB, listingID)
+}
+
+// ImageStateTx locks the listing row first so concurrent uploads to one listing append in turn
+func (repo Repo) ImageStateTx(ctx context.Context, tx *sql.Tx, listingID string) (ImageState, error) {
+	if err := lockListing(ctx, tx, listingID); err != nil {
+		return ImageState{}, err
+	}
+	return imageState(ctx, tx, listingID)
+}
+
+// lockListing serializes changes to the images of one listing, the thumbnail and sort order
+// span several rows so row locks on listing_images alone are not enough
+func lockListing(ctx context.Context, tx *sql.Tx, listingID string) error {
+	_, err := tx.ExecContext(ctx, `SELECT 1 FROM listings WHERE id = $1::uuid FOR UPDATE`, listingID)
+	return err
+}
+
+// ClearThumbnailTx unflags the current thumbnail so another image can take it
+// without tripping the single thumbnail index
+func (repo Repo) ClearThumbnailTx(ctx context.Context, tx *sql.Tx, listingID string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE listing_images
+		SET is_thumbnail = false
+		WHERE listing_id = $1::uuid AND is_thumbnail
+		`, listingID)
+	return err
+}
+
+// ReferencedKeys returns which of keys are still used by an image or one of its variants
+func (repo Repo) ReferencedKeys(ctx context.Context, keys []string) (map[string]bool, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT k.key
+		FROM unnest($1::text[]) AS k(key)
+		WHERE EXISTS (SELECT 1 FROM listing_images li WHERE li.s3_key = k.key)
+			OR EXISTS (SELECT 1 FROM listing_image_variants v WHERE v.s3_key = k.key)
+		`, pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referenced := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		referenced[key] = true
+	}
+	return referenced, rows.Err()
+}
+
+// FindSimilar looks hashes up by their 16 bit bands, see migration 0024. Probing every band and its single
+// bit flips finds all matches up to maxHashDistance bits apart
+const (
+	hashBands       = 4
+	hashBandBits    = 16
+	maxHashDistance = 2*hashBands - 1
+)
+
+// FindSimilar returns images of listings not owned by userID whose hash is within maxDistance bits of
+// one of hashes, closest first. Candidates come from the hash band indexes, only they get their exact
+// distance computed. Needs PostgreSQL 14 or newer for bit_count
+func (repo Repo) FindSimilar(ctx context.Context, userID string, hashes []int64, maxDistance int) ([]SimilarImage, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	if maxDistance > maxHashDistance {
+		return nil, ErrDistanceTooLarge
+	}
+
+	// every band of every hash, as is and with each of its bits flipped
+	probeCount := len(hashes) * hashBands * (hashBandBits + 1)
+	indexes := make([]int64, 0, probeCount)
+	probeHashes := make([]int64, 0, probeCount)
+	bands := make([]int64, 0, probeCount)
+	values := make([]int64, 0, probeCount)
+	for i, hash := range hashes {
+		for band := 0; band < hashBands; band++ {
+			value := int64(uint64(hash)>>(hashBandBits*(hashBands-1-band))) & (1<<hashBandBits - 1)
+			for flip := -1; flip < hashBandBits; flip++ {
+				probe := value
+				if flip >= 0 {
+					probe ^= 1 << flip
+				}
+				indexes = append(indexes, int64(i))
+				probeHashes = append(probeHashes, hash)
+				bands = append(bands, int64(band))
+				values = append(values, probe)
+			}
+		}
+	}
+
+	rows, err := repo.DB.QueryContext(ctx, `
+		WITH probes AS (
+		    SELECT *
+		    FROM unnest($1::int[], $2::bigint[], $3::int[], $4::int[]) AS p(idx, hash, band, value)
+		),
+		candidates AS (
+		    SELECT p.idx, p.hash, li.id
+		    FROM probes p JOIN listing_images li ON li.phash_band0 = p.value AND li.phash IS NOT NULL
+		    WHERE p.band = 0
+		    UNION
+		    SELECT p.idx, p.hash, li.id
+		    FROM probes p JOIN listing_images li ON li.phash_band1 = p.value AND li.phash IS NOT NULL
+		    WHERE p.band = 1
+		    UNION
+		    SELECT p.idx, p.hash, li.id
+		    FROM probes p JOIN listing_images li ON li.phash_band2 = p.value AND li.phash IS NOT NULL
+		    WHERE p.band = 2
+		    UNION
+		    SELECT p.idx, p.hash, li.id
+		    FROM probes p JOIN listing_images li ON li.phash_band3 = p.value AND li.phash IS NOT NULL
+		    WHERE p.band = 3
+		)
+		SELECT c.idx, li.id::text, li.listing_id::text, m.distance
+		FROM candidates c
+		JOIN listing_images li ON li.id = c.id
+		JOIN listings l ON l.id = li.listing_id
+		CROSS JOIN LATERAL (SELECT bit_count((li.phash # c.hash)::bit(64))::int AS distance) m
+		WHERE l.user_id <> $5::uuid
+			AND m.distance <= $6
+		ORDER BY m.distance ASC, c.idx ASC
+		`, pq.Array(indexes), pq.Array(probeHashes), pq.Array(bands), pq.Array(values), userID, maxDistance)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]SimilarImage, 0)
+	for rows.Next() {
+		var similar SimilarImage
+		if err := rows.Scan(&similar.Index, &similar.ImageID, &similar.ListingID, &similar.Distance); err != nil {
+			return nil, err
+		}
+		out = append(out, similar)
+	}
+	return out, rows.Err()
+}
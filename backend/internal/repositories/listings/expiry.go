@@ -0,0 +1,121 @@
+package listings
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrListingNotActive = errors.New("listing is not active")
+var ErrListingNotExpired = errors.New("listing is not expired")
+
+// ListExpired returns active listings whose available_until date is before today
+// or that have not been updated since staleBefore
+func (repo Repo) ListExpired(ctx context.Context, today time.Time, staleBefore time.Time) ([]Listing, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT `+listingColumns+`
+		FROM listings l
+		WHERE l.status = 'active'
+			AND (l.available_until < $1::date OR l.updated_at < $2)
+		ORDER BY l.updated_at ASC
+		`, today.Format(time.DateOnly), staleBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanListings(rows)
+}
+
+// ListDueForReminder returns active listings that will expire before remindBy (by date or staleness)
+// and whose owner was not reminded since the listing was last updated
+func (repo Repo) ListDueForReminder(ctx context.Context, remindBy time.Time, staleBefore time.Time) ([]Listing, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT `+listingColumns+`
+		FROM listings l
+		WHERE l.status = 'active'
+			AND (l.renewal_reminded_at IS NULL OR l.renewal_reminded_at < l.updated_at)
+			AND (l.available_until < $1::date OR l.updated_at < $2)
+		ORDER BY l.updated_at ASC
+		`, remindBy.Format(time.DateOnly), staleBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanListings(rows)
+}
+
+func (repo Repo) MarkRenewalReminded(ctx context.Context, listingID string, at time.Time) error {
+	_, err := repo.DB.ExecContext(ctx, `
+		UPDATE listings
+		SET renewal_reminded_at = $2
+		WHERE id = $1::uuid
+		`, listingID, at)
+	return mapListingError(err)
+}
+
+// Expire deactivates a listing that is still expired as of today and staleBefore and records the
+// change in the history, it returns ErrListingNotExpired once the listing was renewed or unpublished
+func (repo Repo) Expire(ctx context.Context, listingID string, today time.Time, staleBefore time.Time, at time.Time) (Listing, error) {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Listing{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var listing Listing
+	err = tx.QueryRowContext(ctx, `
+		UPDATE listings l
+		SET status = $4, updated_at = $5
+		WHERE l.id = $1::uuid
+			AND l.status = 'active'
+			AND (l.available_until < $2::date OR l.updated_at < $3)
+		RETURNING `+listingColumns,
+		listingID, today.Format(time.DateOnly), staleBefore, StatusInactive, at,
+	).Scan(listingScanDest(&listing)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Listing{}, ErrListingNotExpired
+	}
+	if err != nil {
+		return Listing{}, mapListingError(err)
+	}
+
+	// system change, no actor
+	from := StatusActive
+	if err := repo.RecordStatusTx(ctx, tx, listingID, &from, StatusInactive, ""); err != nil {
+		return Listing{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Listing{}, err
+	}
+
+	return listing, nil
+}
+
+// Renew restarts the staleness window of an active listing at the given time, the expiry job
+// compares it against its own clock
+func (repo Repo) Renew(ctx context.Context, listingID string, at time.Time) (Listing, error) {
+	var listing Listing
+	err := repo.DB.QueryRowContext(ctx, `
+		UPDATE listings l
+		SET updated_at = $2, renewal_reminded_at = NULL
+		WHERE l.id = $1::uuid AND l.status = 'active'
+		RETURNING `+listingColumns,
+		listingID, at,
+	).Scan(listingScanDest(&listing)...)
+	if err != nil {
+		// the owner check ran before, so no row here means the listing is not active
+		err = mapListingError(err)
+		if errors.Is(err, ErrListingNotFound) {
+			return Listing{}, ErrListingNotActive
+		}
+		return Listing{}, err
+	}
+
+	return listing, nil
+}
@@ -0,0 +1,286 @@
+package listings
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+)
+
+// kmPerDegreeLat is the length of one degree of latitude, it is also the length of one degree of longitude at the equator
+const kmPerDegreeLat = 111.045
+
+const maxMapResults = 500
+
+var ErrInvalidBounds = errors.New("invalid bounding box")
+var ErrInvalidRadius = errors.New("invalid radius")
+
+type RadiusParams struct {
+	Filters   SearchParams
+	Latitude  float64
+	Longitude float64
+	RadiusKm  float64
+	Limit     int
+}
+
+// BoundsParams describes a map viewport, West may be greater than East when the viewport crosses the antimeridian
+type BoundsParams struct {
+	Filters SearchParams
+	North   float64
+	South   float64
+	East    float64
+	West    float64
+	// optional reference point used to compute DistanceKm (usually the map center or the user location)
+	Latitude  *float64
+	Longitude *float64
+	Limit     int
+}
+
+type MapListing struct {
+	Listing
+	DistanceKm *float64 `json:"distanceKm,omitempty"`
+}
+
+type Cluster struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Count     int     `json:"count"`
+	MinPrice  float64 `json:"minPrice"`
+	MaxPrice  float64 `json:"maxPrice"`
+	// set when the cell only holds one listing so the client can render it as a regular marker
+	ListingID *string `json:"listingId,omitempty"`
+}
+
+func (params BoundsParams) validate() error {
+	if params.North < -90 || params.North > 90 || params.South < -90 || params.South > 90 || params.South > params.North {
+		return ErrInvalidBounds
+	}
+	if params.East < -180 || params.East > 180 || params.West < -180 || params.West > 180 {
+		return ErrInvalidBounds
+	}
+	return nil
+}
+
+// haversineSQL returns the great-circle distance in km (earth radius 6371km) between the listing and the point bound to latArg/lngArg
+func haversineSQL(latArg string, lngArg string) string {
+	return `(2 * 6371.0 * asin(sqrt(
+		power(sin(radians(l.latitude::float8 - ` + latArg + `::float8) / 2), 2) +
+		cos(radians(` + latArg + `::float8)) * cos(radians(l.latitude::float8)) *
+		power(sin(radians(l.longitude::float8 - ` + lngArg + `::float8) / 2), 2)
+	)))`
+}
+
+// bounds restricts the query to a bounding box, splitting it in two when it crosses the antimeridian
+func (q *searchQuery) bounds(north, south, east, west float64) {
+	q.where("l.latitude BETWEEN %s AND %s", south, north)
+	if west <= east {
+		q.where("l.longitude BETWEEN %s AND %s", west, east)
+		return
+	}
+	q.where("(l.longitude >= %s OR l.longitude <= %s)", west, east)
+}
+
+// WithinRadius returns listings whose coordinates are at most RadiusKm away from the point, closest first
+// A bounding box prefilter keeps the (latitude, longitude) index usable before the exact haversine check
+func (repo Repo) WithinRadius(ctx context.Context, params RadiusParams) ([]MapListing, error) {
+	if params.RadiusKm <= 0 || params.RadiusKm > 500 {
+		return nil, ErrInvalidRadius
+	}
+	if params.Latitude < -90 || params.Latitude > 90 || params.Longitude < -180 || params.Longitude > 180 {
+		return nil, ErrInvalidBounds
+	}
+	if params.Limit <= 0 || params.Limit > maxMapResults {
+		params.Limit = maxMapResults
+	}
+
+	latDelta := params.RadiusKm / kmPerDegreeLat
+	north := math.Min(params.Latitude+latDelta, 90)
+	south := math.Max(params.Latitude-latDelta, -90)
+
+	var q searchQuery
+	q.filter(params.Filters)
+
+	// near the poles a degree of longitude shrinks to nothing, so only the latitude band is usable
+	cosLat := math.Cos(params.Latitude * math.Pi / 180)
+	lngDelta := 360.0
+	if cosLat > 0.01 {
+		lngDelta = params.RadiusKm / (kmPerDegreeLat * cosLat)
+	}
+	if lngDelta >= 180 {
+		q.where("l.latitude BETWEEN %s AND %s", south, north)
+	} else {
+		q.bounds(north, south, wrapLongitude(params.Longitude+lngDelta), wrapLongitude(params.Longitude-lngDelta))
+	}
+
+	latArg := q.arg(params.Latitude)
+	lngArg := q.arg(params.Longitude)
+	distance := haversineSQL(latArg, lngArg)
+	radiusArg := q.arg(params.RadiusKm)
+	limitArg := q.arg(params.Limit)
+
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT *
+		FROM (
+			SELECT
+				`+listingColumns+`,
+				li.id::text,
+				`+thumbnailKeyColumn+`,
+				li.alt_text,
+				li.created_at,
+				`+distance+` AS distance_km
+			FROM listings l
+			LEFT JOIN listing_images li
+				ON li.listing_id = l.id
+				AND li.is_thumbnail = true
+			`+q.whereClause()+`
+		) nearby
+		WHERE nearby.distance_km <= `+radiusArg+`
+		ORDER BY nearby.distance_km ASC
+		LIMIT `+limitArg,
+		q.args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMapListings(rows)
+}
+
+// WithinBounds returns the newest listings inside a map viewport
+func (repo Repo) WithinBounds(ctx context.Context, params BoundsParams) ([]MapListing, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	if params.Limit <= 0 || params.Limit > maxMapResults {
+		params.Limit = maxMapResults
+	}
+
+	var q searchQuery
+	q.filter(params.Filters)
+	q.bounds(params.North, params.South, params.East, params.West)
+
+	distance := "NULL::float8"
+	if params.Latitude != nil && params.Longitude != nil {
+		distance = haversineSQL(q.arg(*params.Latitude), q.arg(*params.Longitude))
+	}
+	limitArg := q.arg(params.Limit)
+
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT
+			`+listingColumns+`,
+			li.id::text,
+			`+thumbnailKeyColumn+`,
+			li.alt_text,
+			li.created_at,
+			`+distance+` AS distance_km
+		FROM listings l
+		LEFT JOIN listing_images li
+			ON li.listing_id = l.id
+			AND li.is_thumbnail = true
+		`+q.whereClause()+`
+		ORDER BY l.created_at DESC, l.id DESC
+		LIMIT `+limitArg,
+		q.args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMapListings(rows)
+}
+
+// Clusters groups the listings of a viewport into square grid cells of cellSizeDeg degrees
+// Each cluster is positioned at the average coordinates of its listings rather than the cell center
+func (repo Repo) Clusters(ctx context.Context, params BoundsParams, cellSizeDeg float64) ([]Cluster, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	if cellSizeDeg <= 0 {
+		return nil, ErrInvalidBounds
+	}
+
+	var q searchQuery
+	q.filter(params.Filters)
+	q.bounds(params.North, params.South, params.East, params.West)
+	cellArg := q.arg(cellSizeDeg)
+
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT
+			avg(l.latitude)::float8,
+			avg(l.longitude)::float8,
+			count(*),
+			min(l.price)::float8,
+			max(l.price)::float8,
+			CASE WHEN count(*) = 1 THEN min(l.id::text) END
+		FROM listings l
+		`+q.whereClause()+`
+		GROUP BY
+			floor(l.latitude::float8 / `+cellArg+`::float8),
+			floor(l.longitude::float8 / `+cellArg+`::float8)
+		ORDER BY count(*) DESC`,
+		q.args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Cluster
+	for rows.Next() {
+		var cluster Cluster
+		if err := rows.Scan(
+			&cluster.Latitude,
+			&cluster.Longitude,
+			&cluster.Count,
+			&cluster.MinPrice,
+			&cluster.MaxPrice,
+			&cluster.ListingID,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, cluster)
+	}
+	return out, rows.Err()
+}
+
+// ClusterCellSize returns the grid cell size in degrees for a web map zoom level,
+// roughly one cell per 64px of a 256px tile
+func ClusterCellSize(zoom int) float64 {
+	if zoom < 0 {
+		zoom = 0
+	}
+	return 360.0 / math.Pow(2, float64(zoom)) / 4
+}
+
+func scanMapListings(rows *sql.Rows) ([]MapListing, error) {
+	out := make([]MapListing, 0)
+	for rows.Next() {
+		var item MapListing
+		var distance *float64
+
+		listing, err := scanListingWithThumbnail(rows, &distance)
+		if err != nil {
+			return nil, err
+		}
+
+		item.Listing = listing
+		if distance != nil {
+			rounded := math.Round(*distance*100) / 100
+			item.DistanceKm = &rounded
+		}
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+func wrapLongitude(lng float64) float64 {
+	for lng > 180 {
+		lng -= 360
+	}
+	for lng < -180 {
+		lng += 360
+	}
+	return lng
+}
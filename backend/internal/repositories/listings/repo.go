@@ -3,8 +3,11 @@ package listings
 import (
 	"context"
 	"database/sql"
-	"go-react-rooms/internal/storage"
+	"errors"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type Listing struct {
@@ -37,6 +40,10 @@ type Listing struct {
 	CreatedAt        time.Time      `json:"createdAt"`
 	UpdatedAt        time.Time      `json:"updatedAt"`
 	Images           []ListingImage `json:"thumbnail,omitempty"`
+	// only set for authenticated callers
+	IsSaved *bool `json:"isSaved,omitempty"`
+	// only set by text searches
+	Highlight *SearchHighlight `json:"highlight,omitempty"`
 }
 
 type ListingImage struct {
@@ -53,6 +60,9 @@ type Repo struct {
 	DB *sql.DB
 }
 
+var ErrListingNotFound = errors.New("listing not found")
+var ErrInvalidListing = errors.New("listing values are out of range")
+
 type InsertParams struct {
 	UserID           string
 	Title            string
@@ -216,9 +226,8 @@ func (repo Repo) InsertTx(ctx context.Context, tx *sql.Tx, params InsertParams)
 	return insertListing(ctx, tx, params)
 }
 
-func (repo Repo) GetAllListings(ctx context.Context) ([]Listing, error) {
-	rows, err := repo.DB.QueryContext(ctx, `
-		SELECT
+// listingColumns is the select list matching listingScanDest, the listings table is aliased as l
+const listingColumns = `
 			l.id::text,
 			l.user_id::text,
 			l.title,
@@ -246,94 +255,39 @@ func (repo Repo) GetAllListings(ctx context.Context) ([]Listing, error) {
 			l.parking_available,
 			l.status,
 			l.created_at,
-			l.updated_at,
-			li.id::text,
-			li.s3_key,
-			li.alt_text,
-			li.created_at
-		FROM listings l
-		LEFT JOIN listing_images li
-		    ON li.listing_id = l.id
-			AND li.is_thumbnail = true
-		ORDER BY l.created_at DESC, li.sort_order ASC
-	`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var out []Listing
-	s3Storage, err := storage.NewS3Storage(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	for rows.Next() {
-		var listing Listing
-
-		var imageID, imageS3Key string
-		var imageAltText *string
-		var imageCreatedAt time.Time
-
-		err := rows.Scan(
-			&listing.ID,
-			&listing.UserID,
-			&listing.Title,
-			&listing.Description,
-			&listing.AddressLine1,
-			&listing.AddressLine2,
-			&listing.City,
-			&listing.Province,
-			&listing.Country,
-			&listing.PostalCode,
-			&listing.Latitude,
-			&listing.Longitude,
-			&listing.Bedrooms,
-			&listing.Bathrooms,
-			&listing.Area,
-			&listing.AreaUnit,
-			&listing.Price,
-			&listing.Currency,
-			&listing.AvailableFrom,
-			&listing.AvailableUntil,
-			&listing.MinLeaseDays,
-			&listing.IsFurnished,
-			&listing.PetsAllowed,
-			&listing.SmokingAllowed,
-			&listing.ParkingAvailable,
-			&listing.Status,
-			&listing.CreatedAt,
-			&listing.UpdatedAt,
-			&imageID,
-			&imageS3Key,
-			&imageAltText,
-			&imageCreatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		if imageID != "" {
-			thumbnailUrl, err := s3Storage.CreatePresignedGetURL(ctx, imageS3Key)
-			if err != nil {
-				continue
-			}
-			listing.Images = []ListingImage{
-				{
-					ID:          imageID,
-					ListingID:   listing.ID,
-					S3Key:       thumbnailUrl,
-					AltText:     imageAltText,
-					SortOrder:   0,
-					IsThumbnail: true,
-					CreatedAt:   imageCreatedAt,
-				},
-			}
-		}
+			l.updated_at`
 
-		out = append(out, listing)
+func listingScanDest(listing *Listing) []any {
+	return []any{
+		&listing.ID,
+		&listing.UserID,
+		&listing.Title,
+		&listing.Description,
+		&listing.AddressLine1,
+		&listing.AddressLine2,
+		&listing.City,
+		&listing.Province,
+		&listing.Country,
+		&listing.PostalCode,
+		&listing.Latitude,
+		&listing.Longitude,
+		&listing.Bedrooms,
+		&listing.Bathrooms,
+		&listing.Area,
+		&listing.AreaUnit,
+		&listing.Price,
+		&listing.Currency,
+		&listing.AvailableFrom,
+		&listing.AvailableUntil,
+		&listing.MinLeaseDays,
+		&listing.IsFurnished,
+		&listing.PetsAllowed,
+		&listing.SmokingAllowed,
+		&listing.ParkingAvailable,
+		&listing.Status,
+		&listing.CreatedAt,
+		&listing.UpdatedAt,
 	}
-	return out, nil
 }
 
 func (repo Repo) UserOwnsListing(ctx context.Context, UserID string, listingID string) (bool, error) {
@@ -349,3 +303,199 @@ func (repo Repo) UserOwnsListing(ctx context.Context, UserID string, listingID s
 
 	return exists, err
 }
+
+func (repo Repo) GetByID(ctx context.Context, listingID string) (Listing, error) {
+	var listing Listing
+
+	err := repo.DB.QueryRowContext(ctx, `
+		SELECT `+listingColumns+`
+		FROM listings l
+		WHERE l.id = $1::uuid
+		`, listingID).Scan(listingScanDest(&listing)...)
+	if err != nil {
+		return Listing{}, mapListingError(err)
+	}
+
+	return listing, nil
+}
+
+// UpdateParams holds the fields of a partial update, nil fields are left untouched
+// For nullable text columns an empty string clears the value
+type UpdateParams struct {
+	Title            *string
+	Description      *string
+	AddressLine1     *string
+	AddressLine2     *string
+	City             *string
+	Province         *string
+	Country          *string
+	PostalCode       *string
+	Latitude         *float64
+	Longitude        *float64
+	Bedrooms         *int
+	Bathrooms        *float64
+	Area             *float64
+	AreaUnit         *string
+	Price            *float64
+	Currency         *string
+	AvailableFrom    *time.Time
+	AvailableUntil   *time.Time
+	MinLeaseDays     *int
+	IsFurnished      *bool
+	PetsAllowed      *bool
+	SmokingAllowed   *bool
+	ParkingAvailable *bool
+}
+
+func (repo Repo) Update(ctx context.Context, listingID string, params UpdateParams) (Listing, error) {
+	return updateListing(ctx, repo.DB, listingID, params)
+}
+
+func (repo Repo) UpdateTx(ctx context.Context, tx *sql.Tx, listingID string, params UpdateParams) (Listing, error) {
+	return updateListing(ctx, tx, listingID, params)
+}
+
+func updateListing(ctx context.Context, db queryRower, listingID string, params UpdateParams) (Listing, error) {
+	var q searchQuery
+	var assignments []string
+
+	set := func(column string, value any) {
+		assignments = append(assignments, column+" = "+q.arg(value))
+	}
+	setNullable := func(column string, value *string) {
+		assignments = append(assignments, column+" = NULLIF("+q.arg(*value)+", '')")
+	}
+
+	if params.Title != nil {
+		set("title", *params.Title)
+	}
+	if params.Description != nil {
+		setNullable("description", params.Description)
+	}
+	if params.AddressLine1 != nil {
+		set("address_line1", *params.AddressLine1)
+	}
+	if params.AddressLine2 != nil {
+		setNullable("address_line2", params.AddressLine2)
+	}
+	if params.City != nil {
+		set("city", *params.City)
+	}
+	if params.Province != nil {
+		set("province", *params.Province)
+	}
+	if params.Country != nil {
+		set("country", *params.Country)
+	}
+	if params.PostalCode != nil {
+		setNullable("postal_code", params.PostalCode)
+	}
+	if params.Latitude != nil {
+		set("latitude", *params.Latitude)
+	}
+	if params.Longitude != nil {
+		set("longitude", *params.Longitude)
+	}
+	if params.Bedrooms != nil {
+		set("bedrooms", *params.Bedrooms)
+	}
+	if params.Bathrooms != nil {
+		set("bathrooms", *params.Bathrooms)
+	}
+	if params.Area != nil {
+		set("area", *params.Area)
+	}
+	if params.AreaUnit != nil {
+		set("area_unit", *params.AreaUnit)
+	}
+	if params.Price != nil {
+		set("price", *params.Price)
+	}
+	if params.Currency != nil {
+		set("currency", *params.Currency)
+	}
+	if params.AvailableFrom != nil {
+		set("available_from", *params.AvailableFrom)
+	}
+	if params.AvailableUntil != nil {
+		set("available_until", *params.AvailableUntil)
+	}
+	if params.MinLeaseDays != nil {
+		set("min_lease_days", *params.MinLeaseDays)
+	}
+	if params.IsFurnished != nil {
+		set("is_furnished", *params.IsFurnished)
+	}
+	if params.PetsAllowed != nil {
+		set("pets_allowed", *params.PetsAllowed)
+	}
+	if params.SmokingAllowed != nil {
+		set("smoking_allowed", *params.SmokingAllowed)
+	}
+	if params.ParkingAvailable != nil {
+		set("parking_available", *params.ParkingAvailable)
+	}
+
+	assignments = append(assignments, "updated_at = now()")
+
+	var listing Listing
+	err := db.QueryRowContext(ctx, `
+		UPDATE listings l
+		SET `+strings.Join(assignments, ", ")+`
+		WHERE l.id = `+q.arg(listingID)+`::uuid
+		RETURNING `+listingColumns,
+		q.args...,
+	).Scan(listingScanDest(&listing)...)
+	if err != nil {
+		return Listing{}, mapListingError(err)
+	}
+
+	return listing, nil
+}
+
+func (repo Repo) Delete(ctx context.Context, listingID string) error {
+	result, err := repo.DB.ExecContext(ctx, `DELETE FROM listings WHERE id = $1::uuid`, listingID)
+	if err != nil {
+		return mapListingError(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrListingNotFound
+	}
+
+	return nil
+}
+
+func mapListingError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrListingNotFound
+	}
+
+	var pgErr *pq.Error
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "22P02":
+			return ErrListingNotFound
+		case "23514":
+			return ErrInvalidListing
+		}
+	}
+
+	return err
+}
+
+func scanListings(rows *sql.Rows) ([]Listing, error) {
+	out := make([]Listing, 0)
+	for rows.Next() {
+		var listing Listing
+		if err := rows.Scan(listingScanDest(&listing)...); err != nil {
+			return nil, err
+		}
+		out = append(out, listing)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,65 @@
+package listings
+
+import (
+	"context"
+	"time"
+)
+
+type SavedListing struct {
+	Listing
+	SavedAt time.Time `json:"savedAt"`
+}
+
+// ListSavedByUser returns the user's saved listings that are active or rented, most recently saved first
+// the user's own listings are returned whatever their status
+// if beforeListingID is provided it returns listings saved before that one
+func (repo Repo) ListSavedByUser(ctx context.Context, userID string, beforeListingID string, limit int) ([]SavedListing, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT
+			`+listingColumns+`,
+			li.id::text,
+			`+thumbnailKeyColumn+`,
+			li.alt_text,
+			li.created_at,
+			sl.created_at
+		FROM saved_listings sl
+		JOIN listings l ON l.id = sl.listing_id
+		LEFT JOIN listing_images li
+			ON li.listing_id = l.id
+			AND li.is_thumbnail = true
+		WHERE sl.user_id = $1::uuid
+			AND (l.status IN ('active', 'rented') OR l.user_id = $1::uuid)
+			AND (
+				$2 = ''
+				OR (sl.created_at, sl.listing_id) < (
+					SELECT c.created_at, c.listing_id
+					FROM saved_listings c
+					WHERE c.user_id = $1::uuid AND c.listing_id = NULLIF($2, '')::uuid
+				)
+			)
+		ORDER BY sl.created_at DESC, sl.listing_id DESC
+		LIMIT $3
+		`, userID, beforeListingID, limit)
+	if err != nil {
+		return nil, mapListingError(err)
+	}
+	defer rows.Close()
+
+	out := make([]SavedListing, 0)
+	for rows.Next() {
+		var item SavedListing
+		listing, err := scanListingWithThumbnail(rows, &item.SavedAt)
+		if err != nil {
+			return nil, err
+		}
+		item.Listing = listing
+		saved := true
+		item.IsSaved = &saved
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,434 @@
+package listings
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	SortNewest    = "newest"
+	SortPriceAsc  = "price_asc"
+	SortPriceDesc = "price_desc"
+	// only valid with a text query, it is the default sort when Query is set
+	SortRelevance = "relevance"
+)
+
+// trigram similarity a listing needs to be returned by the typo tolerant fallback, it is set as the
+// pg_trgm thresholds so the % and <% operators can use the trigram indexes
+const fuzzyMinSimilarity = 0.3
+
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=25, MinWords=8"
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrInvalidSort = errors.New("invalid sort option")
+
+type SearchParams struct {
+	// free text matched against the listing search_vector (title, description, location and amenities)
+	Query            string
+	UserID           string
+	City             string
+	Province         string
+	PriceMin         *float64
+	PriceMax         *float64
+	BedroomsMin      *int
+	BathroomsMin     *float64
+	IsFurnished      *bool
+	PetsAllowed      *bool
+	SmokingAllowed   *bool
+	ParkingAvailable *bool
+	AvailableFrom    *time.Time
+	AvailableTo      *time.Time
+	// listings must have every one of these amenity keys
+	Amenities []string
+	Status    string
+	Sort      string
+	Cursor    string
+	Limit     int
+}
+
+type SearchResult struct {
+	Listings   []Listing `json:"listings"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+	// set when the text query matched nothing as typed and the results come from trigram similarity
+	Fuzzy bool `json:"fuzzy,omitempty"`
+}
+
+// SearchHighlight holds HTML fragments where matched terms are wrapped in <mark>, everything else is escaped
+type SearchHighlight struct {
+	Title   string `json:"title"`
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// searchCursor is the keyset position of the last row of a page, it is sent to clients base64 encoded
+type searchCursor struct {
+	Sort      string    `json:"s"`
+	Price     float64   `json:"p,omitempty"`
+	CreatedAt time.Time `json:"c"`
+	ID        string    `json:"i"`
+	Rank      float64   `json:"r,omitempty"`
+	Fuzzy     bool      `json:"f,omitempty"`
+}
+
+func encodeCursor(sort string, listing Listing, rank float64, fuzzy bool) string {
+	raw, _ := json.Marshal(searchCursor{
+		Sort:      sort,
+		Price:     listing.Price,
+		CreatedAt: listing.CreatedAt,
+		ID:        listing.ID,
+		Rank:      rank,
+		Fuzzy:     fuzzy,
+	})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(sort string, value string) (searchCursor, error) {
+	var cursor searchCursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	// a cursor is only valid for the ordering it was produced with
+	if cursor.Sort != sort || cursor.ID == "" {
+		return cursor, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+// searchQuery accumulates WHERE conditions and their positional arguments
+type searchQuery struct {
+	conditions []string
+	args       []any
+}
+
+func (q *searchQuery) arg(value any) string {
+	q.args = append(q.args, value)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+func (q *searchQuery) where(format string, values ...any) {
+	placeholders := make([]any, len(values))
+	for i, value := range values {
+		placeholders[i] = q.arg(value)
+	}
+	q.conditions = append(q.conditions, fmt.Sprintf(format, placeholders...))
+}
+
+func (q *searchQuery) whereClause() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(q.conditions, " AND ")
+}
+
+// filter adds the attribute filters shared by list and map queries
+func (q *searchQuery) filter(params SearchParams) {
+	if params.Query != "" {
+		q.where("l.search_vector @@ websearch_to_tsquery('english', %s)", params.Query)
+	}
+	if params.UserID != "" {
+		q.where("l.user_id = %s::uuid", params.UserID)
+	}
+	if params.City != "" {
+		q.where("l.city = %s", params.City)
+	}
+	if params.Province != "" {
+		q.where("l.province = %s", params.Province)
+	}
+	if params.PriceMin != nil {
+		q.where("l.price >= %s::numeric", *params.PriceMin)
+	}
+	if params.PriceMax != nil {
+		q.where("l.price <= %s::numeric", *params.PriceMax)
+	}
+	if params.BedroomsMin != nil {
+		q.where("l.bedrooms >= %s", *params.BedroomsMin)
+	}
+	if params.BathroomsMin != nil {
+		q.where("l.bathrooms >= %s", *params.BathroomsMin)
+	}
+	if params.IsFurnished != nil {
+		q.where("l.is_furnished = %s", *params.IsFurnished)
+	}
+	if params.PetsAllowed != nil {
+		q.where("l.pets_allowed = %s", *params.PetsAllowed)
+	}
+	if params.SmokingAllowed != nil {
+		q.where("l.smoking_allowed = %s", *params.SmokingAllowed)
+	}
+	if params.ParkingAvailable != nil {
+		q.where("l.parking_available = %s", *params.ParkingAvailable)
+	}
+	if params.AvailableFrom != nil {
+		q.where("l.available_from >= %s", *params.AvailableFrom)
+	}
+	if params.AvailableTo != nil {
+		q.where("l.available_from <= %s", *params.AvailableTo)
+	}
+	if params.Status != "" {
+		q.where("l.status = %s", params.Status)
+	}
+	if len(params.Amenities) > 0 {
+		q.where(`l.id IN (
+			SELECT la.listing_id
+			FROM listing_amenities la
+			JOIN amenities a ON a.id = la.amenity_id
+			WHERE a.key = ANY(%s::text[])
+			GROUP BY la.listing_id
+			HAVING count(*) = %s
+		)`, pq.Array(params.Amenities), len(params.Amenities))
+	}
+}
+
+// Search returns one page of listings matching the filters, ordered by params.Sort
+// Pagination is keyset based: NextCursor is empty when there are no more rows
+// A text query is ranked by relevance by default and falls back to trigram similarity when nothing matches as typed
+func (repo Repo) Search(ctx context.Context, params SearchParams) (SearchResult, error) {
+	params.Query = strings.TrimSpace(params.Query)
+	if params.Sort == "" {
+		params.Sort = SortNewest
+		if params.Query != "" {
+			params.Sort = SortRelevance
+		}
+	}
+	if params.Sort == SortRelevance && params.Query == "" {
+		return SearchResult{}, ErrInvalidSort
+	}
+	if params.Limit <= 0 || params.Limit > 100 {
+		params.Limit = 20
+	}
+
+	var cursor *searchCursor
+	if params.Cursor != "" {
+		decoded, err := decodeCursor(params.Sort, params.Cursor)
+		if err != nil {
+			return SearchResult{}, err
+		}
+		cursor = &decoded
+	}
+
+	fuzzy := cursor != nil && cursor.Fuzzy
+	result, err := repo.search(ctx, params, cursor, fuzzy)
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	if !fuzzy && cursor == nil && params.Sort == SortRelevance && len(result.Listings) == 0 {
+		return repo.search(ctx, params, nil, true)
+	}
+	return result, nil
+}
+
+func (repo Repo) search(ctx context.Context, params SearchParams, cursor *searchCursor, fuzzy bool) (SearchResult, error) {
+	var q searchQuery
+
+	filters := params
+	if fuzzy {
+		filters.Query = ""
+	}
+	q.filter(filters)
+
+	rank := "NULL::float8"
+	titleHeadline := "NULL::text"
+	snippetHeadline := "NULL::text"
+	if params.Query != "" {
+		queryArg := q.arg(params.Query)
+		if fuzzy {
+			// the operators filter through the indexes, the similarity functions only rank
+			rank = fmt.Sprintf("greatest(word_similarity(%[1]s, l.title), similarity(l.city, %[1]s), word_similarity(%[1]s, l.address_line1))::float8", queryArg)
+			q.conditions = append(q.conditions, fmt.Sprintf("(%[1]s <%% l.title OR l.city %% %[1]s OR %[1]s <%% l.address_line1)", queryArg))
+		} else {
+			tsquery := "websearch_to_tsquery('english', " + queryArg + ")"
+			rank = "ts_rank(l.search_vector, " + tsquery + ")::float8"
+			titleHeadline = "ts_headline('english', l.title, " + tsquery + ", '" + headlineOptions + ", HighlightAll=true')"
+			snippetHeadline = "ts_headline('english', coalesce(l.description, ''), " + tsquery + ", '" + headlineOptions + "')"
+		}
+	}
+
+	var orderBy string
+	switch params.Sort {
+	case SortNewest:
+		orderBy = "l.created_at DESC, l.id DESC"
+	case SortPriceAsc:
+		orderBy = "l.price ASC, l.id ASC"
+	case SortPriceDesc:
+		orderBy = "l.price DESC, l.id DESC"
+	case SortRelevance:
+		orderBy = "search_rank DESC, l.id DESC"
+	default:
+		return SearchResult{}, ErrInvalidSort
+	}
+
+	if cursor != nil {
+		switch params.Sort {
+		case SortNewest:
+			q.where("(l.created_at, l.id) < (%s, %s::uuid)", cursor.CreatedAt, cursor.ID)
+		case SortPriceAsc:
+			q.where("(l.price, l.id) > (%s::numeric, %s::uuid)", cursor.Price, cursor.ID)
+		case SortPriceDesc:
+			q.where("(l.price, l.id) < (%s::numeric, %s::uuid)", cursor.Price, cursor.ID)
+		case SortRelevance:
+			q.where("("+rank+", l.id) < (%s::float8, %s::uuid)", cursor.Rank, cursor.ID)
+		}
+	}
+
+	// fetch one extra row to know whether another page exists
+	limitPlaceholder := q.arg(params.Limit + 1)
+
+	var db queryer = repo.DB
+	if fuzzy {
+		tx, err := repo.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			return SearchResult{}, err
+		}
+		defer tx.Rollback()
+
+		// the thresholds only last until the transaction ends
+		threshold := strconv.FormatFloat(fuzzyMinSimilarity, 'f', -1, 64)
+		if _, err := tx.ExecContext(ctx, `
+			SELECT set_config('pg_trgm.similarity_threshold', $1, true),
+				set_config('pg_trgm.word_similarity_threshold', $1, true)
+			`, threshold); err != nil {
+			return SearchResult{}, err
+		}
+		db = tx
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT
+			`+listingColumns+`,
+			li.id::text,
+			`+thumbnailKeyColumn+`,
+			li.alt_text,
+			li.created_at,
+			`+rank+` AS search_rank,
+			`+titleHeadline+`,
+			`+snippetHeadline+`
+		FROM listings l
+		LEFT JOIN listing_images li
+			ON li.listing_id = l.id
+			AND li.is_thumbnail = true
+		`+q.whereClause()+`
+		ORDER BY `+orderBy+`
+		LIMIT `+limitPlaceholder,
+		q.args...,
+	)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	defer rows.Close()
+
+	result := SearchResult{
+		Listings: make([]Listing, 0, params.Limit),
+		Fuzzy:    fuzzy,
+	}
+	ranks := make([]float64, 0, params.Limit)
+
+	for rows.Next() {
+		var rowRank sql.NullFloat64
+		var titleHighlight, snippetHighlight sql.NullString
+
+		listing, err := scanListingWithThumbnail(rows, &rowRank, &titleHighlight, &snippetHighlight)
+		if err != nil {
+			return SearchResult{}, err
+		}
+		if titleHighlight.Valid {
+			listing.Highlight = &SearchHighlight{
+				Title:   sanitizeHighlight(titleHighlight.String),
+				Snippet: sanitizeHighlight(snippetHighlight.String),
+			}
+		}
+		result.Listings = append(result.Listings, listing)
+		ranks = append(ranks, rowRank.Float64)
+	}
+	if err := rows.Err(); err != nil {
+		return SearchResult{}, err
+	}
+
+	if len(result.Listings) > params.Limit {
+		result.Listings = result.Listings[:params.Limit]
+		last := len(result.Listings) - 1
+		result.NextCursor = encodeCursor(params.Sort, result.Listings[last], ranks[last], fuzzy)
+	}
+
+	return result, nil
+}
+
+// sanitizeHighlight escapes a ts_headline fragment while keeping the <mark> tags it added,
+// listing text is user input and must not reach the client as HTML
+func sanitizeHighlight(fragment string) string {
+	var b strings.Builder
+	for i, part := range strings.Split(fragment, "<mark>") {
+		if i > 0 {
+			b.WriteString("<mark>")
+		}
+		for j, piece := range strings.Split(part, "</mark>") {
+			if j > 0 {
+				b.WriteString("</mark>")
+			}
+			b.WriteString(html.EscapeString(piece))
+		}
+	}
+	return b.String()
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// thumbnailKeyColumn selects the card sized variant of the thumbnail, images stored before
+// variants existed only have their original key
+const thumbnailKeyColumn = `coalesce((
+	SELECT v.s3_key
+	FROM listing_image_variants v
+	WHERE v.image_id = li.id AND v.variant = 'card'
+), li.s3_key)`
+
+// scanListingWithThumbnail scans listingColumns followed by the optional thumbnail image columns and any extra columns
+func scanListingWithThumbnail(row rowScanner, extra ...any) (Listing, error) {
+	var listing Listing
+
+	var imageID, imageS3Key sql.NullString
+	var imageAltText *string
+	var imageCreatedAt sql.NullTime
+
+	dest := append(listingScanDest(&listing), &imageID, &imageS3Key, &imageAltText, &imageCreatedAt)
+	dest = append(dest, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return Listing{}, err
+	}
+
+	if imageID.Valid {
+		listing.Images = []ListingImage{
+			{
+				ID:          imageID.String,
+				ListingID:   listing.ID,
+				S3Key:       imageS3Key.String,
+				AltText:     imageAltText,
+				SortOrder:   0,
+				IsThumbnail: true,
+				CreatedAt:   imageCreatedAt.Time,
+			},
+		}
+	}
+
+	return listing, nil
+}
@@ -0,0 +1,159 @@
+package listings
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	StatusDraft    = "draft"
+	StatusActive   = "active"
+	StatusRented   = "rented"
+	StatusInactive = "inactive"
+	StatusArchived = "archived"
+)
+
+var ErrInvalidStatus = errors.New("invalid listing status")
+var ErrInvalidTransition = errors.New("listing status change not allowed")
+
+// transitions lists, for each status, the statuses a listing may move to
+// archived is terminal and every other status can be archived
+var transitions = map[string][]string{
+	StatusDraft:    {StatusActive, StatusArchived},
+	StatusActive:   {StatusRented, StatusInactive, StatusArchived},
+	StatusRented:   {StatusActive, StatusInactive, StatusArchived},
+	StatusInactive: {StatusActive, StatusArchived},
+	StatusArchived: {},
+}
+
+type StatusChange struct {
+	ID         string    `json:"id"`
+	ListingID  string    `json:"listingId"`
+	FromStatus *string   `json:"fromStatus,omitempty"`
+	ToStatus   string    `json:"toStatus"`
+	ChangedBy  *string   `json:"changedBy,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func IsValidStatus(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+func CanTransition(from string, to string) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangeStatus moves a listing to a new status if the transition is allowed and records it in the history
+// actorID is the user making the change, empty for system changes
+func (repo Repo) ChangeStatus(ctx context.Context, listingID string, actorID string, to string) (Listing, error) {
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Listing{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	listing, err := repo.ChangeStatusTx(ctx, tx, listingID, actorID, to)
+	if err != nil {
+		return Listing{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Listing{}, err
+	}
+
+	return listing, nil
+}
+
+func (repo Repo) ChangeStatusTx(ctx context.Context, tx *sql.Tx, listingID string, actorID string, to string) (Listing, error) {
+	if !IsValidStatus(to) {
+		return Listing{}, ErrInvalidStatus
+	}
+
+	var from string
+	err := tx.QueryRowContext(ctx, `
+		SELECT status
+		FROM listings
+		WHERE id = $1::uuid
+		FOR UPDATE
+		`, listingID).Scan(&from)
+	if err != nil {
+		return Listing{}, mapListingError(err)
+	}
+
+	if !CanTransition(from, to) {
+		return Listing{}, ErrInvalidTransition
+	}
+
+	var listing Listing
+	err = tx.QueryRowContext(ctx, `
+		UPDATE listings l
+		SET status = $2, updated_at = now()
+		WHERE l.id = $1::uuid
+		RETURNING `+listingColumns,
+		listingID, to,
+	).Scan(listingScanDest(&listing)...)
+	if err != nil {
+		return Listing{}, mapListingError(err)
+	}
+
+	if err := repo.RecordStatusTx(ctx, tx, listingID, &from, to, actorID); err != nil {
+		return Listing{}, err
+	}
+
+	return listing, nil
+}
+
+// RecordStatusTx appends a row to the status history, from is nil for the initial status of a new listing
+func (repo Repo) RecordStatusTx(ctx context.Context, tx *sql.Tx, listingID string, from *string, to string, actorID string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO listing_status_history (listing_id, from_status, to_status, changed_by)
+		VALUES ($1::uuid, $2, $3, NULLIF($4, '')::uuid)
+		`, listingID, from, to, actorID)
+	return err
+}
+
+func (repo Repo) StatusHistory(ctx context.Context, listingID string) ([]StatusChange, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT
+			id::text,
+			listing_id::text,
+			from_status,
+			to_status,
+			changed_by::text,
+			created_at
+		FROM listing_status_history
+		WHERE listing_id = $1::uuid
+		ORDER BY created_at DESC, id DESC
+		`, listingID)
+	if err != nil {
+		return nil, mapListingError(err)
+	}
+	defer rows.Close()
+
+	out := make([]StatusChange, 0)
+	for rows.Next() {
+		var change StatusChange
+		if err := rows.Scan(
+			&change.ID,
+			&change.ListingID,
+			&change.FromStatus,
+			&change.ToStatus,
+			&change.ChangedBy,
+			&change.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, change)
+	}
+	return out, rows.Err()
+}
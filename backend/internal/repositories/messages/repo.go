@@ -6,15 +6,45 @@ import (
 	"errors"
 	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type Message struct {
-	ID         string    `json:"id"`
-	RoomID     string    `json:"roomId"`
-	SenderID   string    `json:"senderId"`
-	SenderName string    `json:"senderName"`
-	Body       string    `json:"body"`
-	CreatedAt  time.Time `json:"createdAt"`
+	ID         string     `json:"id"`
+	RoomID     string     `json:"roomId"`
+	SenderID   string     `json:"senderId"`
+	SenderName string     `json:"senderName"`
+	Body       string     `json:"body"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	EditedAt   *time.Time `json:"editedAt,omitempty"`
+	// deleted messages are served as tombstones, the body is empty
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// set on replies, ReplyTo quotes the parent
+	ReplyToID *string       `json:"replyToId,omitempty"`
+	ReplyTo   *ReplyPreview `json:"replyTo,omitempty"`
+	// replies ever posted to the message, it only grows: deleted replies stay counted, including the ones
+	// removed with their sender's account, so clients should treat it as a hint rather than an exact total
+	ReplyCount int `json:"replyCount"`
+}
+
+// ReplyPreview is the quoted parent shown above a reply, Body is cut to 140 characters
+type ReplyPreview struct {
+	ID         string     `json:"id"`
+	SenderID   string     `json:"senderId"`
+	SenderName string     `json:"senderName"`
+	Body       string     `json:"body"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	DeletedAt  *time.Time `json:"deletedAt,omitempty"`
+}
+
+// Edit is a previous version of a message body
+type Edit struct {
+	ID        string    `json:"id"`
+	MessageID string    `json:"messageId"`
+	Body      string    `json:"body"`
+	EditedBy  *string   `json:"editedBy,omitempty"`
+	EditedAt  time.Time `json:"editedAt"`
 }
 
 type Cursor struct {
@@ -25,6 +55,84 @@ type Repo struct {
 	DB *sql.DB
 }
 
+var ErrMessageNotFound = errors.New("message not found")
+var ErrMessageDeleted = errors.New("message was deleted")
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// messageColumns expects messages as m with messageJoins, deleted bodies never leave the database
+const messageColumns = `
+	m.id::text,
+	m.room_id::text,
+	m.sender_id::text,
+	CASE WHEN m.deleted_at IS NULL THEN m.body ELSE '' END,
+	m.created_at,
+	u.name,
+	m.edited_at,
+	m.deleted_at,
+	m.reply_to_id::text,
+	m.reply_count,
+	p.id::text,
+	p.sender_id::text,
+	pu.name,
+	CASE WHEN p.deleted_at IS NULL THEN left(p.body, 140) ELSE '' END,
+	p.created_at,
+	p.deleted_at
+`
+
+const messageJoins = `
+	JOIN users u ON u.id = m.sender_id
+	LEFT JOIN messages p ON p.id = m.reply_to_id
+	LEFT JOIN users pu ON pu.id = p.sender_id
+`
+
+// quotedParent holds the parent columns of messageColumns, they are all null for messages that are not replies
+type quotedParent struct {
+	id         *string
+	senderID   *string
+	senderName *string
+	body       *string
+	createdAt  *time.Time
+	deletedAt  *time.Time
+}
+
+func scanDest(message *Message, parent *quotedParent) []any {
+	return []any{
+		&message.ID,
+		&message.RoomID,
+		&message.SenderID,
+		&message.Body,
+		&message.CreatedAt,
+		&message.SenderName,
+		&message.EditedAt,
+		&message.DeletedAt,
+		&message.ReplyToID,
+		&message.ReplyCount,
+		&parent.id,
+		&parent.senderID,
+		&parent.senderName,
+		&parent.body,
+		&parent.createdAt,
+		&parent.deletedAt,
+	}
+}
+
+func (parent quotedParent) preview() *ReplyPreview {
+	if parent.id == nil {
+		return nil
+	}
+	return &ReplyPreview{
+		ID:         *parent.id,
+		SenderID:   *parent.senderID,
+		SenderName: *parent.senderName,
+		Body:       *parent.body,
+		CreatedAt:  *parent.createdAt,
+		DeletedAt:  parent.deletedAt,
+	}
+}
+
 func (repo Repo) Insert(ctx context.Context, roomID, senderID, body string) (Message, error) {
 	body = strings.TrimSpace(body)
 	if body == "" {
@@ -71,9 +179,10 @@ func (repo Repo) ListLatest(ctx context.Context, roomID string, beforeID string,
 		    FROM messages
 		    WHERE id = $2::uuid AND room_id = $1::uuid
 		)
-		SELECT m.id::text, m.room_id::text, m.sender_id::text, m.body, m.created_at, u.name
+		SELECT `+messageColumns+`
 		FROM messages m
-		JOIN users u ON u.id = m.sender_id, cursor c
+		`+messageJoins+`
+		CROSS JOIN cursor c
 		WHERE m.room_id = $1::uuid
 			AND (m.created_at < c.created_at OR (m.created_at = c.created_at AND m.id < c.id))
 		ORDER BY m.created_at DESC, m.id DESC
@@ -87,9 +196,11 @@ func (repo Repo) ListLatest(ctx context.Context, roomID string, beforeID string,
 	var receivedRows []Message
 	for rows.Next() {
 		var message Message
-		if err := rows.Scan(&message.ID, &message.RoomID, &message.SenderID, &message.Body, &message.CreatedAt, &message.SenderName); err != nil {
+		var parent quotedParent
+		if err := rows.Scan(scanDest(&message, &parent)...); err != nil {
 			return nil, err
 		}
+		message.ReplyTo = parent.preview()
 		receivedRows = append(receivedRows, message)
 	}
 	return receivedRows, rows.Err()
@@ -97,11 +208,11 @@ func (repo Repo) ListLatest(ctx context.Context, roomID string, beforeID string,
 
 func (repo Repo) listLatestNoCursor(ctx context.Context, roomID string, limit int) ([]Message, error) {
 	rows, err := repo.DB.QueryContext(ctx, `
-		SELECT m.id::text, m.room_id::text, m.sender_id::text, m.body, m.created_at, u.name
+		SELECT `+messageColumns+`
 		FROM messages m
-		JOIN users u ON u.id = m.sender_id
-		WHERE room_id = $1::uuid
-		ORDER BY created_at DESC, id DESC
+		`+messageJoins+`
+		WHERE m.room_id = $1::uuid
+		ORDER BY m.created_at DESC, m.id DESC
 		LIMIT $2
 	`, roomID, limit)
 	if err != nil {
@@ -112,10 +223,302 @@ func (repo Repo) listLatestNoCursor(ctx context.Context, roomID string, limit in
 	var receivedRows []Message
 	for rows.Next() {
 		var message Message
-		if err := rows.Scan(&message.ID, &message.RoomID, &message.SenderID, &message.Body, &message.CreatedAt, &message.SenderName); err != nil {
+		var parent quotedParent
+		if err := rows.Scan(scanDest(&message, &parent)...); err != nil {
+			return nil, err
+		}
+		message.ReplyTo = parent.preview()
+		receivedRows = append(receivedRows, message)
+	}
+	return receivedRows, rows.Err()
+}
+
+// InsertReply stores a reply to a message of the same room and returns it with the parent's new reply count.
+// Deleted messages take no new replies. The count is append only, nothing decrements it
+func (repo Repo) InsertReply(ctx context.Context, roomID, senderID, body, replyToID string) (Message, int, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return Message{}, 0, errors.New("body required")
+	}
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Message{}, 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var replyCount int
+	err = tx.QueryRowContext(ctx, `
+		UPDATE messages
+		SET reply_count = reply_count + 1
+		WHERE id = $1::uuid AND room_id = $2::uuid AND deleted_at IS NULL
+		RETURNING reply_count
+	`, replyToID, roomID).Scan(&replyCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		// nothing updated: the parent is unknown, in another room or a tombstone
+		var deletedAt *time.Time
+		err = tx.QueryRowContext(ctx, `
+			SELECT deleted_at
+			FROM messages
+			WHERE id = $1::uuid AND room_id = $2::uuid
+		`, replyToID, roomID).Scan(&deletedAt)
+		if err != nil {
+			return Message{}, 0, mapMessageError(err)
+		}
+		return Message{}, 0, ErrMessageDeleted
+	}
+	if err != nil {
+		return Message{}, 0, mapMessageError(err)
+	}
+
+	var messageID string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO messages (room_id, sender_id, body, reply_to_id)
+		VALUES ($1::uuid, $2::uuid, $3, $4::uuid)
+		RETURNING id::text
+	`, roomID, senderID, body, replyToID).Scan(&messageID)
+	if err != nil {
+		return Message{}, 0, err
+	}
+
+	message, err := getMessage(ctx, tx, messageID)
+	if err != nil {
+		return Message{}, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Message{}, 0, err
+	}
+	return message, replyCount, nil
+}
+
+// ListReplies returns newest-first replies to a message, paged like ListLatest
+func (repo Repo) ListReplies(ctx context.Context, parentID string, beforeID string, limit int) ([]Message, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	if strings.TrimSpace(beforeID) == "" {
+		return repo.listRepliesNoCursor(ctx, parentID, limit)
+	}
+
+	rows, err := repo.DB.QueryContext(ctx, `
+		WITH cursor AS (
+		    SELECT created_at, id
+		    FROM messages
+		    WHERE id = $2::uuid AND reply_to_id = $1::uuid
+		)
+		SELECT `+messageColumns+`
+		FROM messages m
+		`+messageJoins+`
+		CROSS JOIN cursor c
+		WHERE m.reply_to_id = $1::uuid
+			AND (m.created_at < c.created_at OR (m.created_at = c.created_at AND m.id < c.id))
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT $3
+	`, parentID, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var receivedRows []Message
+	for rows.Next() {
+		var message Message
+		var parent quotedParent
+		if err := rows.Scan(scanDest(&message, &parent)...); err != nil {
+			return nil, err
+		}
+		message.ReplyTo = parent.preview()
+		receivedRows = append(receivedRows, message)
+	}
+	return receivedRows, rows.Err()
+}
+
+func (repo Repo) listRepliesNoCursor(ctx context.Context, parentID string, limit int) ([]Message, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT `+messageColumns+`
+		FROM messages m
+		`+messageJoins+`
+		WHERE m.reply_to_id = $1::uuid
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT $2
+	`, parentID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var receivedRows []Message
+	for rows.Next() {
+		var message Message
+		var parent quotedParent
+		if err := rows.Scan(scanDest(&message, &parent)...); err != nil {
 			return nil, err
 		}
+		message.ReplyTo = parent.preview()
 		receivedRows = append(receivedRows, message)
 	}
 	return receivedRows, rows.Err()
 }
+
+func getMessage(ctx context.Context, db queryRower, messageID string) (Message, error) {
+	var message Message
+	var parent quotedParent
+	err := db.QueryRowContext(ctx, `
+		SELECT `+messageColumns+`
+		FROM messages m
+		`+messageJoins+`
+		WHERE m.id = $1::uuid
+	`, messageID).Scan(scanDest(&message, &parent)...)
+	if err != nil {
+		return Message{}, mapMessageError(err)
+	}
+	message.ReplyTo = parent.preview()
+	return message, nil
+}
+
+func (repo Repo) Get(ctx context.Context, messageID string) (Message, error) {
+	return getMessage(ctx, repo.DB, messageID)
+}
+
+// Edit replaces the body and keeps the previous one in message_edits, changed is false when the
+// body is the same and nothing was written
+func (repo Repo) Edit(ctx context.Context, messageID string, editorID string, body string) (Message, bool, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return Message{}, false, errors.New("body required")
+	}
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Message{}, false, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var current string
+	var deletedAt *time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT body, deleted_at
+		FROM messages
+		WHERE id = $1::uuid
+		FOR UPDATE
+	`, messageID).Scan(&current, &deletedAt)
+	if err != nil {
+		return Message{}, false, mapMessageError(err)
+	}
+	if deletedAt != nil {
+		return Message{}, false, ErrMessageDeleted
+	}
+
+	changed := current != body
+	if changed {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO message_edits (message_id, body, edited_by)
+			VALUES ($1::uuid, $2, $3::uuid)
+		`, messageID, current, editorID); err != nil {
+			return Message{}, false, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE messages
+			SET body = $2, edited_at = now()
+			WHERE id = $1::uuid
+		`, messageID, body); err != nil {
+			return Message{}, false, err
+		}
+	}
+
+	message, err := getMessage(ctx, tx, messageID)
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Message{}, false, err
+	}
+	return message, changed, nil
+}
+
+// ListEdits returns the previous bodies of a message, newest first
+func (repo Repo) ListEdits(ctx context.Context, messageID string) ([]Edit, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT id::text, message_id::text, body, edited_by::text, edited_at
+		FROM message_edits
+		WHERE message_id = $1::uuid
+		ORDER BY edited_at DESC, id DESC
+	`, messageID)
+	if err != nil {
+		return nil, mapMessageError(err)
+	}
+	defer rows.Close()
+
+	var out []Edit
+	for rows.Next() {
+		var edit Edit
+		if err := rows.Scan(&edit.ID, &edit.MessageID, &edit.Body, &edit.EditedBy, &edit.EditedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, edit)
+	}
+	return out, rows.Err()
+}
+
+// softDelete turns a message into a tombstone, the body is kept for moderation
+func softDelete(ctx context.Context, db queryRower, messageID string, deletedBy string) (Message, error) {
+	var message Message
+	var parent quotedParent
+	err := db.QueryRowContext(ctx, `
+		WITH deleted AS (
+			UPDATE messages
+			SET deleted_at = now(), deleted_by = $2::uuid
+			WHERE id = $1::uuid AND deleted_at IS NULL
+			RETURNING *
+		)
+		SELECT `+messageColumns+`
+		FROM deleted m
+		`+messageJoins+`
+	`, messageID, deletedBy).Scan(scanDest(&message, &parent)...)
+	if err == nil {
+		message.ReplyTo = parent.preview()
+		return message, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Message{}, mapMessageError(err)
+	}
+
+	// nothing updated: the message is unknown or already a tombstone
+	var exists bool
+	err = db.QueryRowContext(ctx, `
+		SELECT true
+		FROM messages
+		WHERE id = $1::uuid
+	`, messageID).Scan(&exists)
+	if err != nil {
+		return Message{}, mapMessageError(err)
+	}
+	return Message{}, ErrMessageDeleted
+}
+
+func (repo Repo) SoftDelete(ctx context.Context, messageID string, deletedBy string) (Message, error) {
+	return softDelete(ctx, repo.DB, messageID, deletedBy)
+}
+
+func (repo Repo) SoftDeleteTx(ctx context.Context, tx *sql.Tx, messageID string, deletedBy string) (Message, error) {
+	return softDelete(ctx, tx, messageID, deletedBy)
+}
+
+func mapMessageError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMessageNotFound
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "22P02" {
+		return ErrMessageNotFound
+	}
+	return err
+}
@@ -0,0 +1,157 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Notification struct {
+	ID        string          `json:"id"`
+	UserID    string          `json:"userId"`
+	Type      string          `json:"type"`
+	Title     string          `json:"title"`
+	Body      *string         `json:"body,omitempty"`
+	Data      json.RawMessage `json:"data"`
+	ReadAt    *time.Time      `json:"readAt,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+type InsertParams struct {
+	UserID string
+	Type   string
+	Title  string
+	Body   *string
+	// marshalled to JSON, usually a map with the ids the client needs to link the notification
+	Data any
+}
+
+type Repo struct {
+	DB *sql.DB
+}
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
+func (repo Repo) Insert(ctx context.Context, params InsertParams) (Notification, error) {
+	data := []byte("{}")
+	if params.Data != nil {
+		encoded, err := json.Marshal(params.Data)
+		if err != nil {
+			return Notification{}, err
+		}
+		data = encoded
+	}
+
+	var notification Notification
+	err := repo.DB.QueryRowContext(ctx, `
+		INSERT INTO notifications (user_id, type, title, body, data)
+		VALUES ($1::uuid, $2, $3, $4, $5::jsonb)
+		RETURNING id::text, user_id::text, type, title, body, data, read_at, created_at
+		`, params.UserID, params.Type, params.Title, params.Body, string(data)).Scan(
+		&notification.ID,
+		&notification.UserID,
+		&notification.Type,
+		&notification.Title,
+		&notification.Body,
+		&notification.Data,
+		&notification.ReadAt,
+		&notification.CreatedAt,
+	)
+
+	return notification, err
+}
+
+// ListForUser returns newest-first notifications, if beforeID is provided it returns notifications older than that one
+func (repo Repo) ListForUser(ctx context.Context, userID string, unreadOnly bool, beforeID string, limit int) ([]Notification, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT n.id::text, n.user_id::text, n.type, n.title, n.body, n.data, n.read_at, n.created_at
+		FROM notifications n
+		WHERE n.user_id = $1::uuid
+			AND ($2 = false OR n.read_at IS NULL)
+			AND (
+				$3 = ''
+				OR (n.created_at, n.id) < (
+					SELECT c.created_at, c.id
+					FROM notifications c
+					WHERE c.id = NULLIF($3, '')::uuid AND c.user_id = $1::uuid
+				)
+			)
+		ORDER BY n.created_at DESC, n.id DESC
+		LIMIT $4
+		`, userID, unreadOnly, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Notification, 0)
+	for rows.Next() {
+		var notification Notification
+		if err := rows.Scan(
+			&notification.ID,
+			&notification.UserID,
+			&notification.Type,
+			&notification.Title,
+			&notification.Body,
+			&notification.Data,
+			&notification.ReadAt,
+			&notification.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		out = append(out, notification)
+	}
+	return out, rows.Err()
+}
+
+func (repo Repo) CountUnread(ctx context.Context, userID string) (int, error) {
+	var count int
+	err := repo.DB.QueryRowContext(ctx, `
+		SELECT count(*)
+		FROM notifications
+		WHERE user_id = $1::uuid AND read_at IS NULL
+		`, userID).Scan(&count)
+	return count, err
+}
+
+// MarkRead marks one notification as read, or all of the user's notifications when notificationID is empty
+func (repo Repo) MarkRead(ctx context.Context, userID string, notificationID string) error {
+	if notificationID == "" {
+		_, err := repo.DB.ExecContext(ctx, `
+			UPDATE notifications
+			SET read_at = now()
+			WHERE user_id = $1::uuid AND read_at IS NULL
+			`, userID)
+		return err
+	}
+
+	result, err := repo.DB.ExecContext(ctx, `
+		UPDATE notifications
+		SET read_at = COALESCE(read_at, now())
+		WHERE id = $1::uuid AND user_id = $2::uuid
+		`, notificationID, userID)
+	if err != nil {
+		var pgErr *pq.Error
+		if errors.As(err, &pgErr) && pgErr.Code == "22P02" {
+			return ErrNotificationNotFound
+		}
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
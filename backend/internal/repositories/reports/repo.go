@@ -0,0 +1,262 @@
+package reports
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	TargetListing = "listing"
+	TargetMessage = "message"
+	TargetUser    = "user"
+)
+
+const (
+	ReasonSpam          = "spam"
+	ReasonScam          = "scam"
+	ReasonInappropriate = "inappropriate"
+	ReasonHarassment    = "harassment"
+	ReasonOther         = "other"
+)
+
+const (
+	StatusOpen      = "open"
+	StatusDismissed = "dismissed"
+	StatusActioned  = "actioned"
+)
+
+// actions a moderator can take on a report, every action but dismiss marks it actioned
+const (
+	ActionDismiss       = "dismiss"
+	ActionHideListing   = "hide_listing"
+	ActionDeleteMessage = "delete_message"
+	ActionSuspendUser   = "suspend_user"
+)
+
+type Report struct {
+	ID         string  `json:"id"`
+	ReporterID string  `json:"reporterId"`
+	TargetType string  `json:"targetType"`
+	TargetID   string  `json:"targetId"`
+	Reason     string  `json:"reason"`
+	Details    *string `json:"details,omitempty"`
+	Status     string  `json:"status"`
+	Action     *string `json:"action,omitempty"`
+	// the user responsible for the target: the listing owner, the message sender or the reported user,
+	// nil once the target is deleted
+	SubjectUserID *string `json:"subjectUserId,omitempty"`
+	// listing title, message body or user name, nil once the target is deleted
+	Preview    *string    `json:"preview,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+	ResolvedBy *string    `json:"resolvedBy,omitempty"`
+}
+
+type InsertParams struct {
+	ReporterID string
+	TargetType string
+	TargetID   string
+	Reason     string
+	Details    *string
+}
+
+type Repo struct {
+	DB *sql.DB
+}
+
+var ErrReportNotFound = errors.New("report not found")
+var ErrAlreadyReported = errors.New("you already reported this")
+var ErrAlreadyResolved = errors.New("report is already resolved")
+
+func IsValidTargetType(targetType string) bool {
+	switch targetType {
+	case TargetListing, TargetMessage, TargetUser:
+		return true
+	}
+	return false
+}
+
+func IsValidReason(reason string) bool {
+	switch reason {
+	case ReasonSpam, ReasonScam, ReasonInappropriate, ReasonHarassment, ReasonOther:
+		return true
+	}
+	return false
+}
+
+func IsValidStatus(status string) bool {
+	switch status {
+	case StatusOpen, StatusDismissed, StatusActioned:
+		return true
+	}
+	return false
+}
+
+const reportColumns = `
+	r.id::text,
+	r.reporter_id::text,
+	r.target_type,
+	r.target_id::text,
+	r.reason,
+	r.details,
+	r.status,
+	r.action,
+	CASE r.target_type
+		WHEN 'listing' THEN l.user_id
+		WHEN 'message' THEN m.sender_id
+		ELSE u.id
+	END::text,
+	CASE r.target_type
+		WHEN 'listing' THEN l.title
+		WHEN 'message' THEN m.body
+		ELSE u.name
+	END,
+	r.created_at,
+	r.resolved_at,
+	r.resolved_by::text`
+
+const reportJoins = `
+	LEFT JOIN listings l ON r.target_type = 'listing' AND l.id = r.target_id
+	LEFT JOIN messages m ON r.target_type = 'message' AND m.id = r.target_id
+	LEFT JOIN users u ON r.target_type = 'user' AND u.id = r.target_id`
+
+func scanDest(report *Report) []any {
+	return []any{
+		&report.ID,
+		&report.ReporterID,
+		&report.TargetType,
+		&report.TargetID,
+		&report.Reason,
+		&report.Details,
+		&report.Status,
+		&report.Action,
+		&report.SubjectUserID,
+		&report.Preview,
+		&report.CreatedAt,
+		&report.ResolvedAt,
+		&report.ResolvedBy,
+	}
+}
+
+// Create files a report, the caller checks that the target exists and may be reported by the reporter
+func (repo Repo) Create(ctx context.Context, params InsertParams) (Report, error) {
+	var id string
+	err := repo.DB.QueryRowContext(ctx, `
+		INSERT INTO reports (reporter_id, target_type, target_id, reason, details)
+		VALUES ($1::uuid, $2, $3::uuid, $4, $5)
+		RETURNING id::text
+		`, params.ReporterID, params.TargetType, params.TargetID, params.Reason, params.Details).Scan(&id)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return Report{}, ErrAlreadyReported
+		}
+		return Report{}, mapReportError(err)
+	}
+
+	return repo.Get(ctx, id)
+}
+
+func (repo Repo) Get(ctx context.Context, reportID string) (Report, error) {
+	var report Report
+	err := repo.DB.QueryRowContext(ctx, `
+		SELECT `+reportColumns+`
+		FROM reports r
+		`+reportJoins+`
+		WHERE r.id = $1::uuid
+		`, reportID).Scan(scanDest(&report)...)
+	if err != nil {
+		return Report{}, mapReportError(err)
+	}
+	return report, nil
+}
+
+// List returns reports with the given status, oldest first so the queue is worked in order
+func (repo Repo) List(ctx context.Context, status string, limit int) ([]Report, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT `+reportColumns+`
+		FROM reports r
+		`+reportJoins+`
+		WHERE r.status = $1
+		ORDER BY r.created_at ASC, r.id ASC
+		LIMIT $2
+		`, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Report, 0)
+	for rows.Next() {
+		var report Report
+		if err := rows.Scan(scanDest(&report)...); err != nil {
+			return nil, err
+		}
+		out = append(out, report)
+	}
+	return out, rows.Err()
+}
+
+// LockOpenTx loads an open report and locks it so two moderators can not act on it at once
+func (repo Repo) LockOpenTx(ctx context.Context, tx *sql.Tx, reportID string) (Report, error) {
+	var report Report
+	err := tx.QueryRowContext(ctx, `
+		SELECT `+reportColumns+`
+		FROM reports r
+		`+reportJoins+`
+		WHERE r.id = $1::uuid
+		FOR UPDATE OF r
+		`, reportID).Scan(scanDest(&report)...)
+	if err != nil {
+		return Report{}, mapReportError(err)
+	}
+	if report.Status != StatusOpen {
+		return Report{}, ErrAlreadyResolved
+	}
+	return report, nil
+}
+
+// ResolveTx closes every open report on the same target as report with one decision, so a listing
+// reported by ten users leaves the queue with the first action taken on it
+func (repo Repo) ResolveTx(ctx context.Context, tx *sql.Tx, report Report, action string, resolvedBy string) (int, error) {
+	status := StatusActioned
+	if action == ActionDismiss {
+		status = StatusDismissed
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE reports
+		SET
+			status = $3,
+			action = $4,
+			resolved_at = now(),
+			resolved_by = $5::uuid
+		WHERE target_type = $1 AND target_id = $2::uuid AND status = 'open'
+		`, report.TargetType, report.TargetID, status, action, resolvedBy)
+	if err != nil {
+		return 0, err
+	}
+
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
+
+func mapReportError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrReportNotFound
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "22P02" {
+		return ErrReportNotFound
+	}
+	return err
+}
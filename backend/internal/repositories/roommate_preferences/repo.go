@@ -0,0 +1,341 @@
+package roommate_preferences
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	GenderMale   = "male"
+	GenderFemale = "female"
+	GenderAny    = "any"
+	GenderOther  = "other"
+)
+
+const MinAge = 18
+
+// largest values the columns hold: budgets are numeric(10,2), bathrooms numeric(3,1), the rest integer
+const (
+	MaxBudget    = 99999999.99
+	MaxBathrooms = 99.9
+	MaxInteger   = math.MaxInt32
+)
+
+type Preferences struct {
+	UserID             string     `json:"userId"`
+	PreferredCity      *string    `json:"preferredCity,omitempty"`
+	PreferredProvince  *string    `json:"preferredProvince,omitempty"`
+	BudgetMin          *float64   `json:"budgetMin,omitempty"`
+	BudgetMax          *float64   `json:"budgetMax,omitempty"`
+	MoveInDate         *time.Time `json:"moveInDate,omitempty"`
+	PreferredBedrooms  *int       `json:"preferredBedrooms,omitempty"`
+	PreferredBathrooms *float64   `json:"preferredBathrooms,omitempty"`
+	FurnishedPreferred *bool      `json:"furnishedPreferred,omitempty"`
+	PetsOK             *bool      `json:"petsOk,omitempty"`
+	SmokingOK          *bool      `json:"smokingOk,omitempty"`
+	ParkingNeeded      *bool      `json:"parkingNeeded,omitempty"`
+	GenderPreference   *string    `json:"genderPreference,omitempty"`
+	MinAge             *int       `json:"minAge,omitempty"`
+	MaxAge             *int       `json:"maxAge,omitempty"`
+	Occupation         *string    `json:"occupation,omitempty"`
+	LifestyleNotes     *string    `json:"lifestyleNotes,omitempty"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	UpdatedAt          time.Time  `json:"updatedAt"`
+}
+
+// Params holds every writable column, nil stores NULL
+type Params struct {
+	PreferredCity      *string
+	PreferredProvince  *string
+	BudgetMin          *float64
+	BudgetMax          *float64
+	MoveInDate         *time.Time
+	PreferredBedrooms  *int
+	PreferredBathrooms *float64
+	FurnishedPreferred *bool
+	PetsOK             *bool
+	SmokingOK          *bool
+	ParkingNeeded      *bool
+	GenderPreference   *string
+	MinAge             *int
+	MaxAge             *int
+	Occupation         *string
+	LifestyleNotes     *string
+}
+
+// Candidate is another user's preferences as seen by roommate matching, occupation and lifestyle notes
+// are private to their owner and left empty
+type Candidate struct {
+	Preferences
+	Name string `json:"name"`
+}
+
+type Repo struct {
+	DB *sql.DB
+}
+
+var ErrPreferencesNotFound = errors.New("roommate preferences not found")
+var ErrPreferencesExist = errors.New("roommate preferences already exist")
+
+// FieldError is a CHECK constraint violation translated to the request field it concerns
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (err FieldError) Error() string {
+	return err.Field + ": " + err.Message
+}
+
+// constraintFields maps the table CHECK constraints to request fields
+var constraintFields = map[string]FieldError{
+	"roommate_preferences_budget_min_check":          {"budgetMin", "must be 0 or more"},
+	"roommate_preferences_budget_max_check":          {"budgetMax", "must be 0 or more"},
+	"roommate_preferences_budget_range_check":        {"budgetMax", "must be greater than or equal to budgetMin"},
+	"roommate_preferences_preferred_bedrooms_check":  {"preferredBedrooms", "must be 0 or more"},
+	"roommate_preferences_preferred_bathrooms_check": {"preferredBathrooms", "must be 0 or more"},
+	"roommate_preferences_gender_preference_check":   {"genderPreference", "must be one of male, female, any, other"},
+	"roommate_preferences_min_age_check":             {"minAge", "must be at least 18"},
+	"roommate_preferences_max_age_check":             {"maxAge", "must be at least 18"},
+	"roommate_preferences_age_range_check":           {"maxAge", "must be greater than or equal to minAge"},
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+const preferencesColumns = `
+	rp.user_id::text,
+	rp.preferred_city,
+	rp.preferred_province,
+	rp.budget_min::float8,
+	rp.budget_max::float8,
+	rp.move_in_date,
+	rp.preferred_bedrooms,
+	rp.preferred_bathrooms::float8,
+	rp.furnished_preferred,
+	rp.pets_ok,
+	rp.smoking_ok,
+	rp.parking_needed,
+	rp.gender_preference,
+	rp.min_age,
+	rp.max_age,
+	rp.occupation,
+	rp.lifestyle_notes,
+	rp.created_at,
+	rp.updated_at`
+
+func scanPreferences(row *sql.Row) (Preferences, error) {
+	var preferences Preferences
+	if err := row.Scan(preferencesScanDest(&preferences)...); err != nil {
+		return Preferences{}, mapPreferencesError(err)
+	}
+	return preferences, nil
+}
+
+func preferencesScanDest(preferences *Preferences) []any {
+	return []any{
+		&preferences.UserID,
+		&preferences.PreferredCity,
+		&preferences.PreferredProvince,
+		&preferences.BudgetMin,
+		&preferences.BudgetMax,
+		&preferences.MoveInDate,
+		&preferences.PreferredBedrooms,
+		&preferences.PreferredBathrooms,
+		&preferences.FurnishedPreferred,
+		&preferences.PetsOK,
+		&preferences.SmokingOK,
+		&preferences.ParkingNeeded,
+		&preferences.GenderPreference,
+		&preferences.MinAge,
+		&preferences.MaxAge,
+		&preferences.Occupation,
+		&preferences.LifestyleNotes,
+		&preferences.CreatedAt,
+		&preferences.UpdatedAt,
+	}
+}
+
+func (repo Repo) Get(ctx context.Context, userID string) (Preferences, error) {
+	return scanPreferences(repo.DB.QueryRowContext(ctx, `
+		SELECT `+preferencesColumns+`
+		FROM roommate_preferences rp
+		WHERE rp.user_id = $1::uuid
+		`, userID))
+}
+
+// ListCandidates returns other users' preferences for roommate matching, most recently updated first
+// When province is set only users looking in that province (or anywhere) are returned
+func (repo Repo) ListCandidates(ctx context.Context, userID string, province string, limit int) ([]Candidate, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 200
+	}
+
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT `+preferencesColumns+`, u.name
+		FROM roommate_preferences rp
+		JOIN users u ON u.id = rp.user_id
+		WHERE rp.user_id <> $1::uuid
+			AND ($2 = '' OR rp.preferred_province IS NULL OR lower(rp.preferred_province) = lower($2))
+		ORDER BY rp.updated_at DESC
+		LIMIT $3
+		`, userID, province, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Candidate, 0)
+	for rows.Next() {
+		var candidate Candidate
+		dest := append(preferencesScanDest(&candidate.Preferences), &candidate.Name)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		candidate.Occupation = nil
+		candidate.LifestyleNotes = nil
+		out = append(out, candidate)
+	}
+	return out, rows.Err()
+}
+
+func insertPreferences(ctx context.Context, db queryRower, userID string, params Params) (Preferences, error) {
+	return scanPreferences(db.QueryRowContext(ctx, `
+		INSERT INTO roommate_preferences AS rp (
+			user_id,
+			preferred_city,
+			preferred_province,
+			budget_min,
+			budget_max,
+			move_in_date,
+			preferred_bedrooms,
+			preferred_bathrooms,
+			furnished_preferred,
+			pets_ok,
+			smoking_ok,
+			parking_needed,
+			gender_preference,
+			min_age,
+			max_age,
+			occupation,
+			lifestyle_notes
+		)
+		VALUES ($1::uuid, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING `+preferencesColumns,
+		append([]any{userID}, params.values()...)...,
+	))
+}
+
+func (repo Repo) Create(ctx context.Context, userID string, params Params) (Preferences, error) {
+	return insertPreferences(ctx, repo.DB, userID, params)
+}
+
+func (repo Repo) CreateTx(ctx context.Context, tx *sql.Tx, userID string, params Params) (Preferences, error) {
+	return insertPreferences(ctx, tx, userID, params)
+}
+
+// replacePreferences overwrites every column, fields left nil are cleared
+func replacePreferences(ctx context.Context, db queryRower, userID string, params Params) (Preferences, error) {
+	return scanPreferences(db.QueryRowContext(ctx, `
+		UPDATE roommate_preferences rp
+		SET
+			preferred_city = $2,
+			preferred_province = $3,
+			budget_min = $4,
+			budget_max = $5,
+			move_in_date = $6,
+			preferred_bedrooms = $7,
+			preferred_bathrooms = $8,
+			furnished_preferred = $9,
+			pets_ok = $10,
+			smoking_ok = $11,
+			parking_needed = $12,
+			gender_preference = $13,
+			min_age = $14,
+			max_age = $15,
+			occupation = $16,
+			lifestyle_notes = $17,
+			updated_at = now()
+		WHERE rp.user_id = $1::uuid
+		RETURNING `+preferencesColumns,
+		append([]any{userID}, params.values()...)...,
+	))
+}
+
+func (repo Repo) Replace(ctx context.Context, userID string, params Params) (Preferences, error) {
+	return replacePreferences(ctx, repo.DB, userID, params)
+}
+
+func (repo Repo) ReplaceTx(ctx context.Context, tx *sql.Tx, userID string, params Params) (Preferences, error) {
+	return replacePreferences(ctx, tx, userID, params)
+}
+
+// Delete removes the preferences, their amenities go with them (ON DELETE CASCADE)
+func (repo Repo) Delete(ctx context.Context, userID string) error {
+	result, err := repo.DB.ExecContext(ctx, `DELETE FROM roommate_preferences WHERE user_id = $1::uuid`, userID)
+	if err != nil {
+		return mapPreferencesError(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrPreferencesNotFound
+	}
+	return nil
+}
+
+func (params Params) values() []any {
+	return []any{
+		params.PreferredCity,
+		params.PreferredProvince,
+		params.BudgetMin,
+		params.BudgetMax,
+		params.MoveInDate,
+		params.PreferredBedrooms,
+		params.PreferredBathrooms,
+		params.FurnishedPreferred,
+		params.PetsOK,
+		params.SmokingOK,
+		params.ParkingNeeded,
+		params.GenderPreference,
+		params.MinAge,
+		params.MaxAge,
+		params.Occupation,
+		params.LifestyleNotes,
+	}
+}
+
+func mapPreferencesError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrPreferencesNotFound
+	}
+
+	var pgErr *pq.Error
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case "22P02":
+		return ErrPreferencesNotFound
+	case "22003":
+		return FieldError{Field: "preferences", Message: "value out of range"}
+	case "23505":
+		return ErrPreferencesExist
+	case "23514":
+		if field, ok := constraintFields[pgErr.Constraint]; ok {
+			return field
+		}
+		return FieldError{Field: "preferences", Message: "invalid value"}
+	}
+	return err
+}
@@ -11,34 +11,65 @@ import (
 	"github.com/lib/pq"
 )
 
+// unread counts stop here so a long backlog costs no more than a short one, clients show "99+"
+const MaxUnreadCount = 100
+
 type Room struct {
 	ID          string            `json:"id"`
 	Name        string            `json:"name"`
 	CreatedBy   string            `json:"createdBy"`
 	CreatedAt   time.Time         `json:"createdAt"`
 	LastMessage *messages.Message `json:"lastMessage,omitempty"`
+	// messages from other members after LastReadMessageID, capped at MaxUnreadCount
+	UnreadCount       int     `json:"unreadCount"`
+	LastReadMessageID *string `json:"lastReadMessageId,omitempty"`
+	// private rooms can not be joined, their members are added with the room
+	IsPrivate bool `json:"isPrivate"`
+}
+
+// ReadState is how far a member has read a room
+type ReadState struct {
+	RoomID    string `json:"roomId"`
+	UserID    string `json:"userId"`
+	MessageID string `json:"messageId"`
+	// created_at of the message, positions compare by it and then by id like message history does
+	MessageAt time.Time `json:"messageAt"`
+	ReadAt    time.Time `json:"readAt"`
 }
 
 type Repo struct {
 	DB *sql.DB
 }
 
+// member roles, owners and moderators can delete any message in the room
+const (
+	RoleOwner     = "owner"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
 var ErrRoomNameExists = errors.New("room name already existes")
 var ErrRoomNotFound = errors.New("no room found with entered ID")
 var ErrInvalidRoomId = errors.New("invalid room id")
+var ErrNotMember = errors.New("not a member of this room")
+var ErrPrivateRoom = errors.New("this room is private")
 
-func (repo Repo) Create(ctx context.Context, name string, createdBy string) (Room, error) {
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func create(ctx context.Context, db queryRower, name string, createdBy string, private bool) (Room, error) {
 	name = strings.TrimSpace(name)
 	if name == "" {
 		return Room{}, errors.New("name required")
 	}
 
 	var room Room
-	err := repo.DB.QueryRowContext(ctx, `
-		INSERT INTO rooms (name, created_by)
-		VALUES ($1, $2::uuid)
-		RETURNING id::text, name, created_by::text, created_at
-		`, name, createdBy).Scan(&room.ID, &room.Name, &room.CreatedBy, &room.CreatedAt)
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO rooms (name, created_by, is_private)
+		VALUES ($1, $2::uuid, $3)
+		RETURNING id::text, name, created_by::text, created_at, is_private
+		`, name, createdBy, private).Scan(&room.ID, &room.Name, &room.CreatedBy, &room.CreatedAt, &room.IsPrivate)
 
 	if err != nil {
 		var pgErr *pq.Error
@@ -51,13 +82,44 @@ func (repo Repo) Create(ctx context.Context, name string, createdBy string) (Roo
 	return room, err
 }
 
-func (repo Repo) AddMember(ctx context.Context, roomID string, userID string) error {
-	_, err := repo.DB.ExecContext(ctx, `
-			INSERT INTO room_members (room_id, user_id)
-			VALUES ($1::uuid, $2::uuid)
-			ON CONFLICT (room_id, user_id) DO NOTHING
-			`, roomID, userID)
+func (repo Repo) Create(ctx context.Context, name string, createdBy string) (Room, error) {
+	return create(ctx, repo.DB, name, createdBy, false)
+}
+
+// CreatePrivateTx creates a room nobody can join, members are added with AddPrivateMemberTx
+func (repo Repo) CreatePrivateTx(ctx context.Context, tx *sql.Tx, name string, createdBy string) (Room, error) {
+	return create(ctx, tx, name, createdBy, true)
+}
 
+// addMember adds a user to a room, the room's creator joins as its owner.
+// Private rooms only take members when allowPrivate is set, existing members are left as they are
+func addMember(ctx context.Context, db queryRower, roomID string, userID string, allowPrivate bool) error {
+	var private, member bool
+	err := db.QueryRowContext(ctx, `
+			WITH room AS (
+			    SELECT id, created_by, is_private
+			    FROM rooms
+			    WHERE id = $1::uuid
+			),
+			inserted AS (
+			    INSERT INTO room_members (room_id, user_id, role)
+			    SELECT
+			        room.id,
+			        $2::uuid,
+			        CASE WHEN room.created_by = $2::uuid THEN 'owner' ELSE 'member' END
+			    FROM room
+			    WHERE NOT room.is_private OR $3
+			    ON CONFLICT (room_id, user_id) DO NOTHING
+			)
+			SELECT
+			    room.is_private,
+			    EXISTS(SELECT 1 FROM room_members WHERE room_id = room.id AND user_id = $2::uuid)
+			FROM room
+			`, roomID, userID, allowPrivate).Scan(&private, &member)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRoomNotFound
+	}
 	if err != nil {
 		var pgErr *pq.Error
 		if errors.As(err, &pgErr) && pgErr.Code == "23503" {
@@ -69,7 +131,21 @@ func (repo Repo) AddMember(ctx context.Context, roomID string, userID string) er
 		return err
 	}
 
-	return err
+	// member is read before the insert, so it tells whether the user was already in the room
+	if private && !allowPrivate && !member {
+		return ErrPrivateRoom
+	}
+	return nil
+}
+
+// AddMember joins a user to a public room, private rooms return ErrPrivateRoom
+func (repo Repo) AddMember(ctx context.Context, roomID string, userID string) error {
+	return addMember(ctx, repo.DB, roomID, userID, false)
+}
+
+// AddPrivateMemberTx adds a user to any room, private ones included, for flows that decide membership themselves
+func (repo Repo) AddPrivateMemberTx(ctx context.Context, tx *sql.Tx, roomID string, userID string) error {
+	return addMember(ctx, tx, roomID, userID, true)
 }
 
 func (repo Repo) IsMember(ctx context.Context, roomID string, userID string) (bool, error) {
@@ -82,6 +158,74 @@ func (repo Repo) IsMember(ctx context.Context, roomID string, userID string) (bo
 	return exists, err
 }
 
+// MemberRole returns the user's role in the room, ErrNotMember when they are not in it
+func (repo Repo) MemberRole(ctx context.Context, roomID string, userID string) (string, error) {
+	var role string
+	err := repo.DB.QueryRowContext(ctx, `
+		SELECT role
+		FROM room_members
+		WHERE room_id = $1::uuid AND user_id = $2::uuid
+		`, roomID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotMember
+		}
+		var pgErr *pq.Error
+		if errors.As(err, &pgErr) && pgErr.Code == "22P02" {
+			return "", ErrNotMember
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+// SetRole makes a member a moderator or a plain member again, the owner's role never changes
+func (repo Repo) SetRole(ctx context.Context, roomID string, userID string, role string) error {
+	result, err := repo.DB.ExecContext(ctx, `
+		UPDATE room_members
+		SET role = $3
+		WHERE room_id = $1::uuid AND user_id = $2::uuid AND role <> 'owner'
+		`, roomID, userID, role)
+	if err != nil {
+		var pgErr *pq.Error
+		if errors.As(err, &pgErr) && pgErr.Code == "22P02" {
+			return ErrNotMember
+		}
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotMember
+	}
+	return nil
+}
+
+func (repo Repo) ListMemberIDs(ctx context.Context, roomID string) ([]string, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT user_id::text
+		FROM room_members
+		WHERE room_id = $1::uuid
+		ORDER BY joined_at ASC
+		`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		out = append(out, userID)
+	}
+	return out, rows.Err()
+}
+
 func (repo Repo) ListForUser(ctx context.Context, userID string) ([]Room, error) {
 	rows, err := repo.DB.QueryContext(ctx, `
 		SELECT
@@ -89,23 +233,47 @@ func (repo Repo) ListForUser(ctx context.Context, userID string) ([]Room, error)
 			r.name,
 		    r.created_by::text,
 			r.created_at,
-		    msg.body as last_message_body,
+		    r.is_private,
+		    msg.id::text as last_message_id,
+		    CASE WHEN msg.deleted_at IS NULL THEN msg.body ELSE '' END as last_message_body,
 		    msg.sender_id::text as last_message_sender_id,
 			msg.created_at as last_message_created_at,
-		    u.name as last_message_sender_name
+		    u.name as last_message_sender_name,
+		    msg.edited_at as last_message_edited_at,
+		    msg.deleted_at as last_message_deleted_at,
+		    m.last_read_message_id::text,
+		    unread.count
 		FROM rooms r
 		JOIN room_members m ON m.room_id = r.id
 		LEFT JOIN LATERAL ( 
-		    SELECT body, sender_id, created_at
+		    SELECT id, body, sender_id, created_at, edited_at, deleted_at
 		    FROM messages
 		    WHERE room_id = r.id
 		    ORDER BY created_at DESC
 		    LIMIT 1
 		 ) msg ON true
 		LEFT JOIN users u ON u.id = msg.sender_id
+		-- walks idx_messages_room_created_id from the newest message back to the read position
+		CROSS JOIN LATERAL (
+		    SELECT count(*) AS count
+		    FROM (
+		        SELECT 1
+		        FROM messages um
+		        WHERE um.room_id = r.id
+		            AND um.sender_id <> m.user_id
+		            AND um.deleted_at IS NULL
+		            AND (
+		                m.last_read_message_at IS NULL
+		                OR (um.created_at >= m.last_read_message_at
+		                    AND (um.created_at > m.last_read_message_at OR um.id > m.last_read_message_id))
+		            )
+		        ORDER BY um.created_at DESC, um.id DESC
+		        LIMIT $2
+		    ) capped
+		) unread
 		WHERE m.user_id = $1::uuid
 		ORDER BY COALESCE(msg.created_at, r.created_at) DESC, r.id DESC
-		`, userID)
+		`, userID, MaxUnreadCount)
 	if err != nil {
 		return nil, err
 	}
@@ -114,28 +282,37 @@ func (repo Repo) ListForUser(ctx context.Context, userID string) ([]Room, error)
 	var out []Room
 	for rows.Next() {
 		var rm Room
-		var lastMessageBody, lastMessageSenderID, lastMessageSenderName *string
-		var lastMessageCreatedAt *time.Time
+		var lastMessageID, lastMessageBody, lastMessageSenderID, lastMessageSenderName *string
+		var lastMessageCreatedAt, lastMessageEditedAt, lastMessageDeletedAt *time.Time
 		if err := rows.Scan(
 			&rm.ID,
 			&rm.Name,
 			&rm.CreatedBy,
 			&rm.CreatedAt,
+			&rm.IsPrivate,
+			&lastMessageID,
 			&lastMessageBody,
 			&lastMessageSenderID,
 			&lastMessageCreatedAt,
 			&lastMessageSenderName,
+			&lastMessageEditedAt,
+			&lastMessageDeletedAt,
+			&rm.LastReadMessageID,
+			&rm.UnreadCount,
 		); err != nil {
 			return nil, err
 		}
 
 		if lastMessageBody != nil {
 			rm.LastMessage = &messages.Message{
+				ID:         *lastMessageID,
 				Body:       *lastMessageBody,
 				SenderID:   *lastMessageSenderID,
 				SenderName: *lastMessageSenderName,
 				CreatedAt:  *lastMessageCreatedAt,
 				RoomID:     rm.ID,
+				EditedAt:   lastMessageEditedAt,
+				DeletedAt:  lastMessageDeletedAt,
 			}
 		}
 
@@ -143,3 +320,82 @@ func (repo Repo) ListForUser(ctx context.Context, userID string) ([]Room, error)
 	}
 	return out, rows.Err()
 }
+
+// MarkRead moves the member's read position to messageID. The position only moves forward, advanced
+// is false when the member had already read past it
+func (repo Repo) MarkRead(ctx context.Context, roomID string, userID string, messageID string) (ReadState, bool, error) {
+	var state ReadState
+	err := repo.DB.QueryRowContext(ctx, `
+		WITH target AS (
+		    SELECT id, created_at
+		    FROM messages
+		    WHERE id = $3::uuid AND room_id = $1::uuid
+		)
+		UPDATE room_members m
+		SET last_read_message_id = t.id,
+		    last_read_message_at = t.created_at,
+		    last_read_at = now()
+		FROM target t
+		WHERE m.room_id = $1::uuid
+			AND m.user_id = $2::uuid
+			AND (
+			    m.last_read_message_at IS NULL
+			    OR t.created_at > m.last_read_message_at
+			    OR (t.created_at = m.last_read_message_at AND t.id > m.last_read_message_id)
+			)
+		RETURNING m.room_id::text, m.user_id::text, m.last_read_message_id::text, m.last_read_message_at, m.last_read_at
+		`, roomID, userID, messageID).Scan(&state.RoomID, &state.UserID, &state.MessageID, &state.MessageAt, &state.ReadAt)
+	if err == nil {
+		return state, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return ReadState{}, false, mapReadError(err)
+	}
+
+	// nothing updated: the message is not in the room or the position is already past it
+	var exists bool
+	err = repo.DB.QueryRowContext(ctx, `
+		SELECT EXISTS(
+		    SELECT 1 FROM messages
+		    WHERE id = $2::uuid AND room_id = $1::uuid
+		)`, roomID, messageID).Scan(&exists)
+	if err != nil {
+		return ReadState{}, false, mapReadError(err)
+	}
+	if !exists {
+		return ReadState{}, false, messages.ErrMessageNotFound
+	}
+	return ReadState{}, false, nil
+}
+
+// ListReads returns the read position of every member who has read something in the room
+func (repo Repo) ListReads(ctx context.Context, roomID string) ([]ReadState, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT room_id::text, user_id::text, last_read_message_id::text, last_read_message_at, last_read_at
+		FROM room_members
+		WHERE room_id = $1::uuid AND last_read_message_id IS NOT NULL
+		ORDER BY last_read_message_at DESC, user_id ASC
+		`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ReadState
+	for rows.Next() {
+		var state ReadState
+		if err := rows.Scan(&state.RoomID, &state.UserID, &state.MessageID, &state.MessageAt, &state.ReadAt); err != nil {
+			return nil, err
+		}
+		out = append(out, state)
+	}
+	return out, rows.Err()
+}
+
+func mapReadError(err error) error {
+	var pgErr *pq.Error
+	if errors.As(err, &pgErr) && pgErr.Code == "22P02" {
+		return messages.ErrMessageNotFound
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+package saved_listings
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+type Repo struct {
+	DB *sql.DB
+}
+
+var ErrListingNotFound = errors.New("listing not found")
+
+func (repo Repo) Save(ctx context.Context, userID string, listingID string) error {
+	_, err := repo.DB.ExecContext(ctx, `
+		INSERT INTO saved_listings (user_id, listing_id)
+		VALUES ($1::uuid, $2::uuid)
+		ON CONFLICT (user_id, listing_id) DO NOTHING
+		`, userID, listingID)
+	if err != nil {
+		var pgErr *pq.Error
+		if errors.As(err, &pgErr) && (pgErr.Code == "23503" || pgErr.Code == "22P02") {
+			return ErrListingNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+func (repo Repo) Unsave(ctx context.Context, userID string, listingID string) error {
+	_, err := repo.DB.ExecContext(ctx, `
+		DELETE FROM saved_listings
+		WHERE user_id = $1::uuid AND listing_id = $2::uuid
+		`, userID, listingID)
+	if err != nil {
+		var pgErr *pq.Error
+		if errors.As(err, &pgErr) && pgErr.Code == "22P02" {
+			return ErrListingNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// SavedAmong returns the subset of listingIDs the user has saved
+func (repo Repo) SavedAmong(ctx context.Context, userID string, listingIDs []string) (map[string]bool, error) {
+	saved := make(map[string]bool)
+	if len(listingIDs) == 0 {
+		return saved, nil
+	}
+
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT listing_id::text
+		FROM saved_listings
+		WHERE user_id = $1::uuid AND listing_id = ANY($2::uuid[])
+		`, userID, pq.Array(listingIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var listingID string
+		if err := rows.Scan(&listingID); err != nil {
+			return nil, err
+		}
+		saved[listingID] = true
+	}
+	return saved, rows.Err()
+}
+
+// SaverIDs returns the users who saved the listing, used to fan out change notifications
+func (repo Repo) SaverIDs(ctx context.Context, listingID string) ([]string, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT user_id::text
+		FROM saved_listings
+		WHERE listing_id = $1::uuid
+		`, listingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		out = append(out, userID)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,374 @@
+package saved_searches
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MaxPerUser caps how many searches a user can save
+const MaxPerUser = 20
+
+// Filters mirrors the /listings query parameters a search can be saved with
+type Filters struct {
+	City             string   `json:"city,omitempty"`
+	Province         string   `json:"province,omitempty"`
+	PriceMin         *float64 `json:"priceMin,omitempty"`
+	PriceMax         *float64 `json:"priceMax,omitempty"`
+	BedroomsMin      *int     `json:"bedroomsMin,omitempty"`
+	BathroomsMin     *float64 `json:"bathroomsMin,omitempty"`
+	IsFurnished      *bool    `json:"isFurnished,omitempty"`
+	PetsAllowed      *bool    `json:"petsAllowed,omitempty"`
+	SmokingAllowed   *bool    `json:"smokingAllowed,omitempty"`
+	ParkingAvailable *bool    `json:"parkingAvailable,omitempty"`
+	Amenities        []string `json:"amenities,omitempty"`
+}
+
+type SavedSearch struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"userId"`
+	Name        string    `json:"name"`
+	Filters     Filters   `json:"filters"`
+	NotifyInApp bool      `json:"notifyInApp"`
+	EmailDigest bool      `json:"emailDigest"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+type Params struct {
+	Name        string
+	Filters     Filters
+	NotifyInApp bool
+	EmailDigest bool
+}
+
+// DigestItem is one undigested match joined with what the digest email needs
+type DigestItem struct {
+	UserID        string
+	Email         string
+	UserName      string
+	SavedSearchID string
+	SearchName    string
+	ListingID     string
+	ListingTitle  string
+	City          string
+	Price         float64
+	Currency      string
+	MatchedAt     time.Time
+	// false once the listing is no longer active, such matches are marked digested without being sent
+	ListingActive bool
+}
+
+type Repo struct {
+	DB *sql.DB
+}
+
+var ErrSavedSearchNotFound = errors.New("saved search not found")
+var ErrTooManySearches = errors.New("saved search limit reached")
+
+const savedSearchColumns = `
+	ss.id::text,
+	ss.user_id::text,
+	ss.name,
+	ss.filters,
+	ss.notify_in_app,
+	ss.email_digest,
+	ss.created_at,
+	ss.updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSavedSearch(row rowScanner) (SavedSearch, error) {
+	var search SavedSearch
+	var filters []byte
+
+	err := row.Scan(
+		&search.ID,
+		&search.UserID,
+		&search.Name,
+		&filters,
+		&search.NotifyInApp,
+		&search.EmailDigest,
+		&search.CreatedAt,
+		&search.UpdatedAt,
+	)
+	if err != nil {
+		return SavedSearch{}, mapSavedSearchError(err)
+	}
+
+	if err := json.Unmarshal(filters, &search.Filters); err != nil {
+		return SavedSearch{}, err
+	}
+	return search, nil
+}
+
+// Create saves a search, failing with ErrTooManySearches once the user has MaxPerUser of them.
+// The user row is locked while counting so concurrent creates can not both slip under the limit
+func (repo Repo) Create(ctx context.Context, userID string, params Params) (SavedSearch, error) {
+	filters, err := json.Marshal(params.Filters)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var lockedID string
+	err = tx.QueryRowContext(ctx, `
+		SELECT id::text FROM users WHERE id = $1::uuid FOR NO KEY UPDATE
+		`, userID).Scan(&lockedID)
+	if err != nil {
+		return SavedSearch{}, mapSavedSearchError(err)
+	}
+
+	search, err := scanSavedSearch(tx.QueryRowContext(ctx, `
+		INSERT INTO saved_searches AS ss (user_id, name, filters, notify_in_app, email_digest)
+		SELECT $1::uuid, $2, $3::jsonb, $4, $5
+		WHERE (SELECT count(*) FROM saved_searches WHERE user_id = $1::uuid) < $6
+		RETURNING `+savedSearchColumns,
+		userID, params.Name, string(filters), params.NotifyInApp, params.EmailDigest, MaxPerUser,
+	))
+	// the insert selects nothing when the user is at the limit
+	if errors.Is(err, ErrSavedSearchNotFound) {
+		return SavedSearch{}, ErrTooManySearches
+	}
+	if err != nil {
+		return SavedSearch{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return SavedSearch{}, err
+	}
+	return search, nil
+}
+
+func (repo Repo) ListByUser(ctx context.Context, userID string) ([]SavedSearch, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT `+savedSearchColumns+`
+		FROM saved_searches ss
+		WHERE ss.user_id = $1::uuid
+		ORDER BY ss.created_at DESC
+		`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSavedSearches(rows)
+}
+
+// Get returns a saved search owned by userID, searches of other users are reported as not found
+func (repo Repo) Get(ctx context.Context, userID string, id string) (SavedSearch, error) {
+	return scanSavedSearch(repo.DB.QueryRowContext(ctx, `
+		SELECT `+savedSearchColumns+`
+		FROM saved_searches ss
+		WHERE ss.id = $1::uuid AND ss.user_id = $2::uuid
+		`, id, userID))
+}
+
+func (repo Repo) Update(ctx context.Context, userID string, id string, params Params) (SavedSearch, error) {
+	filters, err := json.Marshal(params.Filters)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+
+	return scanSavedSearch(repo.DB.QueryRowContext(ctx, `
+		UPDATE saved_searches ss
+		SET
+			name = $3,
+			filters = $4::jsonb,
+			notify_in_app = $5,
+			email_digest = $6,
+			updated_at = now()
+		WHERE ss.id = $1::uuid AND ss.user_id = $2::uuid
+		RETURNING `+savedSearchColumns,
+		id, userID, params.Name, string(filters), params.NotifyInApp, params.EmailDigest,
+	))
+}
+
+func (repo Repo) Delete(ctx context.Context, userID string, id string) error {
+	result, err := repo.DB.ExecContext(ctx, `
+		DELETE FROM saved_searches
+		WHERE id = $1::uuid AND user_id = $2::uuid
+		`, id, userID)
+	if err != nil {
+		return mapSavedSearchError(err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrSavedSearchNotFound
+	}
+	return nil
+}
+
+// ListMatchCandidates narrows the saved searches of other users that could match a listing
+// on location and price, the remaining filters are checked by the caller
+func (repo Repo) ListMatchCandidates(ctx context.Context, ownerID string, city string, province string, price float64) ([]SavedSearch, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT `+savedSearchColumns+`
+		FROM saved_searches ss
+		WHERE ss.user_id <> $1::uuid
+			AND (ss.notify_in_app OR ss.email_digest)
+			AND coalesce(lower(ss.filters->>'city'), lower($2)) = lower($2)
+			AND coalesce(lower(ss.filters->>'province'), lower($3)) = lower($3)
+			AND coalesce((ss.filters->>'priceMin')::numeric, $4::numeric) <= $4::numeric
+			AND coalesce((ss.filters->>'priceMax')::numeric, $4::numeric) >= $4::numeric
+		`, ownerID, city, province, price)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanSavedSearches(rows)
+}
+
+// RecordMatches stores that a listing matched the given searches and returns the ids
+// of the searches that had not matched it before
+func (repo Repo) RecordMatches(ctx context.Context, listingID string, searchIDs []string) ([]string, error) {
+	if len(searchIDs) == 0 {
+		return nil, nil
+	}
+
+	rows, err := repo.DB.QueryContext(ctx, `
+		INSERT INTO saved_search_matches (saved_search_id, listing_id)
+		SELECT id, $1::uuid
+		FROM unnest($2::uuid[]) AS id
+		ON CONFLICT (saved_search_id, listing_id) DO NOTHING
+		RETURNING saved_search_id::text
+		`, listingID, pq.Array(searchIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recorded []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		recorded = append(recorded, id)
+	}
+	return recorded, rows.Err()
+}
+
+// ListUndigested returns matches not yet sent by email for searches with the digest enabled,
+// grouped by user, leaving out the users in skipUserIDs
+func (repo Repo) ListUndigested(ctx context.Context, matchedBefore time.Time, skipUserIDs []string, limit int) ([]DigestItem, error) {
+	rows, err := repo.DB.QueryContext(ctx, `
+		SELECT
+			u.id::text,
+			u.email,
+			u.name,
+			ss.id::text,
+			ss.name,
+			l.id::text,
+			l.title,
+			l.city,
+			l.price::float8,
+			l.currency,
+			m.created_at,
+			l.status = 'active'
+		FROM saved_search_matches m
+		JOIN saved_searches ss ON ss.id = m.saved_search_id
+		JOIN users u ON u.id = ss.user_id
+		JOIN listings l ON l.id = m.listing_id
+		WHERE m.digested_at IS NULL
+			AND m.created_at < $1
+			AND ss.email_digest = true
+			AND u.id <> ALL(coalesce($2::uuid[], '{}'))
+		ORDER BY u.id, m.created_at
+		LIMIT $3
+		`, matchedBefore, pq.Array(skipUserIDs), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []DigestItem
+	for rows.Next() {
+		var item DigestItem
+		if err := rows.Scan(
+			&item.UserID,
+			&item.Email,
+			&item.UserName,
+			&item.SavedSearchID,
+			&item.SearchName,
+			&item.ListingID,
+			&item.ListingTitle,
+			&item.City,
+			&item.Price,
+			&item.Currency,
+			&item.MatchedAt,
+			&item.ListingActive,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// MarkDigested marks exactly the given matches as handled, the ones that were emailed
+// and the ones skipped because the listing is no longer active
+func (repo Repo) MarkDigested(ctx context.Context, items []DigestItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	searchIDs := make([]string, len(items))
+	listingIDs := make([]string, len(items))
+	for i, item := range items {
+		searchIDs[i] = item.SavedSearchID
+		listingIDs[i] = item.ListingID
+	}
+
+	_, err := repo.DB.ExecContext(ctx, `
+		UPDATE saved_search_matches m
+		SET digested_at = now()
+		FROM unnest($1::uuid[], $2::uuid[]) AS d(saved_search_id, listing_id)
+		WHERE m.saved_search_id = d.saved_search_id
+			AND m.listing_id = d.listing_id
+			AND m.digested_at IS NULL
+		`, pq.Array(searchIDs), pq.Array(listingIDs))
+	return err
+}
+
+func scanSavedSearches(rows *sql.Rows) ([]SavedSearch, error) {
+	out := make([]SavedSearch, 0)
+	for rows.Next() {
+		search, err := scanSavedSearch(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, search)
+	}
+	return out, rows.Err()
+}
+
+func mapSavedSearchError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSavedSearchNotFound
+	}
+
+	var pgErr *pq.Error
+	if errors.As(err, &pgErr) && pgErr.Code == "22P02" {
+		return ErrSavedSearchNotFound
+	}
+	return err
+}
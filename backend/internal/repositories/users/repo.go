@@ -6,6 +6,8 @@ import (
 	"errors"
 	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type User struct {
@@ -13,7 +15,10 @@ type User struct {
 	Email        string
 	Name         string
 	PasswordHash string
-	CreatedAt    time.Time
+	IsAdmin      bool
+	// set while the account is suspended by a moderator
+	SuspendedAt *time.Time
+	CreatedAt   time.Time
 }
 
 type Repo struct {
@@ -31,15 +36,15 @@ func (r Repo) Create(ctx context.Context, email string, passwordHash string, nam
 	err := r.DB.QueryRowContext(ctx, `
 		INSERT INTO users (email, password_hash, name)
 		VALUES ($1, $2, $3)
-		RETURNING id::text, email, name, password_hash, created_at
-		`, email, passwordHash, name).Scan(&user.ID, &user.Email, &user.Name, &user.PasswordHash, &user.CreatedAt)
+		RETURNING id::text, email, name, password_hash, is_admin, suspended_at, created_at
+		`, email, passwordHash, name).Scan(&user.ID, &user.Email, &user.Name, &user.PasswordHash, &user.IsAdmin, &user.SuspendedAt, &user.CreatedAt)
 
 	return user, err
 }
 
 func (r Repo) GetUserById(ctx context.Context, id string) (User, error) {
 	var user User
-	err := r.DB.QueryRowContext(ctx, `SELECT id::text, email, name, password_hash, created_at FROM users WHERE id = $1::uuid`, id).Scan(&user.ID, &user.Email, &user.Name, &user.PasswordHash, &user.CreatedAt)
+	err := r.DB.QueryRowContext(ctx, `SELECT id::text, email, name, password_hash, is_admin, suspended_at, created_at FROM users WHERE id = $1::uuid`, id).Scan(&user.ID, &user.Email, &user.Name, &user.PasswordHash, &user.IsAdmin, &user.SuspendedAt, &user.CreatedAt)
 
 	return user, err
 }
@@ -51,7 +56,72 @@ func (r Repo) FindByEmail(ctx context.Context, email string) (User, error) {
 	}
 
 	var user User
-	err := r.DB.QueryRowContext(ctx, `SELECT id::text, email, name, password_hash, created_at FROM users WHERE email = $1`, email).Scan(&user.ID, &user.Email, &user.Name, &user.PasswordHash, &user.CreatedAt)
+	err := r.DB.QueryRowContext(ctx, `SELECT id::text, email, name, password_hash, is_admin, suspended_at, created_at FROM users WHERE email = $1`, email).Scan(&user.ID, &user.Email, &user.Name, &user.PasswordHash, &user.IsAdmin, &user.SuspendedAt, &user.CreatedAt)
 
 	return user, err
 }
+
+func (r Repo) IsAdmin(ctx context.Context, id string) (bool, error) {
+	var isAdmin bool
+	err := r.DB.QueryRowContext(ctx, `SELECT is_admin FROM users WHERE id = $1::uuid`, id).Scan(&isAdmin)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return isAdmin, err
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// setSuspended suspends or reinstates a user, it reports sql.ErrNoRows for an unknown user
+func setSuspended(ctx context.Context, db execer, id string, suspended bool) error {
+	result, err := db.ExecContext(ctx, `
+		UPDATE users
+		SET suspended_at = CASE WHEN $2 THEN coalesce(suspended_at, now()) END
+		WHERE id = $1::uuid
+		`, id, suspended)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "22P02" {
+		return sql.ErrNoRows
+	}
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r Repo) SetSuspended(ctx context.Context, id string, suspended bool) error {
+	return setSuspended(ctx, r.DB, id, suspended)
+}
+
+func (r Repo) SetSuspendedTx(ctx context.Context, tx *sql.Tx, id string, suspended bool) error {
+	return setSuspended(ctx, tx, id, suspended)
+}
+
+// ListSuspendedIDs returns every suspended user, the session store is seeded from it on startup
+func (r Repo) ListSuspendedIDs(ctx context.Context) ([]string, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT id::text FROM users WHERE suspended_at IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,282 @@
+package roommate
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/middleware"
+	"go-react-rooms/internal/repositories/amenities"
+	"go-react-rooms/internal/repositories/roommate_preferences"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Handlers struct {
+	Preferences roommate_preferences.Repo
+	Amenities   amenities.Repo
+	DB          *sql.DB
+}
+
+type PreferencesResponse struct {
+	Preferences roommate_preferences.Preferences `json:"preferences"`
+	Amenities   []amenities.Amenity              `json:"amenities"`
+}
+
+// preferencesReq is the full preferences document, fields left out are stored as NULL
+type preferencesReq struct {
+	PreferredCity     *string  `json:"preferredCity"`
+	PreferredProvince *string  `json:"preferredProvince"`
+	BudgetMin         *float64 `json:"budgetMin"`
+	BudgetMax         *float64 `json:"budgetMax"`
+	// YYYY-MM-DD
+	MoveInDate         *string  `json:"moveInDate"`
+	PreferredBedrooms  *int     `json:"preferredBedrooms"`
+	PreferredBathrooms *float64 `json:"preferredBathrooms"`
+	FurnishedPreferred *bool    `json:"furnishedPreferred"`
+	PetsOK             *bool    `json:"petsOk"`
+	SmokingOK          *bool    `json:"smokingOk"`
+	ParkingNeeded      *bool    `json:"parkingNeeded"`
+	GenderPreference   *string  `json:"genderPreference"`
+	MinAge             *int     `json:"minAge"`
+	MaxAge             *int     `json:"maxAge"`
+	Occupation         *string  `json:"occupation"`
+	LifestyleNotes     *string  `json:"lifestyleNotes"`
+	Amenities          []string `json:"amenities"`
+}
+
+// HandlePreferences routes /roommate-preferences by method, it always acts on the caller's own preferences
+func (handler Handlers) HandlePreferences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handler.GetPreferences(w, r)
+	case http.MethodPost:
+		handler.SavePreferences(w, r, false)
+	case http.MethodPut:
+		handler.SavePreferences(w, r, true)
+	case http.MethodDelete:
+		handler.DeletePreferences(w, r)
+	default:
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (handler Handlers) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	preferences, err := handler.Preferences.Get(r.Context(), userID)
+	if err != nil {
+		writePreferencesError(w, err)
+		return
+	}
+
+	preferenceAmenities, err := handler.Amenities.ListForPreferences(r.Context(), userID)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not load preference amenities")
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusOK, PreferencesResponse{
+		Preferences: preferences,
+		Amenities:   preferenceAmenities,
+	})
+}
+
+// SavePreferences creates (POST) or replaces (PUT) the caller's preferences and their amenities
+func (handler Handlers) SavePreferences(w http.ResponseWriter, r *http.Request, replace bool) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var req preferencesReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	params, fields := req.params()
+	if len(fields) > 0 {
+		functions.WriteFieldErrors(w, fields)
+		return
+	}
+
+	tx, err := handler.DB.BeginTx(r.Context(), nil)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to start transaction")
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	var preferences roommate_preferences.Preferences
+	if replace {
+		preferences, err = handler.Preferences.ReplaceTx(r.Context(), tx, userID, params)
+	} else {
+		preferences, err = handler.Preferences.CreateTx(r.Context(), tx, userID, params)
+	}
+	if err != nil {
+		writePreferencesError(w, err)
+		return
+	}
+
+	if err := handler.Amenities.SetForPreferencesTx(r.Context(), tx, userID, req.Amenities); err != nil {
+		var unknown amenities.UnknownAmenityError
+		if errors.As(err, &unknown) {
+			functions.WriteFieldErrors(w, map[string]string{"amenities": unknown.Error()})
+			return
+		}
+		functions.WriteError(w, http.StatusInternalServerError, "failed to save preference amenities")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "failed to commit transaction")
+		return
+	}
+
+	preferenceAmenities, err := handler.Amenities.ListForPreferences(r.Context(), userID)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not load preference amenities")
+		return
+	}
+
+	status := http.StatusCreated
+	if replace {
+		status = http.StatusOK
+	}
+	functions.WriteJSON(w, status, PreferencesResponse{
+		Preferences: preferences,
+		Amenities:   preferenceAmenities,
+	})
+}
+
+func (handler Handlers) DeletePreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if err := handler.Preferences.Delete(r.Context(), userID); err != nil {
+		writePreferencesError(w, err)
+		return
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+}
+
+// params validates the request against the same rules as the table CHECK constraints
+// and returns one message per invalid field
+func (req preferencesReq) params() (roommate_preferences.Params, map[string]string) {
+	fields := make(map[string]string)
+
+	params := roommate_preferences.Params{
+		PreferredCity:      trimOptional(req.PreferredCity),
+		PreferredProvince:  trimOptional(req.PreferredProvince),
+		BudgetMin:          req.BudgetMin,
+		BudgetMax:          req.BudgetMax,
+		PreferredBedrooms:  req.PreferredBedrooms,
+		PreferredBathrooms: req.PreferredBathrooms,
+		FurnishedPreferred: req.FurnishedPreferred,
+		PetsOK:             req.PetsOK,
+		SmokingOK:          req.SmokingOK,
+		ParkingNeeded:      req.ParkingNeeded,
+		GenderPreference:   trimOptional(req.GenderPreference),
+		MinAge:             req.MinAge,
+		MaxAge:             req.MaxAge,
+		Occupation:         trimOptional(req.Occupation),
+		LifestyleNotes:     trimOptional(req.LifestyleNotes),
+	}
+
+	if params.BudgetMin != nil && *params.BudgetMin < 0 {
+		fields["budgetMin"] = "must be 0 or more"
+	} else if params.BudgetMin != nil && *params.BudgetMin > roommate_preferences.MaxBudget {
+		fields["budgetMin"] = fmt.Sprintf("must be at most %.2f", roommate_preferences.MaxBudget)
+	}
+	if params.BudgetMax != nil && *params.BudgetMax < 0 {
+		fields["budgetMax"] = "must be 0 or more"
+	} else if params.BudgetMax != nil && *params.BudgetMax > roommate_preferences.MaxBudget {
+		fields["budgetMax"] = fmt.Sprintf("must be at most %.2f", roommate_preferences.MaxBudget)
+	} else if params.BudgetMin != nil && params.BudgetMax != nil && *params.BudgetMax < *params.BudgetMin {
+		fields["budgetMax"] = "must be greater than or equal to budgetMin"
+	}
+
+	if moveIn := trimOptional(req.MoveInDate); moveIn != nil {
+		date, err := time.Parse("2006-01-02", *moveIn)
+		if err != nil {
+			fields["moveInDate"] = "must be a date formatted as YYYY-MM-DD"
+		} else {
+			params.MoveInDate = &date
+		}
+	}
+
+	if params.PreferredBedrooms != nil && *params.PreferredBedrooms < 0 {
+		fields["preferredBedrooms"] = "must be 0 or more"
+	} else if params.PreferredBedrooms != nil && *params.PreferredBedrooms > roommate_preferences.MaxInteger {
+		fields["preferredBedrooms"] = fmt.Sprintf("must be at most %d", roommate_preferences.MaxInteger)
+	}
+	if params.PreferredBathrooms != nil && *params.PreferredBathrooms < 0 {
+		fields["preferredBathrooms"] = "must be 0 or more"
+	} else if params.PreferredBathrooms != nil && *params.PreferredBathrooms > roommate_preferences.MaxBathrooms {
+		fields["preferredBathrooms"] = fmt.Sprintf("must be at most %.1f", roommate_preferences.MaxBathrooms)
+	}
+
+	if params.GenderPreference != nil {
+		gender := strings.ToLower(*params.GenderPreference)
+		switch gender {
+		case roommate_preferences.GenderMale, roommate_preferences.GenderFemale, roommate_preferences.GenderAny, roommate_preferences.GenderOther:
+			params.GenderPreference = &gender
+		default:
+			fields["genderPreference"] = "must be one of male, female, any, other"
+		}
+	}
+
+	if params.MinAge != nil && *params.MinAge < roommate_preferences.MinAge {
+		fields["minAge"] = "must be at least 18"
+	} else if params.MinAge != nil && *params.MinAge > roommate_preferences.MaxInteger {
+		fields["minAge"] = fmt.Sprintf("must be at most %d", roommate_preferences.MaxInteger)
+	}
+	if params.MaxAge != nil && *params.MaxAge < roommate_preferences.MinAge {
+		fields["maxAge"] = "must be at least 18"
+	} else if params.MaxAge != nil && *params.MaxAge > roommate_preferences.MaxInteger {
+		fields["maxAge"] = fmt.Sprintf("must be at most %d", roommate_preferences.MaxInteger)
+	} else if params.MinAge != nil && params.MaxAge != nil && *params.MaxAge < *params.MinAge {
+		fields["maxAge"] = "must be greater than or equal to minAge"
+	}
+
+	return params, fields
+}
+
+func trimOptional(value *string) *string {
+	if value == nil {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*value)
+	if trimmed == "" {
+		return nil
+	}
+	return &trimmed
+}
+
+func writePreferencesError(w http.ResponseWriter, err error) {
+	var field roommate_preferences.FieldError
+	switch {
+	case errors.As(err, &field):
+		functions.WriteFieldErrors(w, map[string]string{field.Field: field.Message})
+	case errors.Is(err, roommate_preferences.ErrPreferencesNotFound):
+		functions.WriteError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, roommate_preferences.ErrPreferencesExist):
+		functions.WriteError(w, http.StatusConflict, err.Error())
+	default:
+		functions.WriteError(w, http.StatusInternalServerError, "roommate preferences request failed")
+	}
+}
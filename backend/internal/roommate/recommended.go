@@ -0,0 +1,90 @@
+package roommate
+
+import (
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/matching"
+	"go-react-rooms/internal/middleware"
+	"go-react-rooms/internal/repositories/roommate_preferences"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type RecommendedRoommate struct {
+	Roommate roommate_preferences.Candidate `json:"roommate"`
+	Match    matching.Match                 `json:"match"`
+}
+
+// RecommendedRoommates ranks other users by how compatible their roommate preferences are with the caller's
+//
+//	GET /roommates/recommended?limit=20
+func (handler Handlers) RecommendedRoommates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed, use GET")
+		return
+	}
+
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	limit := 20
+	if requestLimit := strings.TrimSpace(r.URL.Query().Get("limit")); requestLimit != "" {
+		if requestLimitToInt, err := strconv.Atoi(requestLimit); err == nil && requestLimitToInt > 0 && requestLimitToInt <= 50 {
+			limit = requestLimitToInt
+		}
+	}
+
+	preferences, err := handler.Preferences.Get(r.Context(), userID)
+	if err != nil {
+		writePreferencesError(w, err)
+		return
+	}
+
+	var province string
+	if preferences.PreferredProvince != nil {
+		province = *preferences.PreferredProvince
+	}
+
+	candidates, err := handler.Preferences.ListCandidates(r.Context(), userID, province, 0)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not list roommates")
+		return
+	}
+
+	ids := make([]string, 0, len(candidates)+1)
+	ids = append(ids, userID)
+	for _, candidate := range candidates {
+		ids = append(ids, candidate.UserID)
+	}
+	wanted, err := handler.Amenities.KeysForPreferences(r.Context(), ids)
+	if err != nil {
+		functions.WriteError(w, http.StatusInternalServerError, "could not load preference amenities")
+		return
+	}
+
+	seeker := matching.Seeker{Preferences: preferences, Amenities: wanted[userID]}
+	matches := make([]matching.Match, len(candidates))
+	for i, candidate := range candidates {
+		matches[i] = matching.ScoreRoommate(seeker, matching.Seeker{
+			Preferences: candidate.Preferences,
+			Amenities:   wanted[candidate.UserID],
+		})
+	}
+
+	ranked := matching.Rank(matches)
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	out := make([]RecommendedRoommate, len(ranked))
+	for i, rank := range ranked {
+		out[i] = RecommendedRoommate{Roommate: candidates[rank.Index], Match: rank.Match}
+	}
+
+	functions.WriteJSON(w, http.StatusOK, map[string]any{
+		"recommendations": out,
+	})
+}
@@ -0,0 +1,134 @@
+package savedsearch
+
+import (
+	"context"
+	"fmt"
+	"go-react-rooms/internal/mailer"
+	"go-react-rooms/internal/repositories/saved_searches"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const digestBatchSize = 500
+
+// DigestJob emails each user one summary of the listings that matched their saved searches
+// since the last digest, for searches with the email digest enabled
+type DigestJob struct {
+	SavedSearches saved_searches.Repo
+	Mailer        mailer.Mailer
+	// base URL of the frontend, used to link listings
+	AppURL string
+	// Now is the job clock, time.Now when nil
+	Now func() time.Time
+}
+
+// Run works through every pending digest in batches, it stops at the first batch that is not full.
+// A user whose digest fails is logged and left out of the rest of the run, their matches stay
+// pending for the next run
+func (job DigestJob) Run(ctx context.Context) error {
+	now := time.Now()
+	if job.Now != nil {
+		now = job.Now()
+	}
+
+	var failed []string
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		items, err := job.SavedSearches.ListUndigested(ctx, now, failed, digestBatchSize)
+		if err != nil {
+			return fmt.Errorf("list undigested matches: %w", err)
+		}
+		full := len(items) == digestBatchSize
+
+		// items are ordered by user, when the batch is full the last user may be cut off,
+		// leave them for the next batch unless they fill the whole batch on their own
+		if full {
+			last := items[len(items)-1].UserID
+			trimmed := len(items)
+			for trimmed > 0 && items[trimmed-1].UserID == last {
+				trimmed--
+			}
+			if trimmed > 0 {
+				items = items[:trimmed]
+			}
+		}
+
+		// handled matches are marked and failed users skipped, so the next batch starts after the users seen here
+		for start := 0; start < len(items); {
+			end := start
+			for end < len(items) && items[end].UserID == items[start].UserID {
+				end++
+			}
+
+			if err := job.send(ctx, items[start:end]); err != nil {
+				log.Printf("saved search digest: %v", err)
+				failed = append(failed, items[start].UserID)
+			}
+			start = end
+		}
+
+		if !full {
+			return nil
+		}
+	}
+}
+
+// send mails one user's digest of active listings, then marks the sent and skipped matches
+// so a failure is retried on the next run
+func (job DigestJob) send(ctx context.Context, pending []saved_searches.DigestItem) error {
+	user := pending[0]
+
+	// pending comes in match order, the email lists active listings per saved search
+	var items []saved_searches.DigestItem
+	for _, item := range pending {
+		if item.ListingActive {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		if err := job.SavedSearches.MarkDigested(ctx, pending); err != nil {
+			return fmt.Errorf("mark digest of %s: %w", user.UserID, err)
+		}
+		return nil
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].SearchName < items[j].SearchName
+	})
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "Hi %s,\n\nNew listings matched your saved searches:\n", user.UserName)
+
+	search := ""
+	for _, item := range items {
+		if item.SearchName != search {
+			search = item.SearchName
+			fmt.Fprintf(&text, "\n%s\n", search)
+		}
+		fmt.Fprintf(&text, "  - %s, %s, %.2f %s\n    %s/listings/%s\n", item.ListingTitle, item.City, item.Price, item.Currency, strings.TrimRight(job.AppURL, "/"), item.ListingID)
+	}
+	text.WriteString("\nYou can turn these emails off in your saved search settings.\n")
+
+	subject := fmt.Sprintf("%d new listings match your saved searches", len(items))
+	if len(items) == 1 {
+		subject = "1 new listing matches your saved search"
+	}
+
+	err := job.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: subject,
+		Text:    text.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("send digest to %s: %w", user.UserID, err)
+	}
+
+	if err := job.SavedSearches.MarkDigested(ctx, pending); err != nil {
+		return fmt.Errorf("mark digest of %s: %w", user.UserID, err)
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package savedsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"go-react-rooms/internal/functions"
+	"go-react-rooms/internal/middleware"
+	"go-react-rooms/internal/repositories/amenities"
+	"go-react-rooms/internal/repositories/saved_searches"
+	"net/http"
+	"strings"
+)
+
+type Handlers struct {
+	SavedSearches saved_searches.Repo
+	Amenities     amenities.Repo
+}
+
+type savedSearchReq struct {
+	Name    string                 `json:"name"`
+	Filters saved_searches.Filters `json:"filters"`
+	// in-app alerts default to on, the email digest to off
+	NotifyInApp *bool `json:"notifyInApp"`
+	EmailDigest *bool `json:"emailDigest"`
+}
+
+// HandleSavedSearches routes /saved-searches: GET lists the caller's searches, POST saves a new one
+func (handler Handlers) HandleSavedSearches(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		items, err := handler.SavedSearches.ListByUser(r.Context(), userID)
+		if err != nil {
+			functions.WriteError(w, http.StatusInternalServerError, "could not list saved searches")
+			return
+		}
+		functions.WriteJSON(w, http.StatusOK, map[string]any{
+			"savedSearches": items,
+		})
+	case http.MethodPost:
+		params, ok := handler.decodeParams(w, r)
+		if !ok {
+			return
+		}
+
+		search, err := handler.SavedSearches.Create(r.Context(), userID, params)
+		if err != nil {
+			writeSavedSearchError(w, err)
+			return
+		}
+		functions.WriteJSON(w, http.StatusCreated, search)
+	default:
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// HandleSavedSearch routes /saved-searches/{id}: GET, PUT replaces the search, DELETE removes it
+func (handler Handlers) HandleSavedSearch(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserIDFromContext(r.Context())
+	if !ok {
+		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	searchID := strings.TrimSpace(r.PathValue("id"))
+
+	switch r.Method {
+	case http.MethodGet:
+		search, err := handler.SavedSearches.Get(r.Context(), userID, searchID)
+		if err != nil {
+			writeSavedSearchError(w, err)
+			return
+		}
+		functions.WriteJSON(w, http.StatusOK, search)
+	case http.MethodPut:
+		params, ok := handler.decodeParams(w, r)
+		if !ok {
+			return
+		}
+
+		search, err := handler.SavedSearches.Update(r.Context(), userID, searchID, params)
+		if err != nil {
+			writeSavedSearchError(w, err)
+			return
+		}
+		functions.WriteJSON(w, http.StatusOK, search)
+	case http.MethodDelete:
+		if err := handler.SavedSearches.Delete(r.Context(), userID, searchID); err != nil {
+			writeSavedSearchError(w, err)
+			return
+		}
+		functions.WriteJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+	default:
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// decodeParams reads and validates a saved search body, it writes the error response itself
+func (handler Handlers) decodeParams(w http.ResponseWriter, r *http.Request) (saved_searches.Params, bool) {
+	var req savedSearchReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		functions.WriteError(w, http.StatusBadRequest, "invalid json")
+		return saved_searches.Params{}, false
+	}
+
+	filters := req.Filters
+	filters.City = strings.TrimSpace(filters.City)
+	filters.Province = strings.TrimSpace(filters.Province)
+	filters.Amenities = amenities.NormalizeKeys(filters.Amenities)
+
+	fields := make(map[string]string)
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		fields["name"] = "is required"
+	} else if len(name) > 100 {
+		fields["name"] = "must be at most 100 characters"
+	}
+
+	if filters.PriceMin != nil && *filters.PriceMin < 0 {
+		fields["filters.priceMin"] = "must be 0 or more"
+	}
+	if filters.PriceMax != nil && *filters.PriceMax < 0 {
+		fields["filters.priceMax"] = "must be 0 or more"
+	}
+	if filters.PriceMin != nil && filters.PriceMax != nil && *filters.PriceMax < *filters.PriceMin {
+		fields["filters.priceMax"] = "must be greater than or equal to priceMin"
+	}
+	if filters.BedroomsMin != nil && *filters.BedroomsMin < 0 {
+		fields["filters.bedroomsMin"] = "must be 0 or more"
+	}
+	if filters.BathroomsMin != nil && *filters.BathroomsMin < 0 {
+		fields["filters.bathroomsMin"] = "must be 0 or more"
+	}
+
+	if len(fields) == 0 {
+		if err := handler.Amenities.ValidateKeys(r.Context(), filters.Amenities); err != nil {
+			var unknown amenities.UnknownAmenityError
+			if !errors.As(err, &unknown) {
+				functions.WriteError(w, http.StatusInternalServerError, "could not validate amenities")
+				return saved_searches.Params{}, false
+			}
+			fields["filters.amenities"] = unknown.Error()
+		}
+	}
+
+	if len(fields) > 0 {
+		functions.WriteFieldErrors(w, fields)
+		return saved_searches.Params{}, false
+	}
+
+	params := saved_searches.Params{
+		Name:        name,
+		Filters:     filters,
+		NotifyInApp: true,
+	}
+	if req.NotifyInApp != nil {
+		params.NotifyInApp = *req.NotifyInApp
+	}
+	if req.EmailDigest != nil {
+		params.EmailDigest = *req.EmailDigest
+	}
+
+	return params, true
+}
+
+func writeSavedSearchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, saved_searches.ErrSavedSearchNotFound):
+		functions.WriteError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, saved_searches.ErrTooManySearches):
+		functions.WriteError(w, http.StatusConflict, err.Error())
+	default:
+		functions.WriteError(w, http.StatusInternalServerError, "saved search request failed")
+	}
+}
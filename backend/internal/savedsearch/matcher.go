@@ -0,0 +1,133 @@
+package savedsearch
+
+import (
+	"context"
+	"fmt"
+	"go-react-rooms/internal/repositories/amenities"
+	"go-react-rooms/internal/repositories/listings"
+	"go-react-rooms/internal/repositories/notifications"
+	"go-react-rooms/internal/repositories/saved_searches"
+	"log"
+	"strings"
+)
+
+const NotificationSearchMatch = "saved_search.match"
+
+// Matcher alerts users whose saved searches match a listing that just became active
+type Matcher struct {
+	SavedSearches saved_searches.Repo
+	Amenities     amenities.Repo
+	Notifications notifications.Repo
+}
+
+// ListingActivated records the matches for a listing and sends the in-app alerts,
+// email digests are sent later by DigestJob. Failures are logged, never returned,
+// so publishing a listing does not depend on alerts
+func (matcher Matcher) ListingActivated(ctx context.Context, listing listings.Listing) {
+	if listing.Status != listings.StatusActive {
+		return
+	}
+
+	candidates, err := matcher.SavedSearches.ListMatchCandidates(ctx, listing.UserID, listing.City, listing.Province, listing.Price)
+	if err != nil {
+		log.Printf("saved search alerts: list candidates for %s: %v", listing.ID, err)
+		return
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	keys, err := matcher.Amenities.KeysForListings(ctx, []string{listing.ID})
+	if err != nil {
+		log.Printf("saved search alerts: load amenities of %s: %v", listing.ID, err)
+		return
+	}
+
+	byID := make(map[string]saved_searches.SavedSearch)
+	var matchedIDs []string
+	for _, search := range candidates {
+		if Matches(search.Filters, listing, keys[listing.ID]) {
+			byID[search.ID] = search
+			matchedIDs = append(matchedIDs, search.ID)
+		}
+	}
+
+	recorded, err := matcher.SavedSearches.RecordMatches(ctx, listing.ID, matchedIDs)
+	if err != nil {
+		log.Printf("saved search alerts: record matches for %s: %v", listing.ID, err)
+		return
+	}
+
+	// a user with several matching searches gets a single alert
+	alerted := make(map[string]bool)
+	for _, searchID := range recorded {
+		search := byID[searchID]
+		if !search.NotifyInApp || alerted[search.UserID] {
+			continue
+		}
+		alerted[search.UserID] = true
+
+		body := fmt.Sprintf("“%s” in %s matches your saved search “%s”.", listing.Title, listing.City, search.Name)
+		_, err := matcher.Notifications.Insert(ctx, notifications.InsertParams{
+			UserID: search.UserID,
+			Type:   NotificationSearchMatch,
+			Title:  "New listing for your saved search",
+			Body:   &body,
+			Data: map[string]any{
+				"savedSearchId": search.ID,
+				"listingId":     listing.ID,
+			},
+		})
+		if err != nil {
+			log.Printf("saved search alerts: notify %s: %v", search.UserID, err)
+		}
+	}
+}
+
+// Matches reports whether a listing satisfies every filter of a saved search
+func Matches(filters saved_searches.Filters, listing listings.Listing, listingAmenities []string) bool {
+	if filters.City != "" && !strings.EqualFold(filters.City, listing.City) {
+		return false
+	}
+	if filters.Province != "" && !strings.EqualFold(filters.Province, listing.Province) {
+		return false
+	}
+	if filters.PriceMin != nil && listing.Price < *filters.PriceMin {
+		return false
+	}
+	if filters.PriceMax != nil && listing.Price > *filters.PriceMax {
+		return false
+	}
+	if filters.BedroomsMin != nil && listing.Bedrooms < *filters.BedroomsMin {
+		return false
+	}
+	if filters.BathroomsMin != nil && listing.Bathrooms < *filters.BathroomsMin {
+		return false
+	}
+	if filters.IsFurnished != nil && listing.IsFurnished != *filters.IsFurnished {
+		return false
+	}
+	if filters.PetsAllowed != nil && listing.PetsAllowed != *filters.PetsAllowed {
+		return false
+	}
+	if filters.SmokingAllowed != nil && listing.SmokingAllowed != *filters.SmokingAllowed {
+		return false
+	}
+	if filters.ParkingAvailable != nil && listing.ParkingAvailable != *filters.ParkingAvailable {
+		return false
+	}
+
+	if len(filters.Amenities) > 0 {
+		have := make(map[string]bool, len(listingAmenities))
+		for _, key := range listingAmenities {
+			have[key] = true
+		}
+		for _, key := range filters.Amenities {
+			if !have[key] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,208 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Job is a periodic task, Run receives a context that is cancelled when the scheduler stops
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Scheduler runs jobs on every API replica but uses a Redis lock per job and tick,
+// so each run happens on exactly one replica. The lock is held for as long as the run lasts,
+// runs never overlap across replicas
+type Scheduler struct {
+	Redis     *redis.Client
+	KeyPrefix string
+	Jobs      []Job
+}
+
+func NewScheduler(rdb *redis.Client, jobs ...Job) *Scheduler {
+	return &Scheduler{
+		Redis:     rdb,
+		KeyPrefix: "scheduler:lock:",
+		Jobs:      jobs,
+	}
+}
+
+// Run starts one loop per job and blocks until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	done := make(chan struct{}, len(s.Jobs))
+
+	for _, job := range s.Jobs {
+		go func(job Job) {
+			s.loop(ctx, job)
+			done <- struct{}{}
+		}(job)
+	}
+
+	for range s.Jobs {
+		<-done
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.runOnce(ctx, job)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, job Job) {
+	lock, acquired, err := s.acquire(ctx, job)
+	if err != nil {
+		log.Printf("scheduler: %s: lock: %v", job.Name, err)
+		return
+	}
+	if !acquired {
+		return
+	}
+
+	// the run stops if another replica could have taken the lock over
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopRenewing := s.renew(runCtx, cancel, job, lock)
+
+	started := time.Now()
+	err = job.Run(runCtx)
+	stopRenewing()
+	s.release(job, lock, started)
+
+	if err != nil {
+		log.Printf("scheduler: %s: %v", job.Name, err)
+		return
+	}
+	log.Printf("scheduler: %s done in %s", job.Name, time.Since(started).Round(time.Millisecond))
+}
+
+// jobLock is a held job lock, token tells this replica's lock apart from one taken after it expired
+type jobLock struct {
+	key   string
+	token string
+	ttl   time.Duration
+}
+
+// renewScript extends the lock only while it still holds our token
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript drops the lock if it still holds our token, or when ARGV[2] is set keeps it until
+// that unix time in milliseconds
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if ARGV[2] ~= "0" then
+	return redis.call("PEXPIREAT", KEYS[1], ARGV[2])
+end
+return redis.call("DEL", KEYS[1])
+`)
+
+// acquire takes the job lock for slightly less than one interval, it is renewed while the job runs
+func (s *Scheduler) acquire(ctx context.Context, job Job) (jobLock, bool, error) {
+	ttl := job.Interval - job.Interval/10
+	if ttl <= 0 {
+		ttl = job.Interval
+	}
+
+	token, err := newLockToken()
+	if err != nil {
+		return jobLock{}, false, err
+	}
+	lock := jobLock{key: s.KeyPrefix + job.Name, token: token, ttl: ttl}
+
+	lockCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	acquired, err := s.Redis.SetNX(lockCtx, lock.key, lock.token, lock.ttl).Result()
+	return lock, acquired, err
+}
+
+// renew keeps extending the lock while the job runs and cancels the run once the lock is lost,
+// the returned func stops renewing
+func (s *Scheduler) renew(ctx context.Context, cancelRun context.CancelFunc, job Job, lock jobLock) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(lock.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			renewCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			renewed, err := renewScript.Run(renewCtx, s.Redis, []string{lock.key}, lock.token, lock.ttl.Milliseconds()).Int()
+			cancel()
+			if err != nil {
+				// a failed renewal is retried, the lock still has two thirds of its ttl left
+				log.Printf("scheduler: %s: renew lock: %v", job.Name, err)
+				continue
+			}
+			if renewed == 0 {
+				log.Printf("scheduler: %s: lock lost, stopping the run", job.Name)
+				cancelRun()
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// release gives the lock back once the job is done. A run shorter than the lock ttl keeps it until
+// started+ttl, so replicas ticking later in the same interval still skip it
+func (s *Scheduler) release(job Job, lock jobLock, started time.Time) {
+	var keepUntil int64
+	if until := started.Add(lock.ttl); time.Now().Before(until) {
+		keepUntil = until.UnixMilli()
+	}
+
+	// the run context may be cancelled already, release regardless
+	releaseCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := releaseScript.Run(releaseCtx, s.Redis, []string{lock.key}, lock.token, keepUntil).Err(); err != nil && !errors.Is(err, redis.Nil) {
+		log.Printf("scheduler: %s: release lock: %v", job.Name, err)
+	}
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go-react-rooms/internal/functions"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalRoutePrefix is where the API serves local objects, app.New mounts LocalStorage there
+const LocalRoutePrefix = "/storage/"
+
+const defaultMaxUploadSize = 10 << 20
+
+var ErrInvalidKey = errors.New("invalid object key")
+var ErrObjectTooLarge = errors.New("object is too large")
+
+// LocalStorage keeps objects on disk under Root and serves them from the API itself
+// through HMAC signed, expiring URLs that behave like S3 presigned URLs
+type LocalStorage struct {
+	Root string
+	// base URL of the API as seen by clients, e.g. http://localhost:8080
+	PublicURL     string
+	MaxUploadSize int64
+	secret        []byte
+}
+
+func NewLocalStorage(root string, publicURL string, secret string, maxUploadSize int64) (*LocalStorage, error) {
+	if root == "" {
+		return nil, fmt.Errorf("STORAGE_LOCAL_DIR is required for the local storage driver")
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("STORAGE_SIGNING_SECRET is required for the local storage driver")
+	}
+	if maxUploadSize <= 0 {
+		maxUploadSize = defaultMaxUploadSize
+	}
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("resolve storage dir: %w", err)
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("create storage dir: %w", err)
+	}
+
+	return &LocalStorage{
+		Root:          root,
+		PublicURL:     strings.TrimRight(publicURL, "/"),
+		MaxUploadSize: maxUploadSize,
+		secret:        []byte(secret),
+	}, nil
+}
+
+// path maps a key to a file under Root, rejecting keys that could escape it
+func (storage *LocalStorage) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, `\`) || path.Clean(key) != key {
+		return "", ErrInvalidKey
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." || segment == "." {
+			return "", ErrInvalidKey
+		}
+	}
+	return filepath.Join(storage.Root, filepath.FromSlash(key)), nil
+}
+
+func (storage *LocalStorage) Put(ctx context.Context, key string, contentType string, body io.Reader, size int64) error {
+	target, err := storage.path(key)
+	if err != nil {
+		return err
+	}
+	if size > storage.MaxUploadSize {
+		return ErrObjectTooLarge
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("create object dir: %w", err)
+	}
+
+	// write to a temp file first so readers never see a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("create object: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	written, err := io.Copy(tmp, io.LimitReader(body, storage.MaxUploadSize+1))
+	closeErr := tmp.Close()
+	if err != nil {
+		return fmt.Errorf("write object: %w", err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("write object: %w", closeErr)
+	}
+	if written > storage.MaxUploadSize {
+		return ErrObjectTooLarge
+	}
+
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("store object: %w", err)
+	}
+	return nil
+}
+
+func (storage *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	target, err := storage.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(target)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("get object: %w", err)
+	}
+	return file, nil
+}
+
+// Delete removes an object, deleting a missing key is not an error (same as S3)
+func (storage *LocalStorage) Delete(ctx context.Context, key string) error {
+	target, err := storage.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("delete object: %w", err)
+	}
+	return nil
+}
+
+// Head stats an object, the content type is sniffed from the stored bytes since the disk keeps no metadata
+func (storage *LocalStorage) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	target, err := storage.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	file, err := os.Open(target)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, fmt.Errorf("head object: %w", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("head object: %w", err)
+	}
+	if stat.IsDir() {
+		return ObjectInfo{}, ErrObjectNotFound
+	}
+
+	buffer := make([]byte, 512)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return ObjectInfo{}, fmt.Errorf("head object: %w", err)
+	}
+
+	return ObjectInfo{
+		Key:          key,
+		Size:         stat.Size(),
+		ContentType:  http.DetectContentType(buffer[:n]),
+		LastModified: stat.ModTime(),
+	}, nil
+}
+
+// List walks the directory of prefix, a prefix that is not a whole directory path also matches file names
+func (storage *LocalStorage) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	dir := storage.Root
+	if slash := strings.LastIndex(prefix, "/"); slash >= 0 {
+		dir = filepath.Join(storage.Root, filepath.FromSlash(prefix[:slash]))
+	}
+	if !strings.HasPrefix(dir, storage.Root) {
+		return ErrInvalidKey
+	}
+
+	err := filepath.WalkDir(dir, func(target string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(storage.Root, target)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		stat, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// deleted while walking
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		return fn(ObjectInfo{
+			Key:          key,
+			Size:         stat.Size(),
+			LastModified: stat.ModTime(),
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("list objects: %w", err)
+	}
+	return nil
+}
+
+func (storage *LocalStorage) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return storage.presign(http.MethodGet, key, "", expires)
+}
+
+func (storage *LocalStorage) PresignPut(ctx context.Context, key string, contentType string, expires time.Duration) (string, error) {
+	return storage.presign(http.MethodPut, key, contentType, expires)
+}
+
+func (storage *LocalStorage) presign(method string, key string, contentType string, expires time.Duration) (string, error) {
+	if _, err := storage.path(key); err != nil {
+		return "", err
+	}
+
+	expiresAt := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+
+	query := url.Values{}
+	query.Set("expires", expiresAt)
+	if contentType != "" {
+		query.Set("contentType", contentType)
+	}
+	query.Set("signature", storage.sign(method, key, contentType, expiresAt))
+
+	return storage.PublicURL + LocalRoutePrefix + (&url.URL{Path: key}).EscapedPath() + "?" + query.Encode(), nil
+}
+
+func (storage *LocalStorage) sign(method string, key string, contentType string, expiresAt string) string {
+	mac := hmac.New(sha256.New, storage.secret)
+	mac.Write([]byte(method + "\n" + key + "\n" + contentType + "\n" + expiresAt))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (storage *LocalStorage) verify(r *http.Request, method string, key string) bool {
+	query := r.URL.Query()
+	expiresAt := query.Get("expires")
+
+	unix, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+
+	expected := storage.sign(method, key, query.Get("contentType"), expiresAt)
+	return hmac.Equal([]byte(expected), []byte(query.Get("signature")))
+}
+
+// ServeHTTP serves GET/HEAD downloads and PUT uploads on LocalRoutePrefix{key...}
+func (storage *LocalStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !storage.verify(r, http.MethodGet, key) {
+			functions.WriteError(w, http.StatusForbidden, "invalid or expired signature")
+			return
+		}
+
+		target, err := storage.path(key)
+		if err != nil {
+			functions.WriteError(w, http.StatusNotFound, ErrObjectNotFound.Error())
+			return
+		}
+		file, err := os.Open(target)
+		if err != nil {
+			functions.WriteError(w, http.StatusNotFound, ErrObjectNotFound.Error())
+			return
+		}
+		defer file.Close()
+
+		stat, err := file.Stat()
+		if err != nil || stat.IsDir() {
+			functions.WriteError(w, http.StatusNotFound, ErrObjectNotFound.Error())
+			return
+		}
+
+		w.Header().Set("Cache-Control", "private, max-age=300")
+		http.ServeContent(w, r, stat.Name(), stat.ModTime(), file)
+	case http.MethodPut:
+		if !storage.verify(r, http.MethodPut, key) {
+			functions.WriteError(w, http.StatusForbidden, "invalid or expired signature")
+			return
+		}
+
+		contentType := r.URL.Query().Get("contentType")
+		if contentType != "" && r.Header.Get("Content-Type") != contentType {
+			functions.WriteError(w, http.StatusBadRequest, "Content-Type does not match the signed upload")
+			return
+		}
+
+		body := http.MaxBytesReader(w, r.Body, storage.MaxUploadSize)
+		if err := storage.Put(r.Context(), key, contentType, body, r.ContentLength); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			switch {
+			case errors.Is(err, ErrObjectTooLarge), errors.As(err, &maxBytesErr):
+				functions.WriteError(w, http.StatusRequestEntityTooLarge, ErrObjectTooLarge.Error())
+			case errors.Is(err, ErrInvalidKey):
+				functions.WriteError(w, http.StatusBadRequest, err.Error())
+			default:
+				functions.WriteError(w, http.StatusInternalServerError, "failed to store object")
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	default:
+		functions.WriteError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -1,15 +1,19 @@
 package storage
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	config2 "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/uuid"
 )
 
@@ -33,7 +37,7 @@ var allowedImageTypes = map[string]bool{
 func NewS3Storage(ctx context.Context) (*S3Storage, error) {
 	region := os.Getenv("AWS_REGION")
 	if region == "" {
-		return nil, fmt.Errorf("AWS_REGION is required " + region)
+		return nil, fmt.Errorf("AWS_REGION is required")
 	}
 
 	bucket := os.Getenv("AWS_S3_BUCKET")
@@ -59,7 +63,9 @@ func IsAllowedImageType(contentType string) bool {
 	return allowedImageTypes[contentType]
 }
 
-func BuildListingImageKey(ListingID string, contentType string) (string, error) {
+// BuildListingUploadKey names an object the client uploads directly, it is processed into variants
+// and deleted once the upload is completed
+func BuildListingUploadKey(listingID string, contentType string) (string, error) {
 	if !IsAllowedImageType(contentType) {
 		return "", fmt.Errorf("unsupported content type: %s", contentType)
 	}
@@ -77,37 +83,107 @@ func BuildListingImageKey(ListingID string, contentType string) (string, error)
 	}
 
 	id := uuid.NewString()
-	return fmt.Sprintf("listings/%s/images/%s%s", ListingID, id, ext), nil
+	return fmt.Sprintf("listings/%s/uploads/%s%s", listingID, id, ext), nil
 }
 
-func (storage *S3Storage) CreatePresignedImageUploadURL(ctx context.Context, ListingID string, contentType string) (*PresignedUpload, error) {
-	key, err := BuildListingImageKey(ListingID, contentType)
+func BuildListingImageVariantKey(listingID string, imageID string, variant string) string {
+	return fmt.Sprintf("listings/%s/images/%s/%s", listingID, imageID, variant)
+}
+
+func (storage *S3Storage) Put(ctx context.Context, key string, contentType string, body io.Reader, size int64) error {
+	_, err := storage.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(storage.Bucket),
+		Key:           aws.String(key),
+		Body:          body,
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	})
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("put object: %w", err)
 	}
+	return nil
+}
 
-	presigner := s3.NewPresignClient(storage.Client)
+func (storage *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := storage.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(storage.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("get object: %w", err)
+	}
+	return out.Body, nil
+}
 
-	req, err := presigner.PresignPutObject(
-		ctx,
-		&s3.PutObjectInput{
-			Bucket:      aws.String(storage.Bucket),
-			Key:         aws.String(key),
-			ContentType: aws.String(contentType),
-		},
-		s3.WithPresignExpires(15*time.Minute),
-	)
+func (storage *S3Storage) Delete(ctx context.Context, key string) error {
+	_, err := storage.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(storage.Bucket),
+		Key:    aws.String(key),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("presign put object: %w", err)
+		return fmt.Errorf("delete object: %w", err)
 	}
+	return nil
+}
 
-	return &PresignedUpload{
-		URL: req.URL,
-		Key: key,
-	}, nil
+func (storage *S3Storage) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	out, err := storage.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(storage.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		var responseErr *awshttp.ResponseError
+		if errors.As(err, &notFound) || (errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusNotFound) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, fmt.Errorf("head object: %w", err)
+	}
+
+	info := ObjectInfo{
+		Key:         key,
+		Size:        aws.ToInt64(out.ContentLength),
+		ContentType: aws.ToString(out.ContentType),
+	}
+	if out.LastModified != nil {
+		info.LastModified = *out.LastModified
+	}
+	return info, nil
 }
 
-func (storage *S3Storage) CreatePresignedGetURL(ctx context.Context, key string) (string, error) {
+func (storage *S3Storage) List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error {
+	paginator := s3.NewListObjectsV2Paginator(storage.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(storage.Bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("list objects: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			info := ObjectInfo{
+				Key:  aws.ToString(object.Key),
+				Size: aws.ToInt64(object.Size),
+			}
+			if object.LastModified != nil {
+				info.LastModified = *object.LastModified
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (storage *S3Storage) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
 	presigner := s3.NewPresignClient(storage.Client)
 
 	req, err := presigner.PresignGetObject(
@@ -116,7 +192,7 @@ func (storage *S3Storage) CreatePresignedGetURL(ctx context.Context, key string)
 			Bucket: aws.String(storage.Bucket),
 			Key:    aws.String(key),
 		},
-		s3.WithPresignExpires(15*time.Minute),
+		s3.WithPresignExpires(expires),
 	)
 	if err != nil {
 		return "", fmt.Errorf("presign get object: %w", err)
@@ -125,32 +201,21 @@ func (storage *S3Storage) CreatePresignedGetURL(ctx context.Context, key string)
 	return req.URL, nil
 }
 
-func (storage *S3Storage) UploadListingImage(ctx context.Context, listingID string, contentType string, data []byte) (string, error) {
-	key, err := BuildListingImageKey(listingID, contentType)
-	if err != nil {
-		return "", err
-	}
+func (storage *S3Storage) PresignPut(ctx context.Context, key string, contentType string, expires time.Duration) (string, error) {
+	presigner := s3.NewPresignClient(storage.Client)
 
-	_, err = storage.Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:      aws.String(storage.Bucket),
-		Key:         aws.String(key),
-		Body:        bytes.NewReader(data),
-		ContentType: aws.String(contentType),
-	})
+	req, err := presigner.PresignPutObject(
+		ctx,
+		&s3.PutObjectInput{
+			Bucket:      aws.String(storage.Bucket),
+			Key:         aws.String(key),
+			ContentType: aws.String(contentType),
+		},
+		s3.WithPresignExpires(expires),
+	)
 	if err != nil {
-		return "", fmt.Errorf("put object: %w", err)
+		return "", fmt.Errorf("presign put object: %w", err)
 	}
 
-	return key, nil
-}
-
-func (storage *S3Storage) DeleteObject(ctx context.Context, key string) error {
-	_, err := storage.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
-		Bucket: aws.String(storage.Bucket),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		return fmt.Errorf("delete object: %w", err)
-	}
-	return nil
+	return req.URL, nil
 }
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	DriverS3    = "s3"
+	DriverLocal = "local"
+)
+
+// DefaultPresignExpiry is how long presigned GET and PUT URLs stay valid
+const DefaultPresignExpiry = 15 * time.Minute
+
+var ErrObjectNotFound = errors.New("object not found")
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// ObjectStore is the blob storage used for listing images, keys are slash separated paths
+type ObjectStore interface {
+	Put(ctx context.Context, key string, contentType string, body io.Reader, size int64) error
+	// Get returns ErrObjectNotFound when the key does not exist, the caller closes the reader
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// Head returns ErrObjectNotFound when the key does not exist
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+	// PresignPut returns a URL the client uploads to with PUT and the same Content-Type header
+	PresignPut(ctx context.Context, key string, contentType string, expires time.Duration) (string, error)
+	// List calls fn for every object whose key starts with prefix, ContentType is not filled in.
+	// An error from fn stops the listing and is returned
+	List(ctx context.Context, prefix string, fn func(ObjectInfo) error) error
+}
+
+type Options struct {
+	Driver string
+	// local driver
+	LocalDir      string
+	PublicURL     string
+	SigningSecret string
+	MaxUploadSize int64
+}
+
+// NewObjectStore builds the store selected by opts.Driver, S3 settings come from the AWS environment
+func NewObjectStore(ctx context.Context, opts Options) (ObjectStore, error) {
+	switch opts.Driver {
+	case "", DriverS3:
+		return NewS3Storage(ctx)
+	case DriverLocal:
+		return NewLocalStorage(opts.LocalDir, opts.PublicURL, opts.SigningSecret, opts.MaxUploadSize)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", opts.Driver)
+	}
+}
+
+// CreatePresignedImageUploadURL reserves a new listing upload key and presigns an upload to it
+func CreatePresignedImageUploadURL(ctx context.Context, store ObjectStore, listingID string, contentType string) (*PresignedUpload, error) {
+	key, err := BuildListingUploadKey(listingID, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := store.PresignPut(ctx, key, contentType, DefaultPresignExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PresignedUpload{
+		URL: url,
+		Key: key,
+	}, nil
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrUploadSlotNotFound = errors.New("upload slot not found or expired")
+
+// UploadSlot is a presigned upload that was handed out to a client and not completed yet
+type UploadSlot struct {
+	Key         string `json:"key"`
+	ListingID   string `json:"listingId"`
+	UserID      string `json:"userId"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+// UploadSlots keeps pending slots in redis, a slot outlives its presigned URL a little
+// so an upload that finishes right before the URL expires can still be completed
+type UploadSlots struct {
+	Redis     *redis.Client
+	TTL       time.Duration
+	KeyPrefix string
+}
+
+func NewUploadSlots(rdb *redis.Client) *UploadSlots {
+	return &UploadSlots{
+		Redis:     rdb,
+		TTL:       DefaultPresignExpiry + 5*time.Minute,
+		KeyPrefix: "upload_slot:",
+	}
+}
+
+func (slots *UploadSlots) Save(ctx context.Context, slot UploadSlot) error {
+	value, err := json.Marshal(slot)
+	if err != nil {
+		return err
+	}
+	return slots.Redis.Set(ctx, slots.KeyPrefix+slot.Key, value, slots.TTL).Err()
+}
+
+func (slots *UploadSlots) Get(ctx context.Context, key string) (UploadSlot, error) {
+	value, err := slots.Redis.Get(ctx, slots.KeyPrefix+key).Bytes()
+	if err == redis.Nil {
+		return UploadSlot{}, ErrUploadSlotNotFound
+	}
+	if err != nil {
+		return UploadSlot{}, err
+	}
+
+	var slot UploadSlot
+	if err := json.Unmarshal(value, &slot); err != nil {
+		return UploadSlot{}, err
+	}
+	return slot, nil
+}
+
+// Claim takes the slot out of redis and returns it, of two concurrent claims on a key only one gets
+// the slot. A claim that does not lead to an image gives the slot back with Save
+func (slots *UploadSlots) Claim(ctx context.Context, key string) (UploadSlot, error) {
+	value, err := slots.Redis.GetDel(ctx, slots.KeyPrefix+key).Bytes()
+	if err == redis.Nil {
+		return UploadSlot{}, ErrUploadSlotNotFound
+	}
+	if err != nil {
+		return UploadSlot{}, err
+	}
+
+	var slot UploadSlot
+	if err := json.Unmarshal(value, &slot); err != nil {
+		return UploadSlot{}, err
+	}
+	return slot, nil
+}
+
+func (slots *UploadSlots) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = slots.KeyPrefix + key
+	}
+	return slots.Redis.Del(ctx, prefixed...).Err()
+}
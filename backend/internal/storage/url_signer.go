@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SignedURLExpiry is how long the GET URLs handed out by URLSigner stay valid
+const SignedURLExpiry = time.Hour
+
+// URLSigner turns object keys into URLs clients can load. Presigned URLs are cached in redis
+// and reused until RefreshBefore ahead of their expiry, so a feed does not presign every
+// thumbnail on every request and repeat visits get the same URL (and the browser cache).
+// With PublicBaseURL set, objects are served from that base (a CDN) and nothing is presigned
+type URLSigner struct {
+	Store         ObjectStore
+	Redis         *redis.Client
+	Expiry        time.Duration
+	RefreshBefore time.Duration
+	PublicBaseURL string
+	KeyPrefix     string
+}
+
+func NewURLSigner(store ObjectStore, rdb *redis.Client, publicBaseURL string) *URLSigner {
+	return &URLSigner{
+		Store:         store,
+		Redis:         rdb,
+		Expiry:        SignedURLExpiry,
+		RefreshBefore: 15 * time.Minute,
+		PublicBaseURL: strings.TrimRight(publicBaseURL, "/"),
+		KeyPrefix:     "signed_url:",
+	}
+}
+
+// Sign returns the URL of one object
+func (signer *URLSigner) Sign(ctx context.Context, key string) (string, bool) {
+	signed, ok := signer.SignMany(ctx, []string{key})[key]
+	return signed, ok
+}
+
+// SignMany returns the URL of every key it could sign, a key missing from the result failed
+// and the caller leaves that image out. Redis problems only cost the cache, never the URLs
+func (signer *URLSigner) SignMany(ctx context.Context, keys []string) map[string]string {
+	unique := make([]string, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+
+	urls := make(map[string]string, len(unique))
+	if len(unique) == 0 {
+		return urls
+	}
+
+	if signer.PublicBaseURL != "" {
+		for _, key := range unique {
+			urls[key] = signer.PublicBaseURL + "/" + (&url.URL{Path: key}).EscapedPath()
+		}
+		return urls
+	}
+
+	missing := unique
+	if signer.Redis != nil {
+		missing = signer.cached(ctx, unique, urls)
+	}
+	if len(missing) == 0 {
+		return urls
+	}
+
+	fresh := make(map[string]string, len(missing))
+	for _, key := range missing {
+		signed, err := signer.Store.PresignGet(ctx, key, signer.Expiry)
+		if err != nil {
+			log.Printf("url signer: presign %s: %v", key, err)
+			continue
+		}
+		urls[key] = signed
+		fresh[key] = signed
+	}
+
+	if signer.Redis != nil && len(fresh) > 0 {
+		pipe := signer.Redis.Pipeline()
+		for key, signed := range fresh {
+			pipe.Set(ctx, signer.KeyPrefix+key, signed, signer.Expiry-signer.RefreshBefore)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("url signer: cache %d urls: %v", len(fresh), err)
+		}
+	}
+
+	return urls
+}
+
+// cached fills urls from redis in one round trip and returns the keys it did not find
+func (signer *URLSigner) cached(ctx context.Context, keys []string, urls map[string]string) []string {
+	cacheKeys := make([]string, len(keys))
+	for i, key := range keys {
+		cacheKeys[i] = signer.KeyPrefix + key
+	}
+
+	values, err := signer.Redis.MGet(ctx, cacheKeys...).Result()
+	if err != nil {
+		log.Printf("url signer: read cache: %v", err)
+		return keys
+	}
+
+	var missing []string
+	for i, key := range keys {
+		if cachedURL, ok := values[i].(string); ok && cachedURL != "" {
+			urls[key] = cachedURL
+			continue
+		}
+		missing = append(missing, key)
+	}
+	return missing
+}
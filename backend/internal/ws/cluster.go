@@ -0,0 +1,98 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// With several API replicas behind a load balancer the members of a room are spread over nodes.
+// Every node delivers a broadcast to its own clients right away and publishes it on the room's
+// redis channel, the other nodes pick it up there and deliver it to theirs
+const (
+	roomChannelPrefix = "ws:room:"
+	// cluster wide commands that are not about one room, such as dropping a suspended user
+	controlChannel = "ws:control"
+	publishTimeout = 2 * time.Second
+	// how long a delivered message id is remembered to drop duplicates
+	dedupWindow = 2 * time.Minute
+)
+
+// clusterMsg is what nodes publish to each other
+type clusterMsg struct {
+	// the publishing node, it already delivered the message locally and skips its own publish
+	Node string    `json:"node"`
+	Room string    `json:"room,omitempty"`
+	Msg  *Envelope `json:"msg,omitempty"`
+	// user whose connections every node closes
+	Disconnect string `json:"disconnect,omitempty"`
+}
+
+// publish is best effort, when redis is down clients on this node still get the message
+func (hub *Hub) publish(channel string, message clusterMsg) {
+	if hub.redis == nil {
+		return
+	}
+
+	message.Node = hub.nodeID
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("ws cluster: encode message for %s: %v", channel, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := hub.redis.Publish(ctx, channel, data).Err(); err != nil {
+		log.Printf("ws cluster: publish to %s: %v", channel, err)
+	}
+}
+
+// listen feeds messages published by other nodes into the hub, go-redis resubscribes on its own
+// after a lost connection
+func (hub *Hub) listen(pubsub *redis.PubSub) {
+	for message := range pubsub.Channel() {
+		var decoded clusterMsg
+		if err := json.Unmarshal([]byte(message.Payload), &decoded); err != nil {
+			log.Printf("ws cluster: decode message from %s: %v", message.Channel, err)
+			continue
+		}
+		if decoded.Node == hub.nodeID {
+			continue
+		}
+
+		switch {
+		case decoded.Disconnect != "":
+			hub.disconnect <- decoded.Disconnect
+		case decoded.Msg != nil && decoded.Room != "":
+			hub.broadcast <- broadcastMsg{room: decoded.Room, msg: *decoded.Msg}
+		}
+	}
+}
+
+// isDuplicate remembers envelopes that carry a message id and reports the ones already delivered.
+// The type, timestamp and sender are part of the key so later events about the same message, such as
+// the read receipts of different members, still go out
+func (hub *Hub) isDuplicate(msg Envelope, now time.Time) bool {
+	if msg.MessageID == "" {
+		return false
+	}
+
+	key := msg.Type + ":" + msg.MessageID + ":" + msg.TS + ":" + msg.From
+	if _, ok := hub.seen[key]; ok {
+		return true
+	}
+	hub.seen[key] = now
+	return false
+}
+
+func (hub *Hub) pruneSeen(now time.Time) {
+	for key, deliveredAt := range hub.seen {
+		if now.Sub(deliveredAt) > dedupWindow {
+			delete(hub.seen, key)
+		}
+	}
+}
@@ -3,14 +3,17 @@ package ws
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"go-react-rooms/internal/auth"
 	"go-react-rooms/internal/functions"
 	"go-react-rooms/internal/repositories/messages"
 	"go-react-rooms/internal/repositories/rooms"
+	"log"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -18,11 +21,12 @@ type Handler struct {
 	Upgrader websocket.Upgrader
 	Hub      *Hub
 	Sessions *auth.SessionStore
+	Presence *Presence
 	Messages messages.Repo
 	Rooms    rooms.Repo
 }
 
-func NewHandler(hub *Hub, sessions *auth.SessionStore, roomsRepo rooms.Repo, msgRepo messages.Repo) *Handler {
+func NewHandler(hub *Hub, sessions *auth.SessionStore, presence *Presence, roomsRepo rooms.Repo, msgRepo messages.Repo) *Handler {
 	return &Handler{
 		Upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
@@ -35,6 +39,7 @@ func NewHandler(hub *Hub, sessions *auth.SessionStore, roomsRepo rooms.Repo, msg
 		},
 		Hub:      hub,
 		Sessions: sessions,
+		Presence: presence,
 		Rooms:    roomsRepo,
 		Messages: msgRepo,
 	}
@@ -54,6 +59,10 @@ func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 
 	userID, err := handler.Sessions.Get(ctx, sessionID)
+	if errors.Is(err, auth.ErrUserSuspended) {
+		functions.WriteError(w, http.StatusForbidden, err.Error())
+		return
+	}
 	if err != nil || userID == "" {
 		functions.WriteError(w, http.StatusUnauthorized, "unauthorized")
 		return
@@ -70,6 +79,8 @@ func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Send:       make(chan Envelope, 16),
 		ActiveRoom: "",
 		Rooms:      make(map[string]struct{}),
+		connID:     uuid.NewString(),
+		conn:       conn,
 	}
 
 	handler.Hub.register <- client
@@ -81,6 +92,10 @@ func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	handler.connected(client)
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+	go handler.heartbeat(heartbeatCtx, client)
+
 	//	start writer in bg
 	go writer(conn, client)
 
@@ -88,6 +103,9 @@ func (handler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	reader(conn, handler, client)
 
 	//	cleanup
+	stopHeartbeat()
+	handler.stopAllTyping(client)
+	handler.disconnected(client)
 	handler.Hub.unregister <- client
 	_ = conn.Close()
 }
@@ -136,6 +154,51 @@ func reader(conn *websocket.Conn, handler *Handler, client *Client) {
 			handler.Hub.Subscribe(client, room)
 
 			client.ActiveRoom = room
+			handler.sendRoomPresence(client, room)
+
+		case "typing.start", "typing.stop":
+			room := strings.TrimSpace(envelope.Room)
+			if room == "" {
+				room = client.ActiveRoom
+			}
+			if _, ok := client.Rooms[room]; !ok {
+				sendErr(client, "not a member")
+				continue
+			}
+
+			if envelope.Type == "typing.start" {
+				handler.startTyping(client, room)
+			} else {
+				handler.stopTyping(client, room)
+			}
+
+		case "read":
+			room := strings.TrimSpace(envelope.Room)
+			if room == "" {
+				room = client.ActiveRoom
+			}
+			if _, ok := client.Rooms[room]; !ok {
+				sendErr(client, "not a member")
+				continue
+			}
+			messageID := strings.TrimSpace(envelope.MessageID)
+			if messageID == "" {
+				sendErr(client, "messageId required")
+				continue
+			}
+
+			state, advanced, err := handler.Rooms.MarkRead(context.Background(), room, client.UserID, messageID)
+			if err != nil {
+				if errors.Is(err, messages.ErrMessageNotFound) {
+					sendErr(client, err.Error())
+				} else {
+					sendErr(client, "could not save read position")
+				}
+				continue
+			}
+			if advanced {
+				handler.Hub.Broadcast(room, ReadReceipt(state))
+			}
 
 		case "message":
 			room := strings.TrimSpace(envelope.Room)
@@ -159,12 +222,28 @@ func reader(conn *websocket.Conn, handler *Handler, client *Client) {
 				continue
 			}
 
-			// persist message in the DB
-			message, err := handler.Messages.Insert(context.Background(), room, client.UserID, text)
+			// persist message in the DB, replies also bump the parent's reply count
+			replyToID := strings.TrimSpace(envelope.ReplyToID)
+			var message messages.Message
+			var replyCount int
+			if replyToID == "" {
+				message, err = handler.Messages.Insert(context.Background(), room, client.UserID, text)
+			} else {
+				message, replyCount, err = handler.Messages.InsertReply(context.Background(), room, client.UserID, text, replyToID)
+			}
 			if err != nil {
-				sendErr(client, "could not save message")
+				switch {
+				case errors.Is(err, messages.ErrMessageNotFound):
+					sendErr(client, "reply target not found")
+				case errors.Is(err, messages.ErrMessageDeleted):
+					sendErr(client, "reply target was deleted")
+				default:
+					sendErr(client, "could not save message")
+				}
 				continue
 			}
+			// sending ends typing, clients do not have to send typing.stop first
+			handler.stopTyping(client, room)
 			// broadcast persisted message
 			handler.Hub.Broadcast(room, Envelope{
 				Type:       "message",
@@ -174,7 +253,11 @@ func reader(conn *websocket.Conn, handler *Handler, client *Client) {
 				MessageID:  message.ID,
 				TS:         message.CreatedAt.UTC().Format(time.RFC3339),
 				SenderName: message.SenderName,
+				ReplyToID:  replyToID,
 			})
+			if replyToID != "" {
+				handler.Hub.Broadcast(room, ThreadUpdated(room, replyToID, replyCount, message))
+			}
 			//	ack sender
 			if envelope.ClientMsgID != "" {
 				client.Send <- Envelope{
@@ -210,9 +293,144 @@ func writer(conn *websocket.Conn, client *Client) {
 	}
 }
 
+// ReadReceipt tells a room how far a member has read, the other members' tabs and the member's own
+// tabs move their markers with it
+func ReadReceipt(state rooms.ReadState) Envelope {
+	return Envelope{
+		Type:      "read",
+		Room:      state.RoomID,
+		From:      state.UserID,
+		MessageID: state.MessageID,
+		TS:        state.ReadAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// MessageUpdated carries the new body of an edited message, TS is the time of the edit with
+// nanoseconds so two quick edits are not dropped as duplicates of each other
+func MessageUpdated(message messages.Message) Envelope {
+	envelope := Envelope{
+		Type:       "message.updated",
+		Room:       message.RoomID,
+		Text:       message.Body,
+		From:       message.SenderID,
+		MessageID:  message.ID,
+		SenderName: message.SenderName,
+	}
+	if message.EditedAt != nil {
+		envelope.TS = message.EditedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return envelope
+}
+
+// ThreadUpdated carries the new reply count of a message after a reply was added, TS is the time of the
+// reply with nanoseconds so quick replies are not dropped as duplicates of each other.
+// It is only sent for new replies since reply counts never go down
+func ThreadUpdated(room string, parentID string, replyCount int, reply messages.Message) Envelope {
+	return Envelope{
+		Type:       "thread.updated",
+		Room:       room,
+		MessageID:  parentID,
+		TS:         reply.CreatedAt.UTC().Format(time.RFC3339Nano),
+		ReplyCount: replyCount,
+	}
+}
+
+// MessageDeleted tells clients to show a message as a tombstone, TS is the time of the deletion
+func MessageDeleted(message messages.Message) Envelope {
+	envelope := Envelope{
+		Type:      "message.deleted",
+		Room:      message.RoomID,
+		From:      message.SenderID,
+		MessageID: message.ID,
+	}
+	if message.DeletedAt != nil {
+		envelope.TS = message.DeletedAt.UTC().Format(time.RFC3339)
+	}
+	return envelope
+}
+
 func sendErr(client *Client, msg string) {
 	select {
 	case client.Send <- Envelope{Type: "error", Error: msg}:
 	default:
 	}
 }
+
+// connected records the connection in Presence and, when it is the user's first, tells their rooms
+func (handler *Handler) connected(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	cameOnline, err := handler.Presence.Connect(ctx, client.UserID, client.connID)
+	if err != nil {
+		log.Printf("ws presence: connect %s: %v", client.UserID, err)
+		return
+	}
+	if cameOnline {
+		handler.broadcastPresence(client, PresenceState{UserID: client.UserID, Online: true})
+	}
+}
+
+// disconnected removes the connection from Presence and, when it was the user's last, tells their rooms
+func (handler *Handler) disconnected(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	wentOffline, lastSeen, err := handler.Presence.Disconnect(ctx, client.UserID, client.connID)
+	if err != nil {
+		log.Printf("ws presence: disconnect %s: %v", client.UserID, err)
+		return
+	}
+	if wentOffline {
+		handler.broadcastPresence(client, PresenceState{UserID: client.UserID, Online: false, LastSeen: lastSeen})
+	}
+}
+
+func (handler *Handler) broadcastPresence(client *Client, state PresenceState) {
+	for room := range client.Rooms {
+		handler.Hub.Broadcast(room, Envelope{
+			Type:     "presence",
+			Room:     room,
+			Presence: []PresenceState{state},
+		})
+	}
+}
+
+// heartbeat keeps the connection counted as online until ctx is cancelled
+func (handler *Handler) heartbeat(ctx context.Context, client *Client) {
+	ticker := time.NewTicker(presenceHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := handler.Presence.Heartbeat(ctx, client.UserID, client.connID); err != nil && ctx.Err() == nil {
+				log.Printf("ws presence: heartbeat %s: %v", client.UserID, err)
+			}
+		}
+	}
+}
+
+// sendRoomPresence sends a client that joins a room the presence of every member
+func (handler *Handler) sendRoomPresence(client *Client, room string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	memberIDs, err := handler.Rooms.ListMemberIDs(ctx, room)
+	if err != nil {
+		log.Printf("ws presence: list members of %s: %v", room, err)
+		return
+	}
+	states, err := handler.Presence.States(ctx, memberIDs)
+	if err != nil {
+		log.Printf("ws presence: load states of %s: %v", room, err)
+		return
+	}
+
+	select {
+	case client.Send <- Envelope{Type: "presence", Room: room, Presence: states}:
+	default:
+	}
+}
@@ -1,5 +1,14 @@
 package ws
 
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
 type Envelope struct {
 	Type        string `json:"type"`
 	Room        string `json:"room,omitempty"`
@@ -10,6 +19,14 @@ type Envelope struct {
 	TS          string `json:"ts,omitempty"`
 	Error       string `json:"error,omitempty"`
 	SenderName  string `json:"senderName,omitempty"`
+	// typing.start: clients drop the indicator after this unless it is refreshed
+	ExpiresAt string `json:"expiresAt,omitempty"`
+	// presence: online state of room members
+	Presence []PresenceState `json:"presence,omitempty"`
+	// message: the message this one replies to
+	ReplyToID string `json:"replyToId,omitempty"`
+	// thread.updated: replies ever posted to MessageID, deleted ones included
+	ReplyCount int `json:"replyCount,omitempty"`
 }
 
 type Client struct {
@@ -17,6 +34,11 @@ type Client struct {
 	Send       chan Envelope
 	ActiveRoom string
 	Rooms      map[string]struct{}
+	// identifies this connection among the user's tabs and devices in Presence
+	connID string
+	typing typingState
+	// closed by the hub to drop the client, its reader then exits and unregisters it
+	conn *websocket.Conn
 }
 
 type broadcastMsg struct {
@@ -30,15 +52,26 @@ type Hub struct {
 	register   chan *Client
 	unregister chan *Client
 	broadcast  chan broadcastMsg
+	disconnect chan string
+	// message ids delivered within dedupWindow, only touched by Run
+	seen map[string]time.Time
+	// fans broadcasts out to the other nodes, nil keeps the hub local to this process
+	redis  *redis.Client
+	nodeID string
 }
 
-func NewHub() *Hub {
+// NewHub creates a hub, with rdb set it shares broadcasts with the hubs of the other nodes
+func NewHub(rdb *redis.Client) *Hub {
 	return &Hub{
 		clients:    make(map[*Client]struct{}),
 		byRoom:     make(map[string]map[*Client]struct{}),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		broadcast:  make(chan broadcastMsg, 64),
+		disconnect: make(chan string, 16),
+		seen:       make(map[string]time.Time),
+		redis:      rdb,
+		nodeID:     uuid.NewString(),
 	}
 }
 
@@ -70,6 +103,15 @@ func (hub *Hub) UnsubscribeAll(client *Client) {
 }
 
 func (hub *Hub) Run() {
+	if hub.redis != nil {
+		pubsub := hub.redis.PSubscribe(context.Background(), roomChannelPrefix+"*", controlChannel)
+		defer pubsub.Close()
+		go hub.listen(pubsub)
+	}
+
+	pruneTicker := time.NewTicker(dedupWindow)
+	defer pruneTicker.Stop()
+
 	for {
 		select {
 		case client := <-hub.register:
@@ -82,14 +124,25 @@ func (hub *Hub) Run() {
 				close(client.Send)
 			}
 
+		case userID := <-hub.disconnect:
+			for client := range hub.clients {
+				if client.UserID == userID {
+					hub.drop(client)
+				}
+			}
+
+		case now := <-pruneTicker.C:
+			hub.pruneSeen(now)
+
 		case broadcast := <-hub.broadcast:
+			if hub.isDuplicate(broadcast.msg, time.Now()) {
+				continue
+			}
 			for client := range hub.byRoom[broadcast.room] {
 				select {
 				case client.Send <- broadcast.msg:
 				default:
-					delete(hub.clients, client)
-					hub.UnsubscribeAll(client)
-					close(client.Send)
+					hub.drop(client)
 				}
 
 			}
@@ -97,9 +150,26 @@ func (hub *Hub) Run() {
 	}
 }
 
+// drop stops delivering to a client and closes its connection. Send stays open because the client's
+// reader may still write to it, unregister closes it once the reader has exited
+func (hub *Hub) drop(client *Client) {
+	hub.UnsubscribeAll(client)
+	if client.conn != nil {
+		_ = client.conn.Close()
+	}
+}
+
+// Broadcast sends msg to the room's clients on this node and on every other node
 func (hub *Hub) Broadcast(room string, msg Envelope) {
 	hub.broadcast <- broadcastMsg{
 		room: room,
 		msg:  msg,
 	}
+	hub.publish(roomChannelPrefix+room, clusterMsg{Room: room, Msg: &msg})
+}
+
+// DisconnectUser closes every connection of a user on every node, used when the user is suspended
+func (hub *Hub) DisconnectUser(userID string) {
+	hub.disconnect <- userID
+	hub.publish(controlChannel, clusterMsg{Disconnect: userID})
 }
@@ -0,0 +1,130 @@
+package ws
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// how often a connection refreshes its presence entry
+	presenceHeartbeat = 30 * time.Second
+	// last seen times are dropped for users who stay away this long
+	lastSeenTTL = 30 * 24 * time.Hour
+)
+
+// PresenceState is sent in presence envelopes, LastSeen is only set for offline users
+type PresenceState struct {
+	UserID   string `json:"userId"`
+	Online   bool   `json:"online"`
+	LastSeen string `json:"lastSeen,omitempty"`
+}
+
+// Presence tracks who is connected in redis so every replica sees the same state. Each connection
+// (a tab, a device) is a member of the user's sorted set scored with the time it expires, so the
+// connections of a node that dies without cleaning up age out after TTL
+type Presence struct {
+	Redis     *redis.Client
+	TTL       time.Duration
+	KeyPrefix string
+}
+
+func NewPresence(rdb *redis.Client) *Presence {
+	return &Presence{
+		Redis:     rdb,
+		TTL:       3 * presenceHeartbeat,
+		KeyPrefix: "presence:",
+	}
+}
+
+func (presence *Presence) connectionsKey(userID string) string {
+	return presence.KeyPrefix + "conns:" + userID
+}
+
+func (presence *Presence) lastSeenKey(userID string) string {
+	return presence.KeyPrefix + "last_seen:" + userID
+}
+
+// Connect records a new connection and reports whether the user just came online, that is when
+// it is new and the user had no live connection before it. Both are read in the MULTI that adds it,
+// so of several connections opened at once exactly one sees the user come online
+func (presence *Presence) Connect(ctx context.Context, userID string, connID string) (bool, error) {
+	liveBefore, added, err := presence.touch(ctx, userID, connID)
+	if err != nil {
+		return false, err
+	}
+	return liveBefore == 0 && added, nil
+}
+
+// Heartbeat keeps a connection from expiring
+func (presence *Presence) Heartbeat(ctx context.Context, userID string, connID string) error {
+	_, _, err := presence.touch(ctx, userID, connID)
+	return err
+}
+
+// touch adds or refreshes a connection after dropping expired ones, it returns how many
+// connections were live before and whether this one is new
+func (presence *Presence) touch(ctx context.Context, userID string, connID string) (int64, bool, error) {
+	now := time.Now()
+	key := presence.connectionsKey(userID)
+
+	pipe := presence.Redis.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+	liveBefore := pipe.ZCard(ctx, key)
+	added := pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Add(presence.TTL).UnixMilli()), Member: connID})
+	pipe.Expire(ctx, key, presence.TTL)
+	pipe.Set(ctx, presence.lastSeenKey(userID), now.UTC().Format(time.RFC3339), lastSeenTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, false, err
+	}
+	return liveBefore.Val(), added.Val() == 1, nil
+}
+
+// Disconnect removes a connection and reports whether the user has no live connection left,
+// lastSeen is the time to show for them
+func (presence *Presence) Disconnect(ctx context.Context, userID string, connID string) (bool, string, error) {
+	now := time.Now()
+	key := presence.connectionsKey(userID)
+	lastSeen := now.UTC().Format(time.RFC3339)
+
+	pipe := presence.Redis.TxPipeline()
+	pipe.ZRem(ctx, key, connID)
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+	live := pipe.ZCard(ctx, key)
+	pipe.Set(ctx, presence.lastSeenKey(userID), lastSeen, lastSeenTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, "", err
+	}
+	return live.Val() == 0, lastSeen, nil
+}
+
+// States returns the presence of each user in one round trip
+func (presence *Presence) States(ctx context.Context, userIDs []string) ([]PresenceState, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	pipe := presence.Redis.Pipeline()
+	live := make([]*redis.IntCmd, len(userIDs))
+	lastSeen := make([]*redis.StringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		live[i] = pipe.ZCount(ctx, presence.connectionsKey(userID), "("+now, "+inf")
+		lastSeen[i] = pipe.Get(ctx, presence.lastSeenKey(userID))
+	}
+	// a user never seen has no last_seen key, redis.Nil is expected there
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	states := make([]PresenceState, len(userIDs))
+	for i, userID := range userIDs {
+		states[i] = PresenceState{
+			UserID: userID,
+			Online: live[i].Val() > 0,
+		}
+		if !states[i].Online {
+			states[i].LastSeen = lastSeen[i].Val()
+		}
+	}
+	return states, nil
+}
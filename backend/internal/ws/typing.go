@@ -0,0 +1,111 @@
+package ws
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// a client sending typing.start on every keystroke is forwarded at most this often
+	typingThrottle = 3 * time.Second
+	// typing stops on its own when the client goes quiet for this long, clients refresh before it
+	typingTTL = 6 * time.Second
+)
+
+// typingState is what a client is typing in, keyed by room. The expiry timers fire on their own
+// goroutines so the state is guarded by mu
+type typingState struct {
+	mu    sync.Mutex
+	rooms map[string]*roomTyping
+}
+
+type roomTyping struct {
+	sentAt time.Time
+	expiry *time.Timer
+	// bumped on every start so an expiry that fires late for an older start is ignored
+	generation int
+}
+
+// startTyping tells the room the client is typing and (re)arms the automatic stop
+func (handler *Handler) startTyping(client *Client, room string) {
+	now := time.Now()
+
+	client.typing.mu.Lock()
+	if client.typing.rooms == nil {
+		client.typing.rooms = make(map[string]*roomTyping)
+	}
+	state := client.typing.rooms[room]
+	if state == nil {
+		state = &roomTyping{}
+		client.typing.rooms[room] = state
+	}
+	if state.expiry != nil {
+		state.expiry.Stop()
+	}
+	state.generation++
+	generation := state.generation
+	state.expiry = time.AfterFunc(typingTTL, func() {
+		handler.expireTyping(client, room, generation)
+	})
+	throttled := now.Sub(state.sentAt) < typingThrottle
+	if !throttled {
+		state.sentAt = now
+	}
+	client.typing.mu.Unlock()
+
+	if throttled {
+		return
+	}
+	handler.Hub.Broadcast(room, Envelope{
+		Type:      "typing.start",
+		Room:      room,
+		From:      client.UserID,
+		ExpiresAt: now.Add(typingTTL).UTC().Format(time.RFC3339),
+	})
+}
+
+// stopTyping clears the indicator, it does nothing when the client is not typing in the room
+func (handler *Handler) stopTyping(client *Client, room string) {
+	client.typing.mu.Lock()
+	state, ok := client.typing.rooms[room]
+	if ok {
+		state.expiry.Stop()
+		delete(client.typing.rooms, room)
+	}
+	client.typing.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	handler.Hub.Broadcast(room, Envelope{
+		Type: "typing.stop",
+		Room: room,
+		From: client.UserID,
+	})
+}
+
+// expireTyping stops typing unless the client started typing again after the timer was armed
+func (handler *Handler) expireTyping(client *Client, room string, generation int) {
+	client.typing.mu.Lock()
+	state, ok := client.typing.rooms[room]
+	current := ok && state.generation == generation
+	client.typing.mu.Unlock()
+
+	if current {
+		handler.stopTyping(client, room)
+	}
+}
+
+// stopAllTyping runs when the connection closes
+func (handler *Handler) stopAllTyping(client *Client) {
+	client.typing.mu.Lock()
+	rooms := make([]string, 0, len(client.typing.rooms))
+	for room := range client.typing.rooms {
+		rooms = append(rooms, room)
+	}
+	client.typing.mu.Unlock()
+
+	for _, room := range rooms {
+		handler.stopTyping(client, room)
+	}
+}
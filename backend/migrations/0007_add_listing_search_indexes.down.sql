@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_listings_price_id;
+DROP INDEX IF EXISTS idx_listings_created_id;
@@ -0,0 +1,3 @@
+-- keyset pagination for listing search (newest first / by price)
+CREATE INDEX IF NOT EXISTS idx_listings_created_id ON listings(created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_listings_price_id ON listings(price, id);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_listings_lat_lng;
@@ -0,0 +1,2 @@
+-- bounding box prefilter for map (radius / viewport) queries
+CREATE INDEX IF NOT EXISTS idx_listings_lat_lng ON listings(latitude, longitude);
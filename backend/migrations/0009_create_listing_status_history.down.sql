@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS listing_status_history;
+
+ALTER TABLE listings ALTER COLUMN status SET DEFAULT 'active';
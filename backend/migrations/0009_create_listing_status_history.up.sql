@@ -0,0 +1,15 @@
+-- new listings start as drafts and are published explicitly
+ALTER TABLE listings ALTER COLUMN status SET DEFAULT 'draft';
+
+CREATE TABLE IF NOT EXISTS listing_status_history (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    listing_id uuid NOT NULL REFERENCES listings(id) ON DELETE CASCADE,
+    from_status text,
+    to_status text NOT NULL,
+    -- NULL when the change was made by the system (e.g. expiry job)
+    changed_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_listing_status_history_listing_created
+    ON listing_status_history(listing_id, created_at DESC);
@@ -0,0 +1,3 @@
+ALTER TABLE listings DROP COLUMN IF EXISTS renewal_reminded_at;
+
+DROP TABLE IF EXISTS notifications;
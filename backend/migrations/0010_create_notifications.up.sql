@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS notifications (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type text NOT NULL,
+    title text NOT NULL,
+    body text,
+    data jsonb NOT NULL DEFAULT '{}'::jsonb,
+    read_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_created
+    ON notifications(user_id, created_at DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_unread
+    ON notifications(user_id) WHERE read_at IS NULL;
+
+-- last time the owner was reminded to renew the listing, reset by renewing/editing it (updated_at moves past it)
+ALTER TABLE listings ADD COLUMN IF NOT EXISTS renewal_reminded_at timestamptz;
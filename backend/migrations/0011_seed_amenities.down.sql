@@ -0,0 +1,6 @@
+DELETE FROM amenities WHERE key IN (
+    'in_unit_laundry', 'shared_laundry', 'dishwasher', 'microwave', 'oven', 'refrigerator',
+    'air_conditioning', 'heating', 'fireplace', 'wifi', 'utilities_included', 'balcony', 'patio',
+    'backyard', 'gym', 'pool', 'elevator', 'concierge', 'storage_locker', 'bike_storage',
+    'ev_charging', 'wheelchair_accessible'
+);
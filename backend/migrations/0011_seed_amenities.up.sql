@@ -0,0 +1,24 @@
+INSERT INTO amenities (key, label, category) VALUES
+    ('in_unit_laundry', 'In-unit laundry', 'laundry'),
+    ('shared_laundry', 'Shared laundry', 'laundry'),
+    ('dishwasher', 'Dishwasher', 'kitchen'),
+    ('microwave', 'Microwave', 'kitchen'),
+    ('oven', 'Oven', 'kitchen'),
+    ('refrigerator', 'Refrigerator', 'kitchen'),
+    ('air_conditioning', 'Air conditioning', 'comfort'),
+    ('heating', 'Heating', 'comfort'),
+    ('fireplace', 'Fireplace', 'comfort'),
+    ('wifi', 'Wi-Fi', 'utilities'),
+    ('utilities_included', 'Utilities included', 'utilities'),
+    ('balcony', 'Balcony', 'outdoor'),
+    ('patio', 'Patio', 'outdoor'),
+    ('backyard', 'Backyard', 'outdoor'),
+    ('gym', 'Gym', 'building'),
+    ('pool', 'Pool', 'building'),
+    ('elevator', 'Elevator', 'building'),
+    ('concierge', 'Concierge', 'building'),
+    ('storage_locker', 'Storage locker', 'building'),
+    ('bike_storage', 'Bike storage', 'building'),
+    ('ev_charging', 'EV charging', 'parking'),
+    ('wheelchair_accessible', 'Wheelchair accessible', 'accessibility')
+ON CONFLICT (key) DO NOTHING;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_contact_requests_recipient_created;
+DROP INDEX IF EXISTS uniq_contact_requests_open_per_sender;
+
+ALTER TABLE contact_requests DROP COLUMN IF EXISTS room_id;
@@ -0,0 +1,10 @@
+-- private chat room created when the owner accepts the request
+ALTER TABLE contact_requests
+    ADD COLUMN IF NOT EXISTS room_id uuid REFERENCES rooms(id) ON DELETE SET NULL;
+
+-- a seeker can only have one open request per listing
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_contact_requests_open_per_sender
+    ON contact_requests(listing_id, sender_user_id) WHERE status = 'open';
+
+CREATE INDEX IF NOT EXISTS idx_contact_requests_recipient_created
+    ON contact_requests(recipient_user_id, created_at DESC);
@@ -0,0 +1,7 @@
+ALTER TABLE roommate_preferences
+    DROP CONSTRAINT IF EXISTS roommate_preferences_budget_range_check,
+    DROP CONSTRAINT IF EXISTS roommate_preferences_age_range_check,
+    ADD CONSTRAINT roommate_preferences_check
+        CHECK (budget_max IS NULL OR budget_min IS NULL OR budget_max >= budget_min),
+    ADD CONSTRAINT roommate_preferences_check1
+        CHECK (max_age IS NULL OR min_age IS NULL OR max_age >= min_age);
@@ -0,0 +1,10 @@
+-- give the table level range checks stable names so violations can be reported per field
+ALTER TABLE roommate_preferences
+    DROP CONSTRAINT IF EXISTS roommate_preferences_check,
+    DROP CONSTRAINT IF EXISTS roommate_preferences_check1,
+    DROP CONSTRAINT IF EXISTS roommate_preferences_budget_range_check,
+    DROP CONSTRAINT IF EXISTS roommate_preferences_age_range_check,
+    ADD CONSTRAINT roommate_preferences_budget_range_check
+        CHECK (budget_max IS NULL OR budget_min IS NULL OR budget_max >= budget_min),
+    ADD CONSTRAINT roommate_preferences_age_range_check
+        CHECK (max_age IS NULL OR min_age IS NULL OR max_age >= min_age);
@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS idx_listings_city_trgm;
+DROP INDEX IF EXISTS idx_listings_title_trgm;
+DROP INDEX IF EXISTS idx_listings_search_vector;
+
+DROP TRIGGER IF EXISTS trg_amenities_search_vector ON amenities;
+DROP TRIGGER IF EXISTS trg_listing_amenities_search_vector ON listing_amenities;
+DROP TRIGGER IF EXISTS trg_listings_search_vector ON listings;
+
+DROP FUNCTION IF EXISTS amenities_search_vector_trigger();
+DROP FUNCTION IF EXISTS listing_amenities_search_vector_trigger();
+DROP FUNCTION IF EXISTS listings_search_vector_trigger();
+DROP FUNCTION IF EXISTS listing_amenity_labels(uuid);
+DROP FUNCTION IF EXISTS listing_search_document(text, text, text, text, text, text, text);
+
+ALTER TABLE listings DROP COLUMN IF EXISTS search_vector;
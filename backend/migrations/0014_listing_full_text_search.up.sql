@@ -0,0 +1,110 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE listings ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+-- weights: A title, B city and province, C description and amenity labels, D street address
+CREATE OR REPLACE FUNCTION listing_search_document(
+    p_title text,
+    p_description text,
+    p_city text,
+    p_province text,
+    p_address_line1 text,
+    p_address_line2 text,
+    p_amenity_labels text
+) RETURNS tsvector
+LANGUAGE sql IMMUTABLE AS $$
+    SELECT
+        setweight(to_tsvector('english', coalesce(p_title, '')), 'A') ||
+        setweight(to_tsvector('english', coalesce(p_city, '') || ' ' || coalesce(p_province, '')), 'B') ||
+        setweight(to_tsvector('english', coalesce(p_description, '') || ' ' || coalesce(p_amenity_labels, '')), 'C') ||
+        setweight(to_tsvector('english', coalesce(p_address_line1, '') || ' ' || coalesce(p_address_line2, '')), 'D')
+$$;
+
+CREATE OR REPLACE FUNCTION listing_amenity_labels(p_listing_id uuid) RETURNS text
+LANGUAGE sql STABLE AS $$
+    SELECT string_agg(a.label, ' ')
+    FROM listing_amenities la
+    JOIN amenities a ON a.id = la.amenity_id
+    WHERE la.listing_id = p_listing_id
+$$;
+
+CREATE OR REPLACE FUNCTION listings_search_vector_trigger() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+    NEW.search_vector := listing_search_document(
+        NEW.title,
+        NEW.description,
+        NEW.city,
+        NEW.province,
+        NEW.address_line1,
+        NEW.address_line2,
+        listing_amenity_labels(NEW.id)
+    );
+    RETURN NEW;
+END
+$$;
+
+DROP TRIGGER IF EXISTS trg_listings_search_vector ON listings;
+CREATE TRIGGER trg_listings_search_vector
+    BEFORE INSERT OR UPDATE OF title, description, city, province, address_line1, address_line2
+    ON listings
+    FOR EACH ROW EXECUTE FUNCTION listings_search_vector_trigger();
+
+-- amenity changes do not touch the listing row, so they refresh the vector themselves
+CREATE OR REPLACE FUNCTION listing_amenities_search_vector_trigger() RETURNS trigger
+LANGUAGE plpgsql AS $$
+DECLARE
+    changed_listing_id uuid;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed_listing_id := OLD.listing_id;
+    ELSE
+        changed_listing_id := NEW.listing_id;
+    END IF;
+
+    UPDATE listings l
+    SET search_vector = listing_search_document(
+        l.title, l.description, l.city, l.province, l.address_line1, l.address_line2,
+        listing_amenity_labels(l.id)
+    )
+    WHERE l.id = changed_listing_id;
+
+    RETURN NULL;
+END
+$$;
+
+DROP TRIGGER IF EXISTS trg_listing_amenities_search_vector ON listing_amenities;
+CREATE TRIGGER trg_listing_amenities_search_vector
+    AFTER INSERT OR DELETE ON listing_amenities
+    FOR EACH ROW EXECUTE FUNCTION listing_amenities_search_vector_trigger();
+
+CREATE OR REPLACE FUNCTION amenities_search_vector_trigger() RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE listings l
+    SET search_vector = listing_search_document(
+        l.title, l.description, l.city, l.province, l.address_line1, l.address_line2,
+        listing_amenity_labels(l.id)
+    )
+    WHERE l.id IN (SELECT la.listing_id FROM listing_amenities la WHERE la.amenity_id = NEW.id);
+
+    RETURN NULL;
+END
+$$;
+
+DROP TRIGGER IF EXISTS trg_amenities_search_vector ON amenities;
+CREATE TRIGGER trg_amenities_search_vector
+    AFTER UPDATE OF label ON amenities
+    FOR EACH ROW EXECUTE FUNCTION amenities_search_vector_trigger();
+
+UPDATE listings l
+SET search_vector = listing_search_document(
+    l.title, l.description, l.city, l.province, l.address_line1, l.address_line2,
+    listing_amenity_labels(l.id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_listings_search_vector ON listings USING gin (search_vector);
+
+-- typo tolerant fallback when full-text search finds nothing
+CREATE INDEX IF NOT EXISTS idx_listings_title_trgm ON listings USING gin (title gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_listings_city_trgm ON listings USING gin (city gin_trgm_ops);
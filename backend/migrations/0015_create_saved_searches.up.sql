@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS saved_searches (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name text NOT NULL,
+    -- same keys as the /listings query parameters, absent keys do not filter
+    filters jsonb NOT NULL DEFAULT '{}'::jsonb,
+    notify_in_app boolean NOT NULL DEFAULT true,
+    email_digest boolean NOT NULL DEFAULT false,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_saved_searches_user_created
+    ON saved_searches(user_id, created_at DESC);
+
+-- one row per listing a saved search was alerted about, it keeps a listing that is
+-- republished from alerting twice and queues it for the email digest
+CREATE TABLE IF NOT EXISTS saved_search_matches (
+    saved_search_id uuid NOT NULL REFERENCES saved_searches(id) ON DELETE CASCADE,
+    listing_id uuid NOT NULL REFERENCES listings(id) ON DELETE CASCADE,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    digested_at timestamptz,
+    PRIMARY KEY (saved_search_id, listing_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_saved_search_matches_undigested
+    ON saved_search_matches(created_at) WHERE digested_at IS NULL;
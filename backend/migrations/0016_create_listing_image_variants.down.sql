@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS listing_image_variants;
+
+ALTER TABLE listing_images
+    DROP COLUMN IF EXISTS height,
+    DROP COLUMN IF EXISTS width;
@@ -0,0 +1,17 @@
+-- dimensions of the full variant, null for images stored before processing existed
+ALTER TABLE listing_images
+    ADD COLUMN IF NOT EXISTS width integer CHECK (width > 0),
+    ADD COLUMN IF NOT EXISTS height integer CHECK (height > 0);
+
+-- resized copies of a listing image, s3_key on listing_images points at the full variant
+CREATE TABLE IF NOT EXISTS listing_image_variants (
+    image_id uuid NOT NULL REFERENCES listing_images(id) ON DELETE CASCADE,
+    variant text NOT NULL CHECK (variant IN ('thumbnail', 'card', 'full')),
+    s3_key text NOT NULL,
+    content_type text NOT NULL,
+    width integer NOT NULL CHECK (width > 0),
+    height integer NOT NULL CHECK (height > 0),
+    size_bytes bigint NOT NULL CHECK (size_bytes >= 0),
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (image_id, variant)
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_listing_image_variants_s3_key;
+DROP INDEX IF EXISTS idx_listing_images_s3_key;
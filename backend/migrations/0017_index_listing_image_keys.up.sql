@@ -0,0 +1,3 @@
+-- the object garbage collector looks up stored keys to find orphaned objects
+CREATE INDEX IF NOT EXISTS idx_listing_images_s3_key ON listing_images(s3_key);
+CREATE INDEX IF NOT EXISTS idx_listing_image_variants_s3_key ON listing_image_variants(s3_key);
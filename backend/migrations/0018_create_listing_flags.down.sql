@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS listing_flags;
+ALTER TABLE listing_images DROP COLUMN IF EXISTS phash;
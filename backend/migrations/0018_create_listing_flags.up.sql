@@ -0,0 +1,24 @@
+-- 64 bit difference hash of the image, compared by hamming distance to find reused photos
+ALTER TABLE listing_images
+    ADD COLUMN IF NOT EXISTS phash bigint;
+
+-- the moderation queue, a listing with an open flag can not go live until it is resolved
+CREATE TABLE IF NOT EXISTS listing_flags (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    listing_id uuid NOT NULL REFERENCES listings(id) ON DELETE CASCADE,
+    reason text NOT NULL CHECK (reason IN ('duplicate_photos')),
+    status text NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'dismissed', 'confirmed')),
+    -- what triggered the flag, for duplicate_photos the matched images
+    details jsonb NOT NULL DEFAULT '[]'::jsonb,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    resolved_at timestamptz,
+    resolved_by uuid REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- new matches are merged into the open flag instead of queueing the listing twice
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_listing_flags_open
+    ON listing_flags(listing_id, reason) WHERE status = 'open';
+
+CREATE INDEX IF NOT EXISTS idx_listing_flags_status_created
+    ON listing_flags(status, created_at);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS reports;
+DELETE FROM listing_flags WHERE reason = 'reported';
+ALTER TABLE listing_flags DROP CONSTRAINT IF EXISTS listing_flags_reason_check;
+ALTER TABLE listing_flags
+    ADD CONSTRAINT listing_flags_reason_check CHECK (reason IN ('duplicate_photos'));
+ALTER TABLE users
+    DROP COLUMN IF EXISTS suspended_at,
+    DROP COLUMN IF EXISTS is_admin;
@@ -0,0 +1,34 @@
+-- admins work the moderation queue, suspended users can not sign in or use their sessions
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS is_admin boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS suspended_at timestamptz;
+
+-- a moderator hiding a reported listing holds it with a flag so the owner can not republish it
+ALTER TABLE listing_flags DROP CONSTRAINT IF EXISTS listing_flags_reason_check;
+ALTER TABLE listing_flags
+    ADD CONSTRAINT listing_flags_reason_check CHECK (reason IN ('duplicate_photos', 'reported'));
+
+CREATE TABLE IF NOT EXISTS reports (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    reporter_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    target_type text NOT NULL CHECK (target_type IN ('listing', 'message', 'user')),
+    -- no foreign key, a report is kept after the listing, message or user it is about is gone
+    target_id uuid NOT NULL,
+    reason text NOT NULL CHECK (reason IN ('spam', 'scam', 'inappropriate', 'harassment', 'other')),
+    details text,
+    status text NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'dismissed', 'actioned')),
+    action text CHECK (action IN ('dismiss', 'hide_listing', 'delete_message', 'suspend_user')),
+    created_at timestamptz NOT NULL DEFAULT now(),
+    resolved_at timestamptz,
+    resolved_by uuid REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- a user reports the same thing once until a moderator has looked at it
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_reports_open_per_reporter
+    ON reports(reporter_id, target_type, target_id) WHERE status = 'open';
+
+CREATE INDEX IF NOT EXISTS idx_reports_status_created
+    ON reports(status, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_reports_target
+    ON reports(target_type, target_id);
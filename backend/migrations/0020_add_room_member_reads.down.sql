@@ -0,0 +1,4 @@
+ALTER TABLE room_members
+    DROP COLUMN IF EXISTS last_read_at,
+    DROP COLUMN IF EXISTS last_read_message_at,
+    DROP COLUMN IF EXISTS last_read_message_id;
@@ -0,0 +1,6 @@
+-- how far each member has read, the message id and its created_at so the unread count is a range
+-- over idx_messages_room_created_id. No foreign key: a deleted message keeps the position it marked
+ALTER TABLE room_members
+    ADD COLUMN IF NOT EXISTS last_read_message_id uuid,
+    ADD COLUMN IF NOT EXISTS last_read_message_at timestamptz,
+    ADD COLUMN IF NOT EXISTS last_read_at timestamptz;
@@ -0,0 +1,7 @@
+ALTER TABLE room_members
+    DROP COLUMN IF EXISTS role;
+DROP TABLE IF EXISTS message_edits;
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS deleted_by,
+    DROP COLUMN IF EXISTS deleted_at,
+    DROP COLUMN IF EXISTS edited_at;
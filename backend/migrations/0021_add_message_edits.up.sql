@@ -0,0 +1,27 @@
+-- edited and soft deleted messages, deleted bodies stay for moderation but are not served to members
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS edited_at timestamptz,
+    ADD COLUMN IF NOT EXISTS deleted_at timestamptz,
+    ADD COLUMN IF NOT EXISTS deleted_by uuid REFERENCES users(id) ON DELETE SET NULL;
+
+-- one row per edit with the body it replaced
+CREATE TABLE IF NOT EXISTS message_edits (
+    id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
+    message_id uuid NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    body text NOT NULL,
+    edited_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    edited_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_edits_message_edited
+    ON message_edits (message_id, edited_at DESC);
+
+-- owners and moderators can delete any message in their room
+ALTER TABLE room_members
+    ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'member'
+        CHECK (role IN ('owner', 'moderator', 'member'));
+
+UPDATE room_members m
+SET role = 'owner'
+FROM rooms r
+WHERE r.id = m.room_id AND r.created_by = m.user_id;
@@ -0,0 +1,9 @@
+-- replies quote their parent, reply_count is kept on the parent so room history does not count per row
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS reply_to_id uuid REFERENCES messages(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS reply_count integer NOT NULL DEFAULT 0;
+
+-- For thread views: latest replies to a message
+CREATE INDEX IF NOT EXISTS idx_messages_reply_created_id
+    ON messages (reply_to_id, created_at DESC, id DESC)
+    WHERE reply_to_id IS NOT NULL;
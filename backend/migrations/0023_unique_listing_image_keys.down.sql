@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS uniq_listing_images_s3_key;
+CREATE INDEX IF NOT EXISTS idx_listing_images_s3_key ON listing_images(s3_key);
@@ -0,0 +1,3 @@
+-- an object backs at most one image, the unique index also serves the object garbage collector lookups
+DROP INDEX IF EXISTS idx_listing_images_s3_key;
+CREATE UNIQUE INDEX IF NOT EXISTS uniq_listing_images_s3_key ON listing_images(s3_key);
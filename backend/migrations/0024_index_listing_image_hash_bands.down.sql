@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_listing_images_phash_band3;
+DROP INDEX IF EXISTS idx_listing_images_phash_band2;
+DROP INDEX IF EXISTS idx_listing_images_phash_band1;
+DROP INDEX IF EXISTS idx_listing_images_phash_band0;
+ALTER TABLE listing_images
+    DROP COLUMN IF EXISTS phash_band3,
+    DROP COLUMN IF EXISTS phash_band2,
+    DROP COLUMN IF EXISTS phash_band1,
+    DROP COLUMN IF EXISTS phash_band0;
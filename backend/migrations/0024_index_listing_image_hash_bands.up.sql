@@ -0,0 +1,15 @@
+-- Requires PostgreSQL 14 or newer: duplicate photo matching computes distances with bit_count.
+--
+-- The 64 bit photo hash split into four 16 bit bands, most significant first. Two hashes within 7 bits
+-- of each other differ in at most one bit in at least one band, so looking up each band and its 16
+-- single bit flips finds every candidate through these indexes instead of scanning all images
+ALTER TABLE listing_images
+    ADD COLUMN IF NOT EXISTS phash_band0 integer GENERATED ALWAYS AS (((phash >> 48) & 65535)::integer) STORED,
+    ADD COLUMN IF NOT EXISTS phash_band1 integer GENERATED ALWAYS AS (((phash >> 32) & 65535)::integer) STORED,
+    ADD COLUMN IF NOT EXISTS phash_band2 integer GENERATED ALWAYS AS (((phash >> 16) & 65535)::integer) STORED,
+    ADD COLUMN IF NOT EXISTS phash_band3 integer GENERATED ALWAYS AS ((phash & 65535)::integer) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_listing_images_phash_band0 ON listing_images (phash_band0) WHERE phash IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_listing_images_phash_band1 ON listing_images (phash_band1) WHERE phash IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_listing_images_phash_band2 ON listing_images (phash_band2) WHERE phash IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_listing_images_phash_band3 ON listing_images (phash_band3) WHERE phash IS NOT NULL;
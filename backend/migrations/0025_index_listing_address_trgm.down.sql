@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_listings_address_line1_trgm;
@@ -0,0 +1,2 @@
+-- the typo tolerant search fallback matches the street with <%, like the title and the city
+CREATE INDEX IF NOT EXISTS idx_listings_address_line1_trgm ON listings USING gin (address_line1 gin_trgm_ops);
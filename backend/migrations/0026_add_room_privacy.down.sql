@@ -0,0 +1,2 @@
+ALTER TABLE rooms
+    DROP COLUMN IF EXISTS is_private;
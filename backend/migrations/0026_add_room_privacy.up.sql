@@ -0,0 +1,7 @@
+-- private rooms only take members added by the server, contact request rooms are private
+ALTER TABLE rooms
+    ADD COLUMN IF NOT EXISTS is_private boolean NOT NULL DEFAULT false;
+
+UPDATE rooms r
+SET is_private = true
+WHERE EXISTS (SELECT 1 FROM contact_requests cr WHERE cr.room_id = r.id);
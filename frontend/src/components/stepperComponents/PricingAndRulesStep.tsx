@@ -37,8 +37,7 @@ export const PricingAndRulesStep = ({ formData, setFormData }: PricingAndRulesSt
     ]
     const statusOptions: StatusOption[] = [
         { value: "draft", label: "Draft" },
-        { value: "active", label: "Active" },
-        { value: "inactive", label: "Inactive" }
+        { value: "active", label: "Active" }
     ]
 
     const handlePriceChange = (price: number | null) => {